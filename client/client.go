@@ -1,11 +1,15 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"path"
 	"runtime"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/jslyzt/goconfig/config"
 )
@@ -21,7 +25,15 @@ var (
 type FdfsClient struct {
 	tracker     *Tracker
 	trackerPool *ConnectionPool
-	timeout     int
+	config      *ClientConfig
+	httpConf    *HTTPConf
+	retryPolicy *RetryPolicy
+	metrics     Metrics
+	tracer      Tracer
+	logger      *levelLogger
+	wireDump    *wireDump
+	// storagePools 本客户端用过的 storage 连接池, 用于 PoolStats
+	storagePools sync.Map
 }
 
 // Tracker 追踪
@@ -36,6 +48,10 @@ type storagePool struct {
 	port           int
 	minConns       int
 	maxConns       int
+	connectTimeout time.Duration
+	networkTimeout time.Duration
+	// hooks 只用于新建连接池时的日志
+	hooks *connHooks
 }
 
 func initvar() {
@@ -59,7 +75,8 @@ func init() {
 						sp  *ConnectionPool
 						err error
 					)
-					sp, err = NewConnectionPool(spd.hosts, spd.port, spd.minConns, spd.maxConns)
+					sp, err = newConnectionPool(spd.hosts, spd.port, spd.minConns, spd.maxConns,
+						spd.connectTimeout, spd.networkTimeout, spd.hooks)
 					if err != nil {
 						fetchStoragePoolChan <- err
 					} else {
@@ -74,71 +91,75 @@ func init() {
 	}()
 }
 
-// GetTrackerConf 解析 tacker
-func GetTrackerConf(confPath, confData string) (*Tracker, error) {
-	var cf *config.Config
-	var err error
+func readConf(confPath, confData string) (*config.Config, error) {
+	var (
+		cf  *config.Config
+		err error
+	)
 	fc := &FdfsConfigParser{}
 	if len(confData) > 0 {
 		cf, err = fc.ReadData(confData)
-	} else {
+	} else if len(confPath) > 0 {
 		cf, err = fc.ReadFile(confPath)
 	}
 	if err != nil {
 		return nil, err
 	}
 	if cf == nil {
-		return nil, nil
+		cf = config.NewDefault()
 	}
+	applyEnvOverrides(cf)
+	return cf, nil
+}
 
-	trackerListString, _ := cf.RawString("DEFAULT", "tracker_server")
-	trackerList := strings.Split(trackerListString, ",")
-
-	trackerIPList := make([]string, 0)
-	trackerPort := "22122"
-
-	for _, tr := range trackerList {
-		tr = strings.TrimSpace(tr)
-		parts := strings.Split(tr, ":")
-		trackerIP := parts[0]
-		if len(parts) == 2 {
-			trackerPort = parts[1]
-		}
-		if trackerIP != "" {
-			trackerIPList = append(trackerIPList, trackerIP)
-		}
-	}
-	tp, err := strconv.Atoi(trackerPort)
-	tracer := &Tracker{
-		HostList: trackerIPList,
-		Port:     tp,
+// GetTrackerConf 解析 tacker
+func GetTrackerConf(confPath, confData string) (*Tracker, error) {
+	cfg, err := LoadClientConfig(confPath, confData)
+	if err != nil {
+		return nil, err
 	}
-	return tracer, nil
+	return cfg.Tracker(), nil
 }
 
 // NewFdfsClient 新fastdfs客户端
 func NewFdfsClient(confPath string) (*FdfsClient, error) {
-	tracker, err := GetTrackerConf(confPath, "")
+	cfg, err := LoadClientConfig(confPath, "")
 	if err != nil {
 		return nil, err
 	}
+	return NewFdfsClientByConfig(cfg)
+}
 
-	trackerPool, err := NewConnectionPool(tracker.HostList, tracker.Port, 10, 150)
+// NewFdfsClientByConfig 根据配置新建fastdfs客户端
+func NewFdfsClientByConfig(cfg *ClientConfig) (*FdfsClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	tracker := cfg.Tracker()
+	logger := defaultLogger(cfg)
+	trackerPool, err := newConnectionPool(tracker.HostList, tracker.Port, cfg.MinConns, cfg.MaxConns,
+		cfg.ConnectTimeout, cfg.NetworkTimeout, &connHooks{logger: logger})
 	if err != nil {
 		return nil, err
 	}
 
-	return &FdfsClient{tracker: tracker, trackerPool: trackerPool}, nil
+	httpConf := cfg.HTTP
+	return &FdfsClient{tracker: tracker, trackerPool: trackerPool, config: cfg, httpConf: &httpConf, logger: logger}, nil
 }
 
-// NewFdfsClientByTracker 新fastdfs客户端
+// NewFdfsClientByTracker 新fastdfs客户端, 其他配置使用默认值, 与配置文件一样校验
+// HostList 中没有端口的地址使用 Port
 func NewFdfsClientByTracker(tracker *Tracker) (*FdfsClient, error) {
-	trackerPool, err := NewConnectionPool(tracker.HostList, tracker.Port, 10, 150)
-	if err != nil {
-		return nil, err
+	cfg := NewClientConfig()
+	if tracker != nil {
+		for _, host := range tracker.HostList {
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				host = net.JoinHostPort(host, strconv.Itoa(tracker.Port))
+			}
+			cfg.TrackerServers = append(cfg.TrackerServers, host)
+		}
 	}
-
-	return &FdfsClient{tracker: tracker, trackerPool: trackerPool}, nil
+	return NewFdfsClientByConfig(cfg)
 }
 
 // ColseFdfsClient 关闭客户端
@@ -146,168 +167,536 @@ func ColseFdfsClient() {
 	quit <- true
 }
 
-func (client *FdfsClient) getUploadArg(gname ...string) (tc *TrackerClient, srv *StorageServer, store *StorageClient, err error){
-	tc = &TrackerClient{client.trackerPool}
-	if len(gname) <= 0 {
-		srv, err = tc.trackerQueryStorageStorWithoutGroup()
-	} else {
-		srv, err = tc.trackerQueryStorageStorWithGroup(gname[0])
+func (client *FdfsClient) getUploadArg(op *opTrace, gname ...string) (tc *TrackerClient, srv *StorageServer, store *StorageClient, err error) {
+	tc = &TrackerClient{pool: client.trackerPool, hooks: op.hooks()}
+	err = op.retry(func() (err error) {
+		span := op.startTracker("query_store")
+		if len(gname) <= 0 || len(gname[0]) == 0 {
+			srv, err = tc.trackerQueryStorageStorWithoutGroup()
+		} else {
+			srv, err = tc.trackerQueryStorageStorWithGroup(gname[0])
+		}
+		op.endTracker(span, srv, err)
+		return
+	})
+	if err != nil {
+		return
 	}
+	store, err = client.getStorageClient(op, srv)
+	return
+}
+
+func (client *FdfsClient) getUpdateArg(op *opTrace, groupName, remoteFilename string) (tc *TrackerClient, srv *StorageServer, store *StorageClient, err error) {
+	tc = &TrackerClient{pool: client.trackerPool, hooks: op.hooks()}
+	err = op.retry(func() (err error) {
+		span := op.startTracker("query_update")
+		srv, err = tc.trackerQueryStorageUpdate(groupName, remoteFilename)
+		op.endTracker(span, srv, err)
+		return
+	})
 	if err != nil {
 		return
 	}
-	var storagePool *ConnectionPool
-	storagePool, err = client.getStoragePool(srv.ipAddr, srv.port)
+	store, err = client.getStorageClient(op, srv)
+	return
+}
+
+// getFetchArg 不单独重试 tracker 查询, 由调用方整体重试以便重新选择存储服务器
+func (client *FdfsClient) getFetchArg(op *opTrace, groupName, remoteFilename string) (tc *TrackerClient, srv *StorageServer, store *StorageClient, err error) {
+	tc = &TrackerClient{pool: client.trackerPool, hooks: op.hooks()}
+	span := op.startTracker("query_fetch")
+	srv, err = tc.trackerQueryStorageFetch(groupName, remoteFilename)
+	op.endTracker(span, srv, err)
 	if err != nil {
 		return
 	}
-	store = &StorageClient{storagePool}
+	store, err = client.getStorageClient(op, srv)
 	return
 }
 
+// getStorageClient 获取存储服务器的连接池, 并开始存储服务器的追踪
+func (client *FdfsClient) getStorageClient(op *opTrace, srv *StorageServer) (*StorageClient, error) {
+	storagePool, err := client.getStoragePool(srv.ipAddr, srv.port)
+	if err != nil {
+		return nil, err
+	}
+	op.startStorage(srv)
+	return &StorageClient{pool: storagePool, hooks: op.hooks()}, nil
+}
+
+// hooks 本客户端的指标, 日志和协议调试输出, 随取出的连接传递
+func (client *FdfsClient) hooks() *connHooks {
+	return &connHooks{metrics: client.metrics, logger: client.logger, wireDump: client.wireDump}
+}
+
+// hooks 本次操作的连接 hooks, 带上操作的 context
+func (op *opTrace) hooks() *connHooks {
+	hooks := op.client.hooks()
+	hooks.ctx = op.ctx
+	return hooks
+}
+
+func (client *FdfsClient) trackerClient() *TrackerClient {
+	return &TrackerClient{pool: client.trackerPool, hooks: client.hooks()}
+}
+
 // UploadByFilename 上传文件
-func (client *FdfsClient) UploadByFilename(filename string) (*UploadFileResponse, error) {
+func (client *FdfsClient) UploadByFilename(filename string, groupName ...string) (*UploadFileResponse, error) {
+	op := client.startOp("upload", "")
 	if err := fdfsCheckFile(filename); err != nil {
-		return nil, errors.New(err.Error() + "(uploading)")
+		return nil, op.end(nil, err)
 	}
-	tc, srv, store, err := client.getUploadArg()
+	op.setFileBytes(filename)
+	tc, srv, store, err := client.getUploadArg(op, groupName...)
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
 	}
-	return store.storageUploadByFilename(tc, srv, filename)
+	resp, err := store.storageUploadByFilename(tc, srv, filename)
+	return resp, op.end(srv, err)
 }
 
 // UploadByBuffer 上传数据
-func (client *FdfsClient) UploadByBuffer(filebuffer []byte, fileExtName string) (*UploadFileResponse, error) {
-	tc, srv, store, err := client.getUploadArg()
+func (client *FdfsClient) UploadByBuffer(filebuffer []byte, fileExtName string, groupName ...string) (*UploadFileResponse, error) {
+	op := client.startOp("upload", "")
+	op.setBytes(int64(len(filebuffer)))
+	tc, srv, store, err := client.getUploadArg(op, groupName...)
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
 	}
-	return store.storageUploadByBuffer(tc, srv, filebuffer, fileExtName)
+	resp, err := store.storageUploadByBuffer(tc, srv, filebuffer, fileExtName)
+	return resp, op.end(srv, err)
 }
 
 // UploadByStream 上传流
-func (client *FdfsClient) UploadByStream(stream ReadStream, size int64, fileExtName string) (*UploadFileResponse, error) {
-	tc, srv, store, err := client.getUploadArg()
+func (client *FdfsClient) UploadByStream(stream ReadStream, size int64, fileExtName string, groupName ...string) (*UploadFileResponse, error) {
+	op := client.startOp("upload", "")
+	op.setBytes(size)
+	tc, srv, store, err := client.getUploadArg(op, groupName...)
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
 	}
-	return store.storageUploadByStream(tc, srv, stream, fileExtName, size)
+	resp, err := store.storageUploadByStream(tc, srv, stream, fileExtName, size)
+	return resp, op.end(srv, err)
 }
 
-// UploadSlaveByFilename 上传从文件
-func (client *FdfsClient) UploadSlaveByFilename(filename, remoteFileID, prefixName string) (*UploadFileResponse, error) {
-	if err := fdfsCheckFile(filename); err != nil {
-		return nil, errors.New(err.Error() + "(uploading)")
+// SlaveUploadRequest 从文件上传请求, 从文件名为主文件名加上前缀名和扩展名, 例如缩略图
+type SlaveUploadRequest struct {
+	// MasterFileID 主文件ID
+	MasterFileID string
+	// PrefixName 前缀名, 例如 _150x150, 不能为空, 最长 FDFS_FILE_PREFIX_MAX_LEN
+	PrefixName string
+	// Ext 扩展名, 为空时使用本地文件名或主文件的扩展名
+	Ext string
+	// Source 本地文件名(string), 数据([]byte) 或 ReadStream
+	Source interface{}
+	// Size Source 为 ReadStream 时的大小, 小于等于0时通过 Seek 获取
+	Size int64
+}
+
+// Validate 校验请求
+func (req *SlaveUploadRequest) Validate() error {
+	if _, err := splitRemoteFileID(req.MasterFileID); err != nil {
+		return err
 	}
-	tmp, err := splitRemoteFileID(remoteFileID)
-	if err != nil || len(tmp) != 2 {
-		return nil, err
+	if len(req.PrefixName) == 0 || len(req.PrefixName) > FDFS_FILE_PREFIX_MAX_LEN {
+		return fmt.Errorf("%w: prefix name [%s] length must be 1-%d", ErrInvalidArgument, req.PrefixName, FDFS_FILE_PREFIX_MAX_LEN)
 	}
-	tc, srv, store, err := client.getUploadArg(tmp[0])
-	if err != nil {
-		return nil, err
+	if len(req.Ext) > FDFS_FILE_EXT_NAME_MAX_LEN {
+		return fmt.Errorf("%w: ext name [%s] longer than %d", ErrInvalidArgument, req.Ext, FDFS_FILE_EXT_NAME_MAX_LEN)
+	}
+	switch source := req.Source.(type) {
+	case string:
+		return fdfsCheckFile(source)
+	case []byte, ReadStream:
+	default:
+		return fmt.Errorf("%w: unsupported source type %T", ErrInvalidArgument, req.Source)
 	}
-	return store.storageUploadSlaveByFilename(tc, srv, filename, prefixName, tmp[1])
+	return nil
 }
 
-// UploadSlaveByBuffer 上传从数据
-func (client *FdfsClient) UploadSlaveByBuffer(filebuffer []byte, remoteFileID, fileExtName string) (*UploadFileResponse, error) {
-	tmp, err := splitRemoteFileID(remoteFileID)
-	if err != nil || len(tmp) != 2 {
-		return nil, err
+// UploadSlave 上传从文件, 通过 update 查询上传到主文件所在的源存储服务器
+func (client *FdfsClient) UploadSlave(req *SlaveUploadRequest) (*UploadFileResponse, error) {
+	op := client.startOp("upload_slave", req.MasterFileID)
+	if err := req.Validate(); err != nil {
+		return nil, op.end(nil, err)
+	}
+	switch source := req.Source.(type) {
+	case string:
+		op.setFileBytes(source)
+	case []byte:
+		op.setBytes(int64(len(source)))
+	default:
+		op.setBytes(req.Size)
+	}
+	slave := *req
+	if len(slave.Ext) == 0 {
+		if filename, ok := slave.Source.(string); ok {
+			slave.Ext = getFileExt(filename)
+		}
+		if len(slave.Ext) == 0 || len(slave.Ext) > FDFS_FILE_EXT_NAME_MAX_LEN {
+			slave.Ext = getFileExt(path.Base(req.MasterFileID))
+		}
 	}
-	tc, srv, store, err := client.getUploadArg(tmp[0])
+
+	tmp, _ := splitRemoteFileID(req.MasterFileID)
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
 	}
-	return store.storageUploadSlaveByBuffer(tc, srv, filebuffer, tmp[1], fileExtName)
+	resp, err := store.storageUploadSlave(tc, srv, &slave, tmp[1])
+	return resp, op.end(srv, err)
+}
+
+// UploadSlaveByFilename 上传从文件, 扩展名使用本地文件名的扩展名
+func (client *FdfsClient) UploadSlaveByFilename(filename, remoteFileID, prefixName string) (*UploadFileResponse, error) {
+	return client.UploadSlave(&SlaveUploadRequest{MasterFileID: remoteFileID, PrefixName: prefixName, Source: filename})
+}
+
+// UploadSlaveByBuffer 上传从数据
+func (client *FdfsClient) UploadSlaveByBuffer(filebuffer []byte, remoteFileID, prefixName, fileExtName string) (*UploadFileResponse, error) {
+	return client.UploadSlave(&SlaveUploadRequest{MasterFileID: remoteFileID, PrefixName: prefixName,
+		Ext: fileExtName, Source: filebuffer})
 }
 
 // UploadSlaveByStream 上传从流
-func (client *FdfsClient) UploadSlaveByStream(stream ReadStream, size int64, remoteFileID, fileExtName string) (*UploadFileResponse, error) {
-	tmp, err := splitRemoteFileID(remoteFileID)
-	if err != nil || len(tmp) != 2 {
-		return nil, err
+func (client *FdfsClient) UploadSlaveByStream(stream ReadStream, size int64, remoteFileID, prefixName, fileExtName string) (*UploadFileResponse, error) {
+	return client.UploadSlave(&SlaveUploadRequest{MasterFileID: remoteFileID, PrefixName: prefixName,
+		Ext: fileExtName, Source: stream, Size: size})
+}
+
+// LinkRequest 创建链接请求, 新文件ID与源文件共用同一份内容
+type LinkRequest struct {
+	// SourceFileID 源文件ID, 链接创建在源文件所在的存储服务器上
+	SourceFileID string
+	// MasterFileID 主文件ID, 不为空时按从文件命名(主文件名 + PrefixName + Ext), 需要与源文件同组
+	MasterFileID string
+	// PrefixName 从文件前缀名, MasterFileID 不为空时必须设置
+	PrefixName string
+	// Ext 扩展名, 为空时使用源文件的扩展名
+	Ext string
+	// Signature 源文件签名, 可以为空
+	Signature []byte
+}
+
+// Validate 校验请求
+func (req *LinkRequest) Validate() error {
+	src, err := splitRemoteFileID(req.SourceFileID)
+	if err != nil {
+		return err
+	}
+	if len(req.MasterFileID) > 0 {
+		master, err := splitRemoteFileID(req.MasterFileID)
+		if err != nil {
+			return err
+		}
+		if master[0] != src[0] {
+			return fmt.Errorf("%w: master [%s] and source [%s] not in the same group", ErrInvalidArgument,
+				req.MasterFileID, req.SourceFileID)
+		}
+		if len(req.PrefixName) == 0 {
+			return fmt.Errorf("%w: prefix name is required with master file", ErrInvalidArgument)
+		}
+	}
+	if len(req.PrefixName) > FDFS_FILE_PREFIX_MAX_LEN {
+		return fmt.Errorf("%w: prefix name [%s] longer than %d", ErrInvalidArgument, req.PrefixName, FDFS_FILE_PREFIX_MAX_LEN)
 	}
-	tc, srv, store, err := client.getUploadArg(tmp[0])
+	if len(req.Ext) > FDFS_FILE_EXT_NAME_MAX_LEN {
+		return fmt.Errorf("%w: ext name [%s] longer than %d", ErrInvalidArgument, req.Ext, FDFS_FILE_EXT_NAME_MAX_LEN)
+	}
+	return nil
+}
+
+// CreateLink 创建链接文件, 不复制文件内容
+func (client *FdfsClient) CreateLink(req *LinkRequest) (*UploadFileResponse, error) {
+	op := client.startOp("create_link", req.SourceFileID)
+	if err := req.Validate(); err != nil {
+		return nil, op.end(nil, err)
+	}
+	src, _ := splitRemoteFileID(req.SourceFileID)
+	var masterFilename string
+	if len(req.MasterFileID) > 0 {
+		master, _ := splitRemoteFileID(req.MasterFileID)
+		masterFilename = master[1]
+	}
+	ext := req.Ext
+	if len(ext) == 0 {
+		if ext = getFileExt(path.Base(src[1])); len(ext) > FDFS_FILE_EXT_NAME_MAX_LEN {
+			ext = ""
+		}
+	}
+
+	tc, srv, store, err := client.getUpdateArg(op, src[0], src[1])
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
 	}
-	return store.storageUploadSlaveByStream(tc, srv, stream, tmp[1], fileExtName, size)
+	resp, err := store.storageCreateLink(tc, srv, src[1], masterFilename, req.PrefixName, ext, req.Signature)
+	return resp, op.end(srv, err)
 }
 
 // UploadAppenderByFilename 追加文件
-func (client *FdfsClient) UploadAppenderByFilename(filename string) (*UploadFileResponse, error) {
+func (client *FdfsClient) UploadAppenderByFilename(filename string, groupName ...string) (*UploadFileResponse, error) {
+	op := client.startOp("upload_appender", "")
 	if err := fdfsCheckFile(filename); err != nil {
-		return nil, errors.New(err.Error() + "(uploading)")
+		return nil, op.end(nil, err)
 	}
-	tc, srv, store, err := client.getUploadArg()
+	op.setFileBytes(filename)
+	tc, srv, store, err := client.getUploadArg(op, groupName...)
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
 	}
-	return store.storageUploadAppenderByFilename(tc, srv, filename)
+	resp, err := store.storageUploadAppenderByFilename(tc, srv, filename)
+	return resp, op.end(srv, err)
 }
 
 // UploadAppenderByBuffer 追加数据
-func (client *FdfsClient) UploadAppenderByBuffer(filebuffer []byte, fileExtName string) (*UploadFileResponse, error) {
-	tc, srv, store, err := client.getUploadArg()
+func (client *FdfsClient) UploadAppenderByBuffer(filebuffer []byte, fileExtName string, groupName ...string) (*UploadFileResponse, error) {
+	op := client.startOp("upload_appender", "")
+	op.setBytes(int64(len(filebuffer)))
+	tc, srv, store, err := client.getUploadArg(op, groupName...)
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
 	}
-	return store.storageUploadAppenderByBuffer(tc, srv, filebuffer, fileExtName)
+	resp, err := store.storageUploadAppenderByBuffer(tc, srv, filebuffer, fileExtName)
+	return resp, op.end(srv, err)
 }
 
 // UploadAppenderByStream 追加流
-func (client *FdfsClient) UploadAppenderByStream(stream ReadStream, size int64, fileExtName string) (*UploadFileResponse, error) {
-	tc, srv, store, err := client.getUploadArg()
+func (client *FdfsClient) UploadAppenderByStream(stream ReadStream, size int64, fileExtName string, groupName ...string) (*UploadFileResponse, error) {
+	op := client.startOp("upload_appender", "")
+	op.setBytes(size)
+	tc, srv, store, err := client.getUploadArg(op, groupName...)
 	if err != nil {
-		return nil, err
+		return nil, op.end(srv, err)
+	}
+	resp, err := store.storageUploadAppenderByStream(tc, srv, stream, fileExtName, size)
+	return resp, op.end(srv, err)
+}
+
+// AppendByBuffer 追加数据到appender文件
+func (client *FdfsClient) AppendByBuffer(filebuffer []byte, appenderFileID string) error {
+	tmp, err := splitRemoteFileID(appenderFileID)
+	if err != nil {
+		return err
+	}
+	op := client.startOp("append", appenderFileID)
+	op.setBytes(int64(len(filebuffer)))
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
+	if err != nil {
+		return op.end(srv, err)
+	}
+	return op.end(srv, store.storageAppendByBuffer(tc, srv, filebuffer, tmp[1]))
+}
+
+// AppendByStream 追加流到appender文件
+func (client *FdfsClient) AppendByStream(stream ReadStream, size int64, appenderFileID string) error {
+	tmp, err := splitRemoteFileID(appenderFileID)
+	if err != nil {
+		return err
+	}
+	op := client.startOp("append", appenderFileID)
+	op.setBytes(size)
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
+	if err != nil {
+		return op.end(srv, err)
+	}
+	return op.end(srv, store.storageAppendByStream(tc, srv, stream, size, tmp[1]))
+}
+
+// ModifyByBuffer 从offset处覆盖appender文件内容
+func (client *FdfsClient) ModifyByBuffer(filebuffer []byte, offset int64, appenderFileID string) error {
+	tmp, err := splitRemoteFileID(appenderFileID)
+	if err != nil {
+		return err
+	}
+	op := client.startOp("modify", appenderFileID)
+	op.setBytes(int64(len(filebuffer)))
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
+	if err != nil {
+		return op.end(srv, err)
+	}
+	return op.end(srv, store.storageModifyByBuffer(tc, srv, filebuffer, offset, tmp[1]))
+}
+
+// ModifyByStream 从offset处用流覆盖appender文件内容
+func (client *FdfsClient) ModifyByStream(stream ReadStream, size int64, offset int64, appenderFileID string) error {
+	tmp, err := splitRemoteFileID(appenderFileID)
+	if err != nil {
+		return err
+	}
+	op := client.startOp("modify", appenderFileID)
+	op.setBytes(size)
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
+	if err != nil {
+		return op.end(srv, err)
+	}
+	return op.end(srv, store.storageModifyByStream(tc, srv, stream, size, offset, tmp[1]))
+}
+
+// TruncateFile 截断appender文件
+func (client *FdfsClient) TruncateFile(appenderFileID string, truncatedFileSize int64) error {
+	tmp, err := splitRemoteFileID(appenderFileID)
+	if err != nil {
+		return err
+	}
+	op := client.startOp("truncate", appenderFileID)
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
+	if err != nil {
+		return op.end(srv, err)
 	}
-	return store.storageUploadAppenderByStream(tc, srv, stream, fileExtName, size)
+	return op.end(srv, store.storageTruncateFile(tc, srv, truncatedFileSize, tmp[1]))
 }
 
 // DeleteFile 删除文件
 func (client *FdfsClient) DeleteFile(remoteFileID string) error {
 	tmp, err := splitRemoteFileID(remoteFileID)
-	if err != nil || len(tmp) != 2 {
+	if err != nil {
 		return err
 	}
-	tc, srv, store, err := client.getUploadArg(tmp[0])
+	op := client.startOp("delete", remoteFileID)
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
 	if err != nil {
-		return err
+		return op.end(srv, err)
 	}
-	return store.storageDeleteFile(tc, srv, tmp[1])
+	return op.end(srv, store.storageDeleteFile(tc, srv, tmp[1]))
 }
 
 // DownloadToFile 下载文件
 func (client *FdfsClient) DownloadToFile(localFilename string, remoteFileID string, offset int64, downloadSize int64) (*DownloadFileResponse, error) {
 	tmp, err := splitRemoteFileID(remoteFileID)
-	if err != nil || len(tmp) != 2 {
-		return nil, err
-	}
-	tc, srv, store, err := client.getUploadArg(tmp[0])
 	if err != nil {
 		return nil, err
 	}
-	return store.storageDownloadToFile(tc, srv, localFilename, offset, downloadSize, tmp[1])
+	var resp *DownloadFileResponse
+	err = client.retry(func() error {
+		op := client.startOp("download", remoteFileID)
+		tc, srv, store, err := client.getFetchArg(op, tmp[0], tmp[1])
+		if err != nil {
+			return op.end(srv, err)
+		}
+		resp, err = store.storageDownloadToFile(tc, srv, localFilename, offset, downloadSize, tmp[1])
+		if resp != nil {
+			op.setBytes(resp.DownloadSize)
+		}
+		return op.end(srv, err)
+	})
+	return resp, err
 }
 
 // DownloadToBuffer 下载文件
 func (client *FdfsClient) DownloadToBuffer(remoteFileID string, offset int64, downloadSize int64) (*DownloadFileResponse, error) {
+	return client.downloadToBuffer(context.Background(), remoteFileID, offset, downloadSize)
+}
+
+// downloadToBuffer 下载文件, ctx 取消或超时后中断
+func (client *FdfsClient) downloadToBuffer(ctx context.Context, remoteFileID string, offset int64, downloadSize int64) (*DownloadFileResponse, error) {
 	tmp, err := splitRemoteFileID(remoteFileID)
-	if err != nil || len(tmp) != 2 {
+	if err != nil {
+		return nil, err
+	}
+	var resp *DownloadFileResponse
+	err = client.retry(func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		op := client.startOp("download", remoteFileID)
+		op.ctx = ctx
+		tc, srv, store, err := client.getFetchArg(op, tmp[0], tmp[1])
+		if err != nil {
+			return op.end(srv, err)
+		}
+		var fileBuffer []byte
+		resp, err = store.storageDownloadToBuffer(tc, srv, fileBuffer, offset, downloadSize, tmp[1])
+		if resp != nil {
+			op.setBytes(resp.DownloadSize)
+		}
+		return op.end(srv, err)
+	})
+	return resp, err
+}
+
+// QueryFileInfo 查询文件信息
+func (client *FdfsClient) QueryFileInfo(remoteFileID string) (*FileInfo, error) {
+	return client.queryFileInfo(context.Background(), remoteFileID)
+}
+
+// queryFileInfo 查询文件信息, ctx 取消或超时后中断
+func (client *FdfsClient) queryFileInfo(ctx context.Context, remoteFileID string) (*FileInfo, error) {
+	tmp, err := splitRemoteFileID(remoteFileID)
+	if err != nil {
 		return nil, err
 	}
-	tc, srv, store, err := client.getUploadArg(tmp[0])
+	var resp *FileInfo
+	err = client.retry(func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		op := client.startOp("query_file_info", remoteFileID)
+		op.ctx = ctx
+		tc, srv, store, err := client.getFetchArg(op, tmp[0], tmp[1])
+		if err != nil {
+			return op.end(srv, err)
+		}
+		resp, err = store.storageQueryFileInfo(tc, srv, tmp[1])
+		return op.end(srv, err)
+	})
+	return resp, err
+}
+
+// SetMetadata 设置元数据, opFlag 为 STORAGE_SET_METADATA_FLAG_OVERWRITE 或 STORAGE_SET_METADATA_FLAG_MERGE
+func (client *FdfsClient) SetMetadata(remoteFileID string, meta map[string]string, opFlag byte) error {
+	tmp, err := splitRemoteFileID(remoteFileID)
+	if err != nil {
+		return err
+	}
+	op := client.startOp("set_metadata", remoteFileID)
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
+	if err != nil {
+		return op.end(srv, err)
+	}
+	return op.end(srv, store.storageSetMetadata(tc, srv, tmp[1], meta, opFlag))
+}
+
+// GetMetadata 获取元数据
+func (client *FdfsClient) GetMetadata(remoteFileID string) (map[string]string, error) {
+	tmp, err := splitRemoteFileID(remoteFileID)
 	if err != nil {
 		return nil, err
 	}
-	var fileBuffer []byte
-	return store.storageDownloadToBuffer(tc, srv, fileBuffer, offset, downloadSize, tmp[1])
+	var resp map[string]string
+	err = client.retry(func() error {
+		op := client.startOp("get_metadata", remoteFileID)
+		tc, srv, store, err := client.getFetchArg(op, tmp[0], tmp[1])
+		if err != nil {
+			return op.end(srv, err)
+		}
+		resp, err = store.storageGetMetadata(tc, srv, tmp[1])
+		return op.end(srv, err)
+	})
+	return resp, err
+}
+
+func opError(op, fileID string, srv *StorageServer, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*OpError); ok {
+		if len(e.FileID) == 0 {
+			e.FileID = fileID
+		}
+		return e
+	}
+	e := &OpError{Op: op, FileID: fileID, Err: err}
+	if srv != nil {
+		e.Addr = net.JoinHostPort(srv.ipAddr, strconv.Itoa(srv.port))
+	}
+	return e
 }
 
 func (client *FdfsClient) getStoragePool(ipAddr string, port int) (*ConnectionPool, error) {
+	cfg := client.config
+	if cfg == nil {
+		cfg = NewClientConfig()
+	}
 	hosts := []string{ipAddr}
 	storagePoolKey := fmt.Sprintf("%s-%d", ipAddr, port)
 	var (
@@ -320,8 +709,11 @@ func (client *FdfsClient) getStoragePool(ipAddr string, port int) (*ConnectionPo
 		storagePoolKey: storagePoolKey,
 		hosts:          hosts,
 		port:           port,
-		minConns:       10,
-		maxConns:       150,
+		minConns:       cfg.MinConns,
+		maxConns:       cfg.MaxConns,
+		connectTimeout: cfg.ConnectTimeout,
+		networkTimeout: cfg.NetworkTimeout,
+		hooks:          client.hooks(),
 	}
 
 	storagePoolChan <- spd
@@ -332,6 +724,7 @@ func (client *FdfsClient) getStoragePool(ipAddr string, port int) (*ConnectionPo
 			if err, ok = result.(error); ok {
 				return nil, err
 			} else if storagePool, ok = result.(*ConnectionPool); ok {
+				client.storagePools.Store(storagePoolKey, storagePool)
 				return storagePool, nil
 			} else {
 				return nil, errors.New("none")
@@ -1,231 +1,336 @@
 package client
 
 import (
-	"fmt"
+	"bytes"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
-)
 
-var (
-	uploadResponse *UploadFileResponse
+	"github.com/lerryxiao/fdfs_client/fdfstest"
 )
 
+func newTestClient(tb testing.TB, options ...func(cfg *ClientConfig)) (*FdfsClient, *fdfstest.Server) {
+	server, err := fdfstest.NewServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	cfg, err := LoadClientConfig("", server.ConfigData())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cfg.MinConns = 1
+	for _, option := range options {
+		option(cfg)
+	}
+	fdfsClient, err := NewFdfsClientByConfig(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return fdfsClient, server
+}
+
+func writeTestFile(tb testing.TB, name string, content []byte) string {
+	filename := filepath.Join(tb.TempDir(), name)
+	if err := os.WriteFile(filename, content, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return filename
+}
+
 func TestNewFdfsClientByTracker(t *testing.T) {
+	_, server := newTestClient(t)
 	tracker := &Tracker{
-		[]string{"10.0.1.32"},
-		22122,
+		[]string{server.TrackerHost()},
+		server.TrackerPort(),
 	}
-	_, err := NewFdfsClientByTracker(tracker)
+	fdfsClient, err := NewFdfsClientByTracker(tracker)
 	if err != nil {
+		t.Fatal(err)
+	}
+	if fdfsClient.httpConf == nil || fdfsClient.httpConf.TrackerServerPort != defaultHTTPPort {
+		t.Errorf("expect default http conf, actual %+v", fdfsClient.httpConf)
+	}
+	if _, err = fdfsClient.UploadByBuffer([]byte("hello"), "txt"); err != nil {
 		t.Error(err)
 	}
+
+	for _, tracker := range []*Tracker{
+		nil,
+		{},
+		{HostList: []string{server.TrackerHost()}},
+		{HostList: []string{server.TrackerHost()}, Port: 70000},
+		{HostList: []string{server.TrackerHost() + ":abc"}, Port: server.TrackerPort()},
+	} {
+		var ce *ConfigError
+		if _, err = NewFdfsClientByTracker(tracker); !errors.As(err, &ce) {
+			t.Errorf("%+v: expect ConfigError, actual %v", tracker, err)
+		}
+	}
 }
 
 func TestUploadByFilename(t *testing.T) {
-	fdfsClient, err := NewFdfsClient("client.conf")
+	fdfsClient, server := newTestClient(t)
+	filename := writeTestFile(t, "client.conf", []byte(server.ConfigData()))
+
+	uploadResponse, err := fdfsClient.UploadByFilename(filename)
 	if err != nil {
-		t.Errorf("New FdfsClient error %s", err.Error())
-		return
+		t.Fatalf("UploadByfilename error %v", err)
+	}
+	if uploadResponse.GroupName != fdfstest.DefaultGroupName || !strings.HasSuffix(uploadResponse.RemoteFileID, ".conf") {
+		t.Errorf("unexpected response %+v", uploadResponse)
+	}
+	if content, ok := server.File(uploadResponse.RemoteFileID); !ok || string(content) != server.ConfigData() {
+		t.Errorf("unexpected content %q", content)
 	}
 
-	uploadResponse, err = fdfsClient.UploadByFilename("client.conf")
-	if err != nil || uploadResponse == nil {
-		t.Errorf("UploadByfilename error %v", err)
-	} else {
-		t.Log(uploadResponse.GroupName)
-		t.Log(uploadResponse.RemoteFileID)
-		_ = fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Files()) != 0 {
+		t.Errorf("file not deleted: %v", server.Files())
+	}
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect ErrNotFound: %v", err)
 	}
 }
 
-func TestUploadByBuffer(t *testing.T) {
-	fdfsClient, err := NewFdfsClient("client.conf")
+// TestDeleteFileQueryUpdate 删除文件要向 tracker 查询文件所在的存储服务器(QUERY_UPDATE), 而不是上传用的 QUERY_STORE
+func TestDeleteFileQueryUpdate(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
 	if err != nil {
-		t.Errorf("New FdfsClient error %s", err.Error())
-		return
+		t.Fatal(err)
 	}
 
-	file, err := os.Open("testfile") // For read access.
-	if err != nil {
+	server.InjectFault(TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE, fdfstest.Fault{Status: 28})
+	server.InjectFault(TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITH_GROUP_ONE, fdfstest.Fault{Status: 28})
+	server.InjectFault(TRACKER_PROTO_CMD_SERVICE_QUERY_UPDATE, fdfstest.Fault{Status: 16, Times: 1})
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expect ErrBusy from QUERY_UPDATE, actual %v", err)
+	}
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); err != nil {
 		t.Fatal(err)
 	}
-
-	var fileSize int64
-	if fileInfo, err := file.Stat(); err == nil {
-		fileSize = fileInfo.Size()
+	if hits := server.FaultHits(TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE) +
+		server.FaultHits(TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITH_GROUP_ONE); hits != 0 {
+		t.Errorf("DeleteFile should not query store, %d queries", hits)
 	}
-	fileBuffer := make([]byte, fileSize)
-	_, err = file.Read(fileBuffer)
+}
+
+func TestUploadByBuffer(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello fastdfs"), "txt")
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("TestUploadByBuffer error %v", err)
+	}
+	if content, ok := server.File(uploadResponse.RemoteFileID); !ok || string(content) != "hello fastdfs" {
+		t.Errorf("unexpected content %q", content)
 	}
 
-	uploadResponse, err = fdfsClient.UploadByBuffer(fileBuffer, "txt")
-	if err != nil || uploadResponse == nil {
-		t.Errorf("TestUploadByBuffer error %v", err)
-	} else {
-		t.Log(uploadResponse.GroupName)
-		t.Log(uploadResponse.RemoteFileID)
-		_ = fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
+	if _, err = fdfsClient.UploadByBuffer([]byte("hello"), "txt", "group2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect ErrNotFound for unknown group: %v", err)
 	}
 }
 
 func TestUploadSlaveByFilename(t *testing.T) {
-	fdfsClient, err := NewFdfsClient("client.conf")
+	fdfsClient, server := newTestClient(t)
+
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("master"), "jpg")
 	if err != nil {
-		t.Errorf("New FdfsClient error %s", err.Error())
-		return
+		t.Fatalf("UploadByBuffer error %v", err)
 	}
+	masterFileID := uploadResponse.RemoteFileID
 
-	uploadResponse, err = fdfsClient.UploadByFilename("client.conf")
+	filename := writeTestFile(t, "testfile.png", []byte("slave"))
+	slaveResponse, err := fdfsClient.UploadSlaveByFilename(filename, masterFileID, "_test")
 	if err != nil {
-		t.Errorf("UploadByfilename error %s", err.Error())
+		t.Fatalf("UploadSlaveByFilename error %v", err)
+	}
+	if expect := strings.TrimSuffix(masterFileID, ".jpg") + "_test.png"; slaveResponse.RemoteFileID != expect {
+		t.Errorf("expect %s, actual %s", expect, slaveResponse.RemoteFileID)
 	}
-	t.Log(uploadResponse.GroupName)
-	t.Log(uploadResponse.RemoteFileID)
 
-	masterFileID := uploadResponse.RemoteFileID
-	uploadResponse, err = fdfsClient.UploadSlaveByFilename("testfile", masterFileID, "_test")
+	slaveResponse, err = fdfsClient.UploadSlaveByBuffer([]byte("thumb"), masterFileID, "_150x150", "")
 	if err != nil {
-		t.Errorf("UploadByfilename error %s", err.Error())
+		t.Fatalf("UploadSlaveByBuffer error %v", err)
+	}
+	if expect := strings.TrimSuffix(masterFileID, ".jpg") + "_150x150.jpg"; slaveResponse.RemoteFileID != expect {
+		t.Errorf("expect %s, actual %s", expect, slaveResponse.RemoteFileID)
+	}
+	if content, _ := server.File(slaveResponse.RemoteFileID); string(content) != "thumb" {
+		t.Errorf("unexpected content %q", content)
 	}
-	t.Log(uploadResponse.GroupName)
-	t.Log(uploadResponse.RemoteFileID)
-
-	fdfsClient.DeleteFile(masterFileID)
-	fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
 }
 
-func TestDownloadToFile(t *testing.T) {
-	fdfsClient, err := NewFdfsClient("client.conf")
+func TestAppenderFile(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+
+	uploadResponse, err := fdfsClient.UploadAppenderByBuffer([]byte("hello"), "log")
 	if err != nil {
-		t.Errorf("New FdfsClient error %s", err.Error())
-		return
+		t.Fatal(err)
+	}
+	appenderFileID := uploadResponse.RemoteFileID
+	if err = fdfsClient.AppendByBuffer([]byte(" world"), appenderFileID); err != nil {
+		t.Fatal(err)
+	}
+	if err = fdfsClient.ModifyByBuffer([]byte("W"), 6, appenderFileID); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := server.File(appenderFileID); string(content) != "hello World" {
+		t.Errorf("unexpected content %q", content)
+	}
+	if err = fdfsClient.TruncateFile(appenderFileID, 5); err != nil {
+		t.Fatal(err)
 	}
+	if content, _ := server.File(appenderFileID); string(content) != "hello" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestMetadataAndFileInfo(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
 
-	uploadResponse, err = fdfsClient.UploadByFilename("client.conf")
-	defer fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileID := uploadResponse.RemoteFileID
+	if err = fdfsClient.SetMetadata(fileID, map[string]string{"width": "100", "height": "50"}, STORAGE_SET_METADATA_FLAG_OVERWRITE); err != nil {
+		t.Fatal(err)
+	}
+	if err = fdfsClient.SetMetadata(fileID, map[string]string{"width": "200"}, STORAGE_SET_METADATA_FLAG_MERGE); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := fdfsClient.GetMetadata(fileID)
 	if err != nil {
-		t.Errorf("UploadByfilename error %s", err.Error())
+		t.Fatal(err)
+	}
+	if len(meta) != 2 || meta["width"] != "200" || meta["height"] != "50" {
+		t.Errorf("unexpected metadata %v", meta)
 	}
-	t.Log(uploadResponse.GroupName)
-	t.Log(uploadResponse.RemoteFileID)
 
-	var (
-		downloadResponse *DownloadFileResponse
-	)
-	localFilename := "download.txt"
-	downloadResponse, err = fdfsClient.DownloadToFile(localFilename, uploadResponse.RemoteFileID, 0, 0)
+	info, err := fdfsClient.QueryFileInfo(fileID)
 	if err != nil {
-		t.Errorf("DownloadToFile error %s", err.Error())
+		t.Fatal(err)
+	}
+	if info.FileSize != 5 || info.SourceIPAddr != "127.0.0.1" {
+		t.Errorf("unexpected file info %+v", info)
 	}
-	t.Log(downloadResponse.DownloadSize)
-	t.Log(downloadResponse.RemoteFileID)
 }
 
-func TestDownloadToBuffer(t *testing.T) {
-	fdfsClient, err := NewFdfsClient("client.conf")
+func TestCreateLink(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("content"), "bin")
 	if err != nil {
-		t.Errorf("New FdfsClient error %s", err.Error())
-		return
+		t.Fatal(err)
 	}
-
-	uploadResponse, err = fdfsClient.UploadByFilename("client.conf")
-	defer fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
+	link, err := fdfsClient.CreateLink(&LinkRequest{SourceFileID: uploadResponse.RemoteFileID})
 	if err != nil {
-		t.Errorf("UploadByfilename error %s", err.Error())
+		t.Fatal(err)
 	}
-	t.Log(uploadResponse.GroupName)
-	t.Log(uploadResponse.RemoteFileID)
+	if link.RemoteFileID == uploadResponse.RemoteFileID || !strings.HasSuffix(link.RemoteFileID, ".bin") {
+		t.Errorf("unexpected link %s", link.RemoteFileID)
+	}
+	if content, _ := server.File(link.RemoteFileID); string(content) != "content" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
 
-	var (
-		downloadResponse *DownloadFileResponse
-	)
-	downloadResponse, err = fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0)
+func TestDownloadToFile(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("download to file"), "txt")
 	if err != nil {
-		t.Errorf("DownloadToBuffer error %s", err.Error())
+		t.Fatalf("UploadByBuffer error %v", err)
 	}
-	t.Log(downloadResponse.DownloadSize)
-	t.Log(downloadResponse.RemoteFileID)
-}
 
-func BenchmarkUploadByBuffer(b *testing.B) {
-	fdfsClient, err := NewFdfsClient("client.conf")
+	localFilename := filepath.Join(t.TempDir(), "download.txt")
+	downloadResponse, err := fdfsClient.DownloadToFile(localFilename, uploadResponse.RemoteFileID, 0, 0)
 	if err != nil {
-		fmt.Printf("New FdfsClient error %s", err.Error())
-		return
+		t.Fatalf("DownloadToFile error %v", err)
 	}
-	file, err := os.Open("testfile") // For read access.
+	content, err := os.ReadFile(localFilename)
 	if err != nil {
-		fmt.Printf("%s", err.Error())
+		t.Fatal(err)
+	}
+	if downloadResponse.DownloadSize != 16 || string(content) != "download to file" {
+		t.Errorf("unexpected download %d %q", downloadResponse.DownloadSize, content)
 	}
+}
 
-	var fileSize int64
-	if fileInfo, err := file.Stat(); err == nil {
-		fileSize = fileInfo.Size()
+func TestDownloadToBuffer(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("download to buffer"), "txt")
+	if err != nil {
+		t.Fatalf("UploadByBuffer error %v", err)
 	}
-	fileBuffer := make([]byte, fileSize)
-	_, err = file.Read(fileBuffer)
+
+	downloadResponse, err := fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 9, 2)
 	if err != nil {
-		fmt.Printf("%s", err.Error())
+		t.Fatalf("DownloadToBuffer error %v", err)
+	}
+	if content, _ := downloadResponse.Content.([]byte); !bytes.Equal(content, []byte("to")) {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	_, err = fdfsClient.DownloadToBuffer(fdfstest.DefaultGroupName+"/M00/00/00/none.txt", 0, 0)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect ErrNotFound: %v", err)
 	}
+}
 
-	b.StopTimer()
-	b.StartTimer()
+func BenchmarkUploadByBuffer(b *testing.B) {
+	fdfsClient, _ := newTestClient(b)
+	fileBuffer := bytes.Repeat([]byte("fastdfs"), 1024)
 
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		uploadResponse, err = fdfsClient.UploadByBuffer(fileBuffer, "txt")
+		uploadResponse, err := fdfsClient.UploadByBuffer(fileBuffer, "txt")
 		if err != nil {
-			fmt.Printf("TestUploadByBuffer error %s", err.Error())
+			b.Fatalf("UploadByBuffer error %v", err)
 		}
-
-		fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
+		_ = fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
 	}
 }
 
 func BenchmarkUploadByFilename(b *testing.B) {
-	fdfsClient, err := NewFdfsClient("client.conf")
-	if err != nil {
-		fmt.Printf("New FdfsClient error %s", err.Error())
-		return
-	}
-
-	b.StopTimer()
-	b.StartTimer()
+	fdfsClient, _ := newTestClient(b)
+	filename := writeTestFile(b, "testfile", bytes.Repeat([]byte("fastdfs"), 1024))
 
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		uploadResponse, err = fdfsClient.UploadByFilename("client.conf")
+		uploadResponse, err := fdfsClient.UploadByFilename(filename)
 		if err != nil {
-			fmt.Printf("UploadByfilename error %s", err.Error())
+			b.Fatalf("UploadByfilename error %v", err)
 		}
-		err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
-		if err != nil {
-			fmt.Printf("DeleteFile error %s", err.Error())
+		if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); err != nil {
+			b.Fatalf("DeleteFile error %v", err)
 		}
 	}
 }
 
 func BenchmarkDownloadToFile(b *testing.B) {
-	fdfsClient, err := NewFdfsClient("client.conf")
-	if err != nil {
-		fmt.Printf("New FdfsClient error %s", err.Error())
-		return
-	}
-
-	uploadResponse, err = fdfsClient.UploadByFilename("client.conf")
-	defer fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
+	fdfsClient, _ := newTestClient(b)
+	uploadResponse, err := fdfsClient.UploadByBuffer(bytes.Repeat([]byte("fastdfs"), 1024), "txt")
 	if err != nil {
-		fmt.Printf("UploadByfilename error %s", err.Error())
+		b.Fatalf("UploadByBuffer error %v", err)
 	}
 
-	b.StopTimer()
-	b.StartTimer()
-
-	localFilename := "download.txt"
+	localFilename := filepath.Join(b.TempDir(), "download.txt")
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, err = fdfsClient.DownloadToFile(localFilename, uploadResponse.RemoteFileID, 0, 0)
 		if err != nil {
-			fmt.Printf("DownloadToFile error %s", err.Error())
+			b.Fatalf("DownloadToFile error %v", err)
 		}
 	}
 }
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jslyzt/goconfig/config"
+)
+
+const (
+	defaultTrackerPort    = 22122
+	defaultConnectTimeout = 30 * time.Second
+	defaultNetworkTimeout = 30 * time.Second
+	defaultLogLevel       = "info"
+	defaultMinConns       = 10
+	defaultMaxConns       = 150
+
+	// EnvPrefix 环境变量覆盖配置时的前缀, 例如 FDFS_TRACKER_SERVER 覆盖 tracker_server
+	EnvPrefix = "FDFS_"
+)
+
+// configKeys 支持环境变量覆盖的配置项
+var configKeys = []string{
+	"connect_timeout",
+	"network_timeout",
+	"base_path",
+	"log_level",
+	"tracker_server",
+	"connection_pool_min_conns",
+	"connection_pool_max_conns",
+	"http.tracker_server_port",
+	"http.domain_name",
+	"http.anti_steal.check_token",
+	"http.anti_steal.secret_key",
+	"http.anti_steal.token_ttl",
+}
+
+var logLevels = []string{"emerg", "alert", "crit", "error", "warn", "notice", "info", "debug"}
+
+// ClientConfig client.conf 配置
+type ClientConfig struct {
+	// ConnectTimeout connect_timeout, 单位秒
+	ConnectTimeout time.Duration
+	// NetworkTimeout network_timeout, 单位秒, 每次读写的超时时间
+	NetworkTimeout time.Duration
+	// BasePath base_path
+	BasePath string
+	// LogLevel log_level, Logger 的输出级别; 为空时默认不输出日志, 设置后输出到标准错误
+	LogLevel string
+	// TrackerServers tracker_server, host:port 格式, 可以出现多次或用逗号分隔
+	TrackerServers []string
+	// MinConns connection_pool_min_conns, 每个连接池的初始连接数
+	MinConns int
+	// MaxConns connection_pool_max_conns, 每个连接池的最大连接数
+	MaxConns int
+	// HTTP http.* 配置
+	HTTP HTTPConf
+}
+
+// ConfigError 配置错误
+type ConfigError struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config %s=%q: %s", e.Key, e.Value, e.Err.Error())
+}
+
+// Unwrap 原始错误
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// NewClientConfig 默认配置
+func NewClientConfig() *ClientConfig {
+	return &ClientConfig{
+		ConnectTimeout: defaultConnectTimeout,
+		NetworkTimeout: defaultNetworkTimeout,
+		MinConns:       defaultMinConns,
+		MaxConns:       defaultMaxConns,
+		HTTP:           HTTPConf{TrackerServerPort: defaultHTTPPort},
+	}
+}
+
+// LoadClientConfig 解析配置, FDFS_* 环境变量优先于配置文件
+// confPath 和 confData 都为空时只使用默认值和环境变量
+func LoadClientConfig(confPath, confData string) (*ClientConfig, error) {
+	cf, err := readConf(confPath, confData)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := NewClientConfig()
+	if err = cfg.parse(cf); err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate 校验配置
+func (cfg *ClientConfig) Validate() error {
+	if len(cfg.TrackerServers) == 0 {
+		return &ConfigError{Key: "tracker_server", Err: errors.New("no tracker server")}
+	}
+	for _, tr := range cfg.TrackerServers {
+		if _, _, err := splitHostPort(tr); err != nil {
+			return &ConfigError{Key: "tracker_server", Value: tr, Err: err}
+		}
+	}
+	if cfg.ConnectTimeout <= 0 {
+		return &ConfigError{Key: "connect_timeout", Value: cfg.ConnectTimeout.String(), Err: errors.New("must be positive")}
+	}
+	if cfg.NetworkTimeout <= 0 {
+		return &ConfigError{Key: "network_timeout", Value: cfg.NetworkTimeout.String(), Err: errors.New("must be positive")}
+	}
+	if cfg.MinConns < 0 || cfg.MaxConns <= 0 || cfg.MinConns > cfg.MaxConns {
+		return &ConfigError{Key: "connection_pool_min_conns", Value: fmt.Sprintf("%d/%d", cfg.MinConns, cfg.MaxConns),
+			Err: errors.New("invalid conns settings")}
+	}
+	if len(cfg.LogLevel) > 0 && !validLogLevel(cfg.LogLevel) {
+		return &ConfigError{Key: "log_level", Value: cfg.LogLevel, Err: errors.New("unknown log level")}
+	}
+	if cfg.HTTP.TrackerServerPort <= 0 || cfg.HTTP.TrackerServerPort > 65535 {
+		return &ConfigError{Key: "http.tracker_server_port", Value: strconv.Itoa(cfg.HTTP.TrackerServerPort),
+			Err: errors.New("port out of range")}
+	}
+	return nil
+}
+
+// Tracker 转为 Tracker, 各 tracker 端口不同时 HostList 为 host:port
+func (cfg *ClientConfig) Tracker() *Tracker {
+	tracker := &Tracker{HostList: make([]string, 0, len(cfg.TrackerServers)), Port: defaultTrackerPort}
+	samePort := true
+	for i, tr := range cfg.TrackerServers {
+		_, port, _ := splitHostPort(tr)
+		if i == 0 {
+			tracker.Port = port
+		} else if port != tracker.Port {
+			samePort = false
+		}
+	}
+	for _, tr := range cfg.TrackerServers {
+		host, _, _ := splitHostPort(tr)
+		if samePort {
+			tracker.HostList = append(tracker.HostList, host)
+		} else {
+			tracker.HostList = append(tracker.HostList, tr)
+		}
+	}
+	return tracker
+}
+
+func (cfg *ClientConfig) parse(cf *config.Config) error {
+	var err error
+	if cfg.ConnectTimeout, err = confSeconds(cf, "connect_timeout", cfg.ConnectTimeout); err != nil {
+		return err
+	}
+	if cfg.NetworkTimeout, err = confSeconds(cf, "network_timeout", cfg.NetworkTimeout); err != nil {
+		return err
+	}
+	if cf.HasOption("DEFAULT", "base_path") {
+		cfg.BasePath, _ = cf.RawString("DEFAULT", "base_path")
+	}
+	if cf.HasOption("DEFAULT", "log_level") {
+		logLevel, _ := cf.RawString("DEFAULT", "log_level")
+		cfg.LogLevel = strings.ToLower(logLevel)
+	}
+	if cfg.MinConns, err = confInt(cf, "connection_pool_min_conns", cfg.MinConns); err != nil {
+		return err
+	}
+	if cfg.MaxConns, err = confInt(cf, "connection_pool_max_conns", cfg.MaxConns); err != nil {
+		return err
+	}
+
+	trackerList, _ := cf.RawString("DEFAULT", "tracker_server")
+	for _, tr := range strings.Split(trackerList, ",") {
+		tr = strings.TrimSpace(tr)
+		if len(tr) == 0 {
+			continue
+		}
+		if !strings.Contains(tr, ":") {
+			tr = net.JoinHostPort(tr, strconv.Itoa(defaultTrackerPort))
+		}
+		if _, _, err := splitHostPort(tr); err != nil {
+			return &ConfigError{Key: "tracker_server", Value: tr, Err: err}
+		}
+		cfg.TrackerServers = append(cfg.TrackerServers, tr)
+	}
+
+	httpConf, err := httpConfFromConf(cf)
+	if err != nil {
+		return err
+	}
+	cfg.HTTP = *httpConf
+	return nil
+}
+
+// applyEnvOverrides 用 FDFS_* 环境变量覆盖配置项, 例如 http.tracker_server_port => FDFS_HTTP_TRACKER_SERVER_PORT
+func applyEnvOverrides(cf *config.Config) {
+	for _, key := range configKeys {
+		if value, ok := os.LookupEnv(envName(key)); ok {
+			cf.AddOption("DEFAULT", key, value)
+		}
+	}
+}
+
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+func confInt(cf *config.Config, key string, def int) (int, error) {
+	if !cf.HasOption("DEFAULT", key) {
+		return def, nil
+	}
+	raw, _ := cf.RawString("DEFAULT", key)
+	value, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, &ConfigError{Key: key, Value: raw, Err: err}
+	}
+	return value, nil
+}
+
+// confSeconds 没有配置时返回 def, 配置为负数时原样返回, 由 Validate 报错
+func confSeconds(cf *config.Config, key string, def time.Duration) (time.Duration, error) {
+	if !cf.HasOption("DEFAULT", key) {
+		return def, nil
+	}
+	seconds, err := confInt(cf, key, 0)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func splitHostPort(hostport string) (string, int, error) {
+	host, portString, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(host) == 0 {
+		return "", 0, errors.New("missing host")
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return "", 0, err
+	}
+	if port <= 0 || port > 65535 {
+		return "", 0, errors.New("port out of range")
+	}
+	return host, port, nil
+}
+
+func validLogLevel(level string) bool {
+	for _, l := range logLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeRepeatedOption 合并多次出现的配置项, 例如多行 tracker_server 合并为逗号分隔的一行
+func mergeRepeatedOption(data string, key string) string {
+	lines := strings.Split(data, "\n")
+	first := -1
+	values := make([]string, 0)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		idx := strings.IndexAny(trimmed, "=:")
+		if idx <= 0 || trimmed[0] == '#' || strings.TrimSpace(trimmed[:idx]) != key {
+			continue
+		}
+		values = append(values, strings.TrimSpace(trimmed[idx+1:]))
+		if first < 0 {
+			first = i
+		} else {
+			lines[i] = ""
+		}
+	}
+	if len(values) > 1 {
+		lines[first] = key + "=" + strings.Join(values, ",")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoadClientConfig(t *testing.T) {
+	confData := `connect_timeout=5
+network_timeout=60
+base_path=/var/fastdfs
+log_level=WARN
+tracker_server=10.0.1.32:22122
+tracker_server=10.0.1.33:22123
+tracker_server=10.0.1.34
+connection_pool_max_conns=20
+http.tracker_server_port=8080
+`
+	cfg, err := LoadClientConfig("", confData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConnectTimeout != 5*time.Second || cfg.NetworkTimeout != time.Minute {
+		t.Errorf("unexpected timeouts %v %v", cfg.ConnectTimeout, cfg.NetworkTimeout)
+	}
+	if cfg.BasePath != "/var/fastdfs" || cfg.LogLevel != "warn" {
+		t.Errorf("unexpected base_path/log_level %s %s", cfg.BasePath, cfg.LogLevel)
+	}
+	if cfg.MinConns != defaultMinConns || cfg.MaxConns != 20 || cfg.HTTP.TrackerServerPort != 8080 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	trackers := []string{"10.0.1.32:22122", "10.0.1.33:22123", "10.0.1.34:22122"}
+	if !reflect.DeepEqual(cfg.TrackerServers, trackers) {
+		t.Errorf("unexpected trackers %v", cfg.TrackerServers)
+	}
+	tracker := cfg.Tracker()
+	if !reflect.DeepEqual(tracker.HostList, trackers) {
+		t.Errorf("trackers with different ports should keep their ports: %v", tracker.HostList)
+	}
+}
+
+func TestGetTrackerConfSamePort(t *testing.T) {
+	tracker, err := GetTrackerConf("", "tracker_server=10.0.1.32:22122,10.0.1.33:22122\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tracker.HostList, []string{"10.0.1.32", "10.0.1.33"}) || tracker.Port != 22122 {
+		t.Errorf("unexpected tracker %+v", tracker)
+	}
+}
+
+func TestLoadClientConfigInvalid(t *testing.T) {
+	cases := []string{
+		"tracker_server=10.0.1.32:abc\n",
+		"tracker_server=10.0.1.32:22122\nconnect_timeout=x\n",
+		"tracker_server=10.0.1.32:22122\nconnect_timeout=-1\n",
+		"tracker_server=10.0.1.32:22122\nnetwork_timeout=-30\n",
+		"tracker_server=10.0.1.32:22122\nlog_level=verbose\n",
+		"tracker_server=10.0.1.32:22122\nconnection_pool_min_conns=200\n",
+		"base_path=/tmp\n",
+	}
+	for _, confData := range cases {
+		_, err := LoadClientConfig("", confData)
+		var ce *ConfigError
+		if !errors.As(err, &ce) {
+			t.Errorf("%q: expect ConfigError, actual %v", confData, err)
+		}
+	}
+}
+
+func TestLoadClientConfigEnv(t *testing.T) {
+	os.Setenv("FDFS_TRACKER_SERVER", "10.0.2.1:22122,10.0.2.2:22122")
+	os.Setenv("FDFS_NETWORK_TIMEOUT", strconv.Itoa(10))
+	os.Setenv("FDFS_HTTP_DOMAIN_NAME", "img.example.com")
+	defer func() {
+		os.Unsetenv("FDFS_TRACKER_SERVER")
+		os.Unsetenv("FDFS_NETWORK_TIMEOUT")
+		os.Unsetenv("FDFS_HTTP_DOMAIN_NAME")
+	}()
+
+	cfg, err := LoadClientConfig("", "tracker_server=10.0.1.32:22122\nnetwork_timeout=60\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.TrackerServers, []string{"10.0.2.1:22122", "10.0.2.2:22122"}) {
+		t.Errorf("tracker_server not overridden: %v", cfg.TrackerServers)
+	}
+	if cfg.NetworkTimeout != 10*time.Second || cfg.HTTP.DomainName != "img.example.com" {
+		t.Errorf("env overrides not applied: %+v", cfg)
+	}
+
+	cfg, err = LoadClientConfig("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.TrackerServers) != 2 {
+		t.Errorf("env only config not loaded: %+v", cfg)
+	}
+}
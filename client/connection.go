@@ -1,12 +1,17 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,38 +20,193 @@ var (
 	ErrClosed = errors.New("pool is closed")
 )
 
+// pConn 连接池中的连接, 读写出错后 Close 时直接关闭, 不放回连接池
 type pConn struct {
 	net.Conn
-	pool *ConnectionPool
+	pool   *ConnectionPool
+	hooks  *connHooks
+	addr   string
+	broken bool
+	closed bool
 }
 
-func (c pConn) Close() error {
-	return c.pool.put(c.Conn)
+// connHooks 取出连接的客户端的指标, 日志和协议调试输出
+// storage 连接池在所有客户端间共享, 每次取连接时传入, 不保存在连接池中
+type connHooks struct {
+	metrics  Metrics
+	logger   *levelLogger
+	wireDump *wireDump
+	// ctx 取连接的操作的 context, 读写超时不晚于它的截止时间, 取消后下一次读写失败
+	ctx context.Context
+}
+
+func (hooks *connHooks) getMetrics() Metrics {
+	if hooks == nil {
+		return nil
+	}
+	return hooks.metrics
+}
+
+func (hooks *connHooks) getLogger() *levelLogger {
+	if hooks == nil {
+		return nil
+	}
+	return hooks.logger
+}
+
+func (hooks *connHooks) getWireDump() *wireDump {
+	if hooks == nil {
+		return nil
+	}
+	return hooks.wireDump
+}
+
+func (hooks *connHooks) getContext() context.Context {
+	if hooks == nil {
+		return nil
+	}
+	return hooks.ctx
+}
+
+func (c *pConn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	atomic.AddInt64(&c.pool.inUse, -1)
+	if c.broken {
+		c.hooks.getLogger().debug("fdfs connection closed after error", "addr", c.addr)
+		return c.Conn.Close()
+	}
+	return c.pool.put(c.Conn, c.hooks)
+}
+
+func (c *pConn) Read(b []byte) (int, error) {
+	if err := c.contextErr(); err != nil {
+		c.broken = true
+		return 0, err
+	}
+	if deadline := c.deadline(); !deadline.IsZero() {
+		_ = c.Conn.SetReadDeadline(deadline)
+	}
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.broken = true
+		if ctxErr := c.contextErr(); ctxErr != nil {
+			err = ctxErr
+		}
+	}
+	if metrics := c.hooks.getMetrics(); metrics != nil && n > 0 {
+		metrics.AddBytes(c.addr, DirectionReceived, int64(n))
+	}
+	return n, err
+}
+
+func (c *pConn) Write(b []byte) (int, error) {
+	if err := c.contextErr(); err != nil {
+		c.broken = true
+		return 0, err
+	}
+	if deadline := c.deadline(); !deadline.IsZero() {
+		_ = c.Conn.SetWriteDeadline(deadline)
+	}
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.broken = true
+		if ctxErr := c.contextErr(); ctxErr != nil {
+			err = ctxErr
+		}
+	}
+	if dump := c.hooks.getWireDump(); dump != nil && n > 0 {
+		dump.data(">", c.addr, b[:n])
+	}
+	if metrics := c.hooks.getMetrics(); metrics != nil && n > 0 {
+		metrics.AddBytes(c.addr, DirectionSent, int64(n))
+	}
+	return n, err
+}
+
+// deadline 网络超时和操作的 context 截止时间中较早的一个, 都没有时为零值
+func (c *pConn) deadline() time.Time {
+	var deadline time.Time
+	if c.pool.networkTimeout > 0 {
+		deadline = time.Now().Add(c.pool.networkTimeout)
+	}
+	if ctx := c.hooks.getContext(); ctx != nil {
+		if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+	return deadline
+}
+
+// contextErr 操作的 context 已经取消或超时时返回它的错误, 代替读写超时的错误
+func (c *pConn) contextErr() error {
+	if ctx := c.hooks.getContext(); ctx != nil {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// closeConn 放回连接池; 请求发出后出错时连接上可能还有没有发完或读完的数据, 直接关闭
+// 服务端返回的状态码错误(没有响应体)不影响连接
+func closeConn(conn net.Conn, err error) {
+	if c, ok := conn.(*pConn); ok && err != nil {
+		var errno Errno
+		if !errors.As(err, &errno) {
+			c.broken = true
+		}
+	}
+	_ = conn.Close()
+}
+
+// markBroken 连接不再可用, Close 时直接关闭
+func markBroken(conn net.Conn) {
+	if c, ok := conn.(*pConn); ok {
+		c.broken = true
+	}
 }
 
 // ConnectionPool 连接池
 type ConnectionPool struct {
-	hosts    []string
-	port     int
-	minConns int
-	maxConns int
-	conns    chan net.Conn
+	// inUse 放在第一个字段, 保证32位平台上原子操作的对齐
+	inUse          int64
+	hosts          []string
+	port           int
+	minConns       int
+	maxConns       int
+	connectTimeout time.Duration
+	networkTimeout time.Duration
+	conns          chan net.Conn
+	// mutex 保护 closed, 放回连接和关闭连接池互斥, 关闭后不再向 conns 发送
+	mutex  sync.Mutex
+	closed bool
 }
 
-// NewConnectionPool 新连接池
+// NewConnectionPool 新连接池, hosts 中的地址可以带端口(host:port)
 func NewConnectionPool(hosts []string, port int, minConns int, maxConns int) (*ConnectionPool, error) {
+	return newConnectionPool(hosts, port, minConns, maxConns, time.Minute, 0, nil)
+}
+
+func newConnectionPool(hosts []string, port int, minConns int, maxConns int,
+	connectTimeout time.Duration, networkTimeout time.Duration, hooks *connHooks) (*ConnectionPool, error) {
 	if minConns < 0 || maxConns <= 0 || minConns > maxConns {
 		return nil, errors.New("invalid conns settings")
 	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts")
+	}
 	cp := &ConnectionPool{
-		hosts:    hosts,
-		port:     port,
-		minConns: minConns,
-		maxConns: maxConns,
-		conns:    make(chan net.Conn, maxConns),
+		hosts:          hosts,
+		port:           port,
+		minConns:       minConns,
+		maxConns:       maxConns,
+		connectTimeout: connectTimeout,
+		networkTimeout: networkTimeout,
+		conns:          make(chan net.Conn, maxConns),
 	}
 	for i := 0; i < minConns; i++ {
-		conn, err := cp.makeConn()
+		conn, err := cp.makeConn(hooks)
 		if err != nil {
 			cp.Close()
 			return nil, err
@@ -58,98 +218,149 @@ func NewConnectionPool(hosts []string, port int, minConns int, maxConns int) (*C
 
 // Get 获取
 func (pool *ConnectionPool) Get() (net.Conn, error) {
-	conns := pool.getConns()
-	if conns == nil {
-		return nil, ErrClosed
-	}
+	return pool.get(nil)
+}
 
+// get 获取连接, 连接的指标, 日志和协议调试输出到 hooks
+func (pool *ConnectionPool) get(hooks *connHooks) (net.Conn, error) {
 	for {
+		if pool.isClosed() {
+			return nil, ErrClosed
+		}
 		select {
-		case conn := <-conns:
-			if conn == nil {
-				break
-				//return nil, ErrClosed
-			}
-			if err := pool.activeConn(conn); err != nil {
+		case conn := <-pool.conns:
+			start := time.Now()
+			err := pool.activeConn(conn)
+			hooks.observeActiveTest(conn, start, err)
+			if err != nil {
+				// tracker 或 storage 重启后旧连接不可用, 丢弃
+				hooks.getLogger().info("fdfs stale connection discarded", "addr", conn.RemoteAddr().String(), "err", err)
+				_ = conn.Close()
 				break
 			}
-			return pool.wrapConn(conn), nil
+			atomic.AddInt64(&pool.inUse, 1)
+			return pool.wrapConn(conn, hooks), nil
 		default:
-			if pool.Len() >= pool.maxConns {
-				errmsg := fmt.Sprintf("Too many connctions %d", pool.Len())
+			// 没有空闲连接, 取出的连接数达到上限时不再新建
+			if inUse := atomic.AddInt64(&pool.inUse, 1); inUse > int64(pool.maxConns) {
+				atomic.AddInt64(&pool.inUse, -1)
+				hooks.getLogger().warn("fdfs connection pool exhausted", "addr", pool.stats().Addr, "max_conns", pool.maxConns)
+				errmsg := fmt.Sprintf("Too many connctions %d", inUse-1)
 				return nil, errors.New(errmsg)
 			}
-			conn, err := pool.makeConn()
+			conn, err := pool.makeConn(hooks)
+			if err == nil && pool.isClosed() {
+				_ = conn.Close()
+				err = ErrClosed
+			}
 			if err != nil {
+				atomic.AddInt64(&pool.inUse, -1)
 				return nil, err
 			}
-			pool.conns <- conn
-			//put connection to pool and go next `for` loop
-			//return this.wrapConn(conn), nil
+			return pool.wrapConn(conn, hooks), nil
 		}
 	}
-
 }
 
-// Close 关闭
+// Close 关闭连接池和空闲连接, 之后放回的连接直接关闭
 func (pool *ConnectionPool) Close() {
-	conns := pool.conns
-	pool.conns = nil
-
-	if conns == nil {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.closed {
 		return
 	}
-
-	for conn := range conns {
-		_ = conn.Close()
+	pool.closed = true
+	for {
+		select {
+		case conn := <-pool.conns:
+			_ = conn.Close()
+		default:
+			return
+		}
 	}
-	close(conns)
+}
+
+func (pool *ConnectionPool) isClosed() bool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.closed
 }
 
 // Len 长度
 func (pool *ConnectionPool) Len() int {
-	return len(pool.getConns())
+	return len(pool.conns)
 }
 
-func (pool *ConnectionPool) makeConn() (net.Conn, error) {
-	host := pool.hosts[rand.Intn(len(pool.hosts))]
-	addr := fmt.Sprintf("%s:%d", host, pool.port)
-	return net.DialTimeout("tcp", addr, time.Minute)
+// makeConn 从随机的地址开始连接, 失败时依次尝试其他地址
+func (pool *ConnectionPool) makeConn(hooks *connHooks) (net.Conn, error) {
+	logger := hooks.getLogger()
+	first := rand.Intn(len(pool.hosts))
+	var err error
+	for i := range pool.hosts {
+		addr := pool.hostAddr(pool.hosts[(first+i)%len(pool.hosts)])
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", addr, pool.connectTimeout); err == nil {
+			logger.debug("fdfs connection opened", "addr", addr)
+			return conn, nil
+		}
+		if i+1 < len(pool.hosts) {
+			logger.warn("fdfs connect failed, trying next server", "addr", addr, "err", err)
+		} else {
+			logger.warn("fdfs connect failed", "addr", addr, "err", err)
+		}
+	}
+	return nil, err
 }
 
-func (pool *ConnectionPool) getConns() chan net.Conn {
-	conns := pool.conns
-	return conns
+// hostAddr host 没有端口时加上连接池的端口
+func (pool *ConnectionPool) hostAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(host, strconv.Itoa(pool.port))
+	}
+	return host
 }
 
-func (pool *ConnectionPool) put(conn net.Conn) error {
+func (pool *ConnectionPool) put(conn net.Conn, hooks *connHooks) error {
 	if conn == nil {
 		return errors.New("connection is nil")
 	}
-	if pool.conns == nil {
+	_ = conn.SetDeadline(time.Time{})
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.closed {
 		return conn.Close()
 	}
-
 	select {
 	case pool.conns <- conn:
 		return nil
 	default:
+		hooks.getLogger().debug("fdfs connection closed, pool is full", "addr", conn.RemoteAddr().String())
 		return conn.Close()
 	}
 }
 
-func (pool *ConnectionPool) wrapConn(conn net.Conn) net.Conn {
-	c := pConn{pool: pool}
+// wrapConn 包装取出的连接, 调用方已经计入 inUse, pConn.Close 时减去
+func (pool *ConnectionPool) wrapConn(conn net.Conn, hooks *connHooks) net.Conn {
+	c := &pConn{pool: pool, hooks: hooks, addr: conn.RemoteAddr().String()}
 	c.Conn = conn
 	return c
 }
 
 func (pool *ConnectionPool) activeConn(conn net.Conn) error {
+	if pool.networkTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(pool.networkTimeout))
+		defer conn.SetDeadline(time.Time{})
+	}
 	th := &trackerHeader{}
 	th.cmd = FDFS_PROTO_CMD_ACTIVE_TEST
-	th.sendHeader(conn)
-	th.recvHeader(conn)
-	if th.cmd == 100 && th.status == 0 {
+	if err := th.sendHeader(conn); err != nil {
+		return err
+	}
+	if err := th.recvHeader(conn); err != nil {
+		return err
+	}
+	if th.cmd == TRACKER_PROTO_CMD_RESP && th.status == 0 && th.pkgLen == 0 {
 		return nil
 	}
 	return errors.New("Conn unaliviable")
@@ -193,29 +404,32 @@ func TCPSendFile(conn net.Conn, filename string) error {
 	return TCPSendData(conn, fileBuffer)
 }
 
-// TCPRecvResponse tcp接收数据
+// recvPreallocSize 响应体不超过该长度时一次分配, 更长时随读取增长, 错误的 pkgLen 不会导致一次分配过大的内存
+const recvPreallocSize = 64 * 1024
+
+// TCPRecvResponse tcp接收数据, 连接提前关闭时返回包装了 io.ErrUnexpectedEOF 的错误
 func TCPRecvResponse(conn net.Conn, bufferSize int64) ([]byte, int64, error) {
-	recvBuff := make([]byte, 0, bufferSize)
-	tmp := make([]byte, 256)
-	var total int64
-	for {
-		n, err := conn.Read(tmp)
-		total += int64(n)
-		recvBuff = append(recvBuff, tmp[:n]...)
-		if err != nil {
-			if err != io.EOF {
-				return nil, 0, err
-			}
-			break
-		}
-		if total == bufferSize {
-			break
+	if bufferSize <= 0 {
+		return []byte{}, 0, nil
+	}
+	if bufferSize <= recvPreallocSize {
+		recvBuff := make([]byte, bufferSize)
+		n, err := io.ReadFull(conn, recvBuff)
+		if err = recvError(err, bufferSize, int64(n)); err != nil {
+			return nil, 0, err
 		}
+		return recvBuff[:n], int64(n), nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(recvPreallocSize)
+	total, err := io.CopyN(&buf, conn, bufferSize)
+	if err = recvError(err, bufferSize, total); err != nil {
+		return nil, 0, err
 	}
-	return recvBuff, total, nil
+	return buf.Bytes(), total, nil
 }
 
-// TCPRecvFile tcp接收文件
+// TCPRecvFile tcp接收文件, 边读边写, 不在内存中缓存整个文件
 func TCPRecvFile(conn net.Conn, localFilename string, bufferSize int64) (int64, error) {
 	file, err := os.Create(localFilename)
 	if err != nil {
@@ -226,9 +440,20 @@ func TCPRecvFile(conn net.Conn, localFilename string, bufferSize int64) (int64,
 		_ = file.Close()
 	}()
 
-	recvBuff, total, err := TCPRecvResponse(conn, bufferSize)
-	if _, err := file.Write(recvBuff); err != nil {
+	if bufferSize <= 0 {
+		return 0, nil
+	}
+	total, err := io.CopyN(file, conn, bufferSize)
+	if err = recvError(err, bufferSize, total); err != nil {
 		return 0, err
 	}
 	return total, nil
 }
+
+// recvError 响应体没有读完连接就关闭了, 返回包装了 io.ErrUnexpectedEOF 的错误, 可以重试
+func recvError(err error, expect, actual int64) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("response truncated, expect: %d, actual: %d: %w", expect, actual, io.ErrUnexpectedEOF)
+	}
+	return err
+}
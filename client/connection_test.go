@@ -1,11 +1,16 @@
 package client
 
 import (
-	"fmt"
+	"errors"
+	"net"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/fdfstest"
 )
 
-func getConn(pool *ConnectionPool) {
+func getConn(tb testing.TB, pool *ConnectionPool) {
 	conn, err := pool.Get()
 	defer func() {
 		if conn != nil {
@@ -13,38 +18,129 @@ func getConn(pool *ConnectionPool) {
 		}
 	}()
 	if err != nil {
-		fmt.Printf("get conn error:%s\n", err)
+		tb.Errorf("get conn error:%s", err)
 	}
 }
 
-func TestGetConnection(t *testing.T) {
-	hosts := []string{"10.0.1.32"}
-	port := 22122
+func newTestPool(tb testing.TB) *ConnectionPool {
+	server, err := fdfstest.NewServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	hosts := []string{server.TrackerHost()}
+	port := server.TrackerPort()
 	minConns := 10
 	maxConns := 150
 	pool, err := NewConnectionPool(hosts, port, minConns, maxConns)
 	if err != nil {
-		t.Error(err)
-		return
+		tb.Fatal(err)
 	}
+	tb.Cleanup(pool.Close)
+	return pool
+}
+
+func TestGetConnection(t *testing.T) {
+	pool := newTestPool(t)
+	var wg sync.WaitGroup
 	for i := 0; i < 100; i++ {
-		go getConn(pool)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			getConn(t, pool)
+		}()
+	}
+	wg.Wait()
+	if pool.Len() == 0 || pool.Len() > 150 {
+		t.Errorf("unexpected pool size %d", pool.Len())
 	}
 }
 
-func BenchmarkGetConnection(b *testing.B) {
-	hosts := []string{"10.0.1.32"}
-	port := 22122
-	minConns := 10
-	maxConns := 150
-	pool, err := NewConnectionPool(hosts, port, minConns, maxConns)
+// TestPoolCloseConcurrent 使用中关闭连接池, 之后的 Get 返回 ErrClosed, 放回的连接直接关闭
+func TestPoolCloseConcurrent(t *testing.T) {
+	pool := newTestPool(t)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				conn, err := pool.Get()
+				if err != nil {
+					if !errors.Is(err, ErrClosed) {
+						t.Errorf("expect ErrClosed, actual %v", err)
+					}
+					return
+				}
+				_ = conn.Close()
+			}
+		}()
+	}
+	close(start)
+	pool.Close()
+	wg.Wait()
+
+	if _, err := pool.Get(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expect ErrClosed after close, actual %v", err)
+	}
+	if pool.Len() != 0 {
+		t.Errorf("expect no idle connections after close, actual %d", pool.Len())
+	}
+	pool.Close()
+}
+
+// TestPoolExhausted 取出的连接数达到上限时报错, 放回后可以再取
+func TestPoolExhausted(t *testing.T) {
+	server, err := fdfstest.NewServer()
 	if err != nil {
-		b.Error(err)
-		return
+		t.Fatal(err)
+	}
+	defer server.Close()
+	logger := &recordingLogger{}
+	hooks := &connHooks{logger: newLevelLogger(logger, "warn")}
+	pool, err := newConnectionPool([]string{server.TrackerAddr()}, 0, 0, 2, time.Second, time.Second, hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	conns := make([]net.Conn, 2)
+	for i := range conns {
+		if conns[i], err = pool.get(hooks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = pool.get(hooks); err == nil {
+		t.Fatal("expect error when pool exhausted")
+	}
+	if logger.count("WARN fdfs connection pool exhausted") != 1 {
+		t.Errorf("exhausted pool not logged: %v", logger.lines)
 	}
-	b.StopTimer()
-	b.StartTimer()
-	for i := 0; i < 10000; i++ {
-		go getConn(pool)
+	if stats := pool.stats(); stats.InUse != 2 || stats.Idle != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	_ = conns[0].Close()
+	if conns[0], err = pool.get(hooks); err != nil {
+		t.Fatalf("expect idle connection reused: %v", err)
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	if stats := pool.stats(); stats.InUse != 0 || stats.Idle != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func BenchmarkGetConnection(b *testing.B) {
+	pool := newTestPool(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		getConn(b, pool)
 	}
 }
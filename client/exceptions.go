@@ -1 +1,131 @@
 package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPermission EPERM/EACCES 没有权限
+	ErrPermission = errors.New("permission denied")
+	// ErrNotFound ENOENT 文件不存在
+	ErrNotFound = errors.New("file not found")
+	// ErrIO EIO 存储服务器读写错误
+	ErrIO = errors.New("input/output error")
+	// ErrAgain EAGAIN 服务器暂时不可用, 可以重试
+	ErrAgain = errors.New("resource temporarily unavailable")
+	// ErrNoMemory ENOMEM 服务器内存不足
+	ErrNoMemory = errors.New("out of memory")
+	// ErrBusy EBUSY 服务器忙
+	ErrBusy = errors.New("device or resource busy")
+	// ErrExist EEXIST 文件已存在
+	ErrExist = errors.New("file exist")
+	// ErrInvalidArgument EINVAL 参数错误
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrNoSpace ENOSPC 磁盘空间不足
+	ErrNoSpace = errors.New("no space left on device")
+	// ErrNameTooLong ENAMETOOLONG 文件名过长
+	ErrNameTooLong = errors.New("file name too long")
+	// ErrNotSupported ENOSYS/EOPNOTSUPP 服务器不支持该操作
+	ErrNotSupported = errors.New("operation not supported")
+	// ErrTimeout ETIMEDOUT 服务器处理超时
+	ErrTimeout = errors.New("operation timed out")
+	// ErrConnRefused ECONNREFUSED 服务器拒绝连接
+	ErrConnRefused = errors.New("connection refused")
+	// ErrAlready EALREADY 操作正在进行
+	ErrAlready = errors.New("operation already in progress")
+	// ErrProtocol 协议解析错误, 与服务器返回的状态码区分
+	ErrProtocol = errors.New("protocol error")
+)
+
+// errnoErrors 服务器返回的状态码(linux errno)
+var errnoErrors = map[int]error{
+	1:   ErrPermission,
+	2:   ErrNotFound,
+	5:   ErrIO,
+	11:  ErrAgain,
+	12:  ErrNoMemory,
+	13:  ErrPermission,
+	16:  ErrBusy,
+	17:  ErrExist,
+	22:  ErrInvalidArgument,
+	28:  ErrNoSpace,
+	36:  ErrNameTooLong,
+	38:  ErrNotSupported,
+	95:  ErrNotSupported,
+	110: ErrTimeout,
+	111: ErrConnRefused,
+	114: ErrAlready,
+}
+
+// Errno 服务器返回的非0状态码, 可以用 errors.Is 与 ErrNotFound 等比较
+type Errno struct {
+	status int
+}
+
+// Status 服务端返回的状态码
+func (e Errno) Status() int {
+	return e.status
+}
+
+func (e Errno) Error() string {
+	errmsg := fmt.Sprintf("errno [%d] ", e.status)
+	if err, ok := errnoErrors[e.status]; ok {
+		errmsg += err.Error()
+	}
+	return errmsg
+}
+
+// Is errors.Is 支持
+func (e Errno) Is(target error) bool {
+	if t, ok := target.(Errno); ok {
+		return t.status == e.status
+	}
+	err, ok := errnoErrors[e.status]
+	return ok && err == target
+}
+
+// ProtocolError 协议解析错误
+type ProtocolError struct {
+	Msg string
+}
+
+func newProtocolError(format string, args ...interface{}) error {
+	return &ProtocolError{Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol error: " + e.Msg
+}
+
+// Is errors.Is 支持
+func (e *ProtocolError) Is(target error) bool {
+	return target == ErrProtocol
+}
+
+// OpError 带操作、文件ID和服务器地址的错误
+type OpError struct {
+	// Op 操作, 例如 upload, download, query_fetch
+	Op string
+	// FileID 远端文件ID, 上传失败时为空
+	FileID string
+	// Addr 服务器地址 ip:port
+	Addr string
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	errmsg := "fdfs " + e.Op
+	if len(e.FileID) > 0 {
+		errmsg += " " + e.FileID
+	}
+	if len(e.Addr) > 0 {
+		errmsg += " [" + e.Addr + "]"
+	}
+	return errmsg + ": " + e.Err.Error()
+}
+
+// Unwrap 原始错误
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
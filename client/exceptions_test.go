@@ -0,0 +1,43 @@
+package client
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrnoIs(t *testing.T) {
+	err := error(&OpError{Op: "download", FileID: "group1/M00/00/00/a.txt", Addr: "127.0.0.1:23000", Err: Errno{2}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect ErrNotFound: %v", err)
+	}
+	if errors.Is(err, ErrExist) {
+		t.Errorf("unexpect ErrExist: %v", err)
+	}
+	if !errors.Is(err, Errno{2}) {
+		t.Errorf("expect Errno{2}: %v", err)
+	}
+	var errno Errno
+	if !errors.As(err, &errno) || errno.Status() != 2 {
+		t.Errorf("expect errno 2: %v", err)
+	}
+	if err.Error() != "fdfs download group1/M00/00/00/a.txt [127.0.0.1:23000]: errno [2] file not found" {
+		t.Errorf("unexpect message: %s", err.Error())
+	}
+	if errors.Is(Errno{99}, ErrNotFound) {
+		t.Error("unknown errno should not match")
+	}
+}
+
+func TestProtocolErrorIs(t *testing.T) {
+	err := opError("query_file_info", "", nil, newProtocolError("response length is %d", 3))
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("expect ErrProtocol: %v", err)
+	}
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != "query_file_info" {
+		t.Errorf("expect OpError: %v", err)
+	}
+	if opError("upload", "", nil, nil) != nil {
+		t.Error("nil error should stay nil")
+	}
+}
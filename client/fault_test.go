@@ -0,0 +1,276 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func TestFaultStatusRetry(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.InjectFault(STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{Status: 16, Times: 1})
+	if _, err = fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expect ErrBusy without retry: %v", err)
+	}
+
+	server.InjectFault(STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{Status: 16, Times: 2})
+	fdfsClient.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	if _, err = fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0); err != nil {
+		t.Fatalf("expect success after retry: %v", err)
+	}
+	if hits := server.FaultHits(STORAGE_PROTO_CMD_DOWNLOAD_FILE); hits != 2 {
+		t.Errorf("expect 2 fault hits, actual %d", hits)
+	}
+
+	server.InjectFault(TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE, fdfstest.Fault{Status: 11, Times: 1})
+	if _, err = fdfsClient.UploadByBuffer([]byte("hello"), "txt"); err != nil {
+		t.Fatalf("expect tracker query retried: %v", err)
+	}
+}
+
+func TestFaultBadResponse(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appenderResponse, err := fdfsClient.UploadAppenderByBuffer([]byte("hello"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	faults := []struct {
+		cmd   byte
+		fault fdfstest.Fault
+		call  func() error
+	}{
+		{STORAGE_PROTO_CMD_QUERY_FILE_INFO, fdfstest.Fault{PkgLenDelta: -5, Times: 1}, func() error {
+			_, err := fdfsClient.QueryFileInfo(uploadResponse.RemoteFileID)
+			return err
+		}},
+		// 超大的 pkgLen 在分配缓冲区前被拒绝, 不能 panic 或者分配过大的内存
+		{STORAGE_PROTO_CMD_QUERY_FILE_INFO, fdfstest.Fault{PkgLenDelta: 1 << 60, Times: 1}, func() error {
+			_, err := fdfsClient.QueryFileInfo(uploadResponse.RemoteFileID)
+			return err
+		}},
+		{STORAGE_PROTO_CMD_GET_METADATA, fdfstest.Fault{PkgLenDelta: 1 << 60, Times: 1}, func() error {
+			_, err := fdfsClient.GetMetadata(uploadResponse.RemoteFileID)
+			return err
+		}},
+		{STORAGE_PROTO_CMD_UPLOAD_FILE, fdfstest.Fault{PkgLenDelta: 1 << 60, Times: 1}, func() error {
+			_, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+			return err
+		}},
+		{STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{PkgLenDelta: 1 << 60, Times: 1}, func() error {
+			_, err := fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 2)
+			return err
+		}},
+		{TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE, fdfstest.Fault{PkgLenDelta: 1 << 60, Times: 1}, func() error {
+			_, err := fdfsClient.QueryFileInfo(uploadResponse.RemoteFileID)
+			return err
+		}},
+		{TRACKER_PROTO_CMD_SERVER_LIST_ALL_GROUPS, fdfstest.Fault{PkgLenDelta: 1 << 60, Times: 1}, func() error {
+			_, err := fdfsClient.ListGroups()
+			return err
+		}},
+		// 没有响应体的命令
+		{STORAGE_PROTO_CMD_APPEND_FILE, fdfstest.Fault{PkgLenDelta: 1, Times: 1}, func() error {
+			return fdfsClient.AppendByBuffer([]byte("hello"), appenderResponse.RemoteFileID)
+		}},
+		{STORAGE_PROTO_CMD_SET_METADATA, fdfstest.Fault{PkgLenDelta: 1, Times: 1}, func() error {
+			return fdfsClient.SetMetadata(uploadResponse.RemoteFileID, map[string]string{"k": "v"}, STORAGE_SET_METADATA_FLAG_MERGE)
+		}},
+		{STORAGE_PROTO_CMD_DELETE_FILE, fdfstest.Fault{PkgLenDelta: 1, Times: 1}, func() error {
+			resp, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+			if err != nil {
+				return err
+			}
+			return fdfsClient.DeleteFile(resp.RemoteFileID)
+		}},
+	}
+	for i, f := range faults {
+		server.InjectFault(f.cmd, f.fault)
+		if err := f.call(); !errors.Is(err, ErrProtocol) {
+			t.Errorf("fault %d: expect ErrProtocol, actual %v", i, err)
+		}
+		// 出错的连接不能放回连接池
+		if err := f.call(); err != nil {
+			t.Errorf("fault %d: expect success after fault, actual %v", i, err)
+		}
+	}
+}
+
+// TestFaultTruncated 响应体没有读完连接就断开, 返回可以重试的 io.ErrUnexpectedEOF, 不是 ErrProtocol
+func TestFaultTruncated(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello world"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func() error {
+		_, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+		return err
+	}
+	download := func() error {
+		dr, err := fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0)
+		if err == nil && string(dr.Content.([]byte)) != "hello world" {
+			err = fmt.Errorf("unexpected content %q", dr.Content)
+		}
+		return err
+	}
+	downloadFile := func() error {
+		_, err := fdfsClient.DownloadToFile(filepath.Join(t.TempDir(), "download.txt"), uploadResponse.RemoteFileID, 0, 0)
+		return err
+	}
+	getMetadata := func() error {
+		_, err := fdfsClient.GetMetadata(uploadResponse.RemoteFileID)
+		return err
+	}
+
+	faults := []struct {
+		cmd   byte
+		fault fdfstest.Fault
+		call  func() error
+		// retried 操作是否按重试策略重试, 上传不是幂等的, 只返回可以重试的错误
+		retried bool
+	}{
+		{STORAGE_PROTO_CMD_UPLOAD_FILE, fdfstest.Fault{PartialBody: 10, Times: 1}, upload, false},
+		{STORAGE_PROTO_CMD_UPLOAD_FILE, fdfstest.Fault{PartialBody: 20, Times: 1}, upload, false},
+		{STORAGE_PROTO_CMD_UPLOAD_FILE, fdfstest.Fault{PkgLenDelta: 5, Times: 1}, upload, false},
+		{STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{PartialBody: 3, Times: 1}, download, true},
+		{STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{PkgLenDelta: 5, Times: 1}, download, true},
+		{STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{PartialBody: 3, Times: 1}, downloadFile, true},
+		// 下载整个文件时长度没有上限, 超大的 pkgLen 读到连接关闭为止
+		{STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{PkgLenDelta: 1 << 60, Times: 1}, download, true},
+		{STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{ResetMidBody: true, Times: 1}, download, true},
+		{TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE, fdfstest.Fault{PartialBody: 30, Times: 1}, getMetadata, true},
+	}
+	for i, f := range faults {
+		fdfsClient.SetRetryPolicy(nil)
+		server.InjectFault(f.cmd, f.fault)
+		err := f.call()
+		if errors.Is(err, ErrProtocol) || !IsRetryable(err) {
+			t.Errorf("fault %d: expect retryable error, actual %v", i, err)
+		}
+		if !f.fault.ResetMidBody && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("fault %d: expect io.ErrUnexpectedEOF, actual %v", i, err)
+		}
+		if !f.retried {
+			continue
+		}
+
+		server.InjectFault(f.cmd, f.fault)
+		fdfsClient.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+		if err = f.call(); err != nil {
+			t.Errorf("fault %d: expect success after retry, actual %v", i, err)
+		}
+		if hits := server.FaultHits(f.cmd); hits != 1 {
+			t.Errorf("fault %d: expect 1 fault hit, actual %d", i, hits)
+		}
+	}
+}
+
+// failingStream 读取出错的流, 在包头发出后失败
+type failingStream struct{}
+
+func (failingStream) ReadAt(p []byte, off int64) (int, error)      { return 0, errors.New("read failed") }
+func (failingStream) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+
+func TestFaultKeepAlive(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	logger := &recordingLogger{}
+	fdfsClient.SetLogger(logger)
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(t.TempDir(), "empty.txt")
+	if err = ioutil.WriteFile(emptyFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	faults := []struct {
+		cmd   byte
+		fault fdfstest.Fault
+		call  func() error
+	}{
+		{STORAGE_PROTO_CMD_QUERY_FILE_INFO, fdfstest.Fault{PkgLenDelta: 5, KeepAlive: true, Times: 1}, nil},
+		{STORAGE_PROTO_CMD_QUERY_FILE_INFO, fdfstest.Fault{PkgLenDelta: -5, KeepAlive: true, Times: 1}, nil},
+		{STORAGE_PROTO_CMD_QUERY_FILE_INFO, fdfstest.Fault{Status: 2, Body: []byte("not found"), Times: 1}, nil},
+		{0, fdfstest.Fault{}, func() error {
+			_, err := fdfsClient.UploadByStream(failingStream{}, 1024, "bin")
+			return err
+		}},
+		{0, fdfstest.Fault{}, func() error {
+			_, err := fdfsClient.UploadByFilename(emptyFile)
+			return err
+		}},
+	}
+	for i, f := range faults {
+		if f.call == nil {
+			server.InjectFault(f.cmd, f.fault)
+			f.call = func() error {
+				_, err := fdfsClient.QueryFileInfo(uploadResponse.RemoteFileID)
+				return err
+			}
+		}
+		if err = f.call(); err == nil {
+			t.Errorf("fault %d: expect error", i)
+		}
+		// 请求发出后出错的连接不能放回连接池, 之后的请求不能读到上一次残留的数据
+		for j := 0; j < 3; j++ {
+			if _, err = fdfsClient.QueryFileInfo(uploadResponse.RemoteFileID); err != nil {
+				t.Errorf("fault %d: expect success after fault, actual %v", i, err)
+			}
+			if _, err = fdfsClient.UploadByBuffer([]byte("hello"), "txt"); err != nil {
+				t.Errorf("fault %d: expect upload success after fault, actual %v", i, err)
+			}
+		}
+		// 复用前的 active test 只是兜底, 不同步的连接应该在出错时直接关闭
+		if n := logger.count("INFO fdfs stale connection discarded"); n != 0 {
+			t.Fatalf("fault %d: desynchronized connection returned to pool", i)
+		}
+	}
+}
+
+func TestFaultConnection(t *testing.T) {
+	fdfsClient, server := newTestClient(t, func(cfg *ClientConfig) {
+		cfg.NetworkTimeout = 100 * time.Millisecond
+	})
+
+	server.InjectFault(STORAGE_PROTO_CMD_UPLOAD_FILE, fdfstest.Fault{ResetMidBody: true, Times: 1})
+	_, err := fdfsClient.UploadByBuffer(make([]byte, 1024), "bin")
+	if err == nil || !IsRetryable(err) {
+		t.Fatalf("expect retryable error after reset: %v", err)
+	}
+	uploadResponse, err := fdfsClient.UploadByBuffer(make([]byte, 1024), "bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.InjectFault(TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE, fdfstest.Fault{Delay: 300 * time.Millisecond, Times: 1})
+	_, err = fdfsClient.QueryFileInfo(uploadResponse.RemoteFileID)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expect timeout: %v", err)
+	}
+
+	server.InjectFault(TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE, fdfstest.Fault{Delay: 300 * time.Millisecond, Times: 1})
+	fdfsClient.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+	if _, err = fdfsClient.QueryFileInfo(uploadResponse.RemoteFileID); err != nil {
+		t.Fatalf("expect success after retry: %v", err)
+	}
+}
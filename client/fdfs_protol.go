@@ -3,9 +3,12 @@ package client
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
@@ -126,7 +129,13 @@ const (
 	FDFS_TRUNK_FILENAME_LENGTH       = (FDFS_TRUE_FILE_PATH_LEN + FDFS_FILENAME_BASE64_LENGTH + FDFS_TRUNK_FILE_INFO_LEN + 1 + FDFS_FILE_EXT_NAME_MAX_LEN)
 	FDFS_TRUNK_LOGIC_FILENAME_LENGTH = (FDFS_TRUNK_FILENAME_LENGTH + (FDFS_LOGIC_FILE_PATH_LEN - FDFS_TRUE_FILE_PATH_LEN))
 
-	FDFS_VERSION_SIZE = 6
+	FDFS_VERSION_SIZE         = 6
+	FDFS_REMOTE_NAME_MAX_SIZE = 128
+	FDFS_STORAGE_ID_MAX_SIZE  = 16
+
+	// list groups 和 list storage 每条记录的长度, 与 v4.06 的 TrackerGroupStat 和 TrackerStorageStat 一致
+	TRACKER_GROUP_STAT_SIZE   = (FDFS_GROUP_NAME_MAX_LEN + 1 + 11*FDFS_PROTO_PKG_LEN_SIZE)
+	TRACKER_STORAGE_STAT_SIZE = (1 + 2*FDFS_STORAGE_ID_MAX_SIZE + IP_ADDRESS_SIZE + FDFS_DOMAIN_NAME_MAX_LEN + FDFS_VERSION_SIZE + 52*FDFS_PROTO_PKG_LEN_SIZE + 1)
 
 	TRACKER_QUERY_STORAGE_FETCH_BODY_LEN = (FDFS_GROUP_NAME_MAX_LEN + IP_ADDRESS_SIZE - 1 + FDFS_PROTO_PKG_LEN_SIZE)
 	TRACKER_QUERY_STORAGE_STORE_BODY_LEN = (FDFS_GROUP_NAME_MAX_LEN + IP_ADDRESS_SIZE - 1 + FDFS_PROTO_PKG_LEN_SIZE + 1)
@@ -183,7 +192,7 @@ func (tracker *trackerHeader) marshal() ([]byte, error) {
 
 func (tracker *trackerHeader) unmarshal(data []byte) error {
 	if len(data) != 10 {
-		return errors.New("data less than 10")
+		return newProtocolError("header length is %d, expect 10", len(data))
 	}
 	buff := bytes.NewBuffer(data)
 	binary.Read(buff, binary.BigEndian, &tracker.pkgLen)
@@ -194,21 +203,47 @@ func (tracker *trackerHeader) unmarshal(data []byte) error {
 	return nil
 }
 
-func (tracker *trackerHeader) sendHeader(conn net.Conn) {
+func (tracker *trackerHeader) sendHeader(conn net.Conn) error {
 	buf, _ := tracker.marshal()
-	conn.Write(buf)
+	if dump, addr := wireDumpOf(conn); dump != nil {
+		dump.header(">", addr, tracker, nil)
+	}
+	_, err := conn.Write(buf)
+	return err
 }
 
-func (tracker *trackerHeader) recvHeader(conn net.Conn) {
+func (tracker *trackerHeader) recvHeader(conn net.Conn) error {
 	buf := make([]byte, 10)
 	_, err := io.ReadFull(conn, buf)
 	if err != nil {
-		return
+		return err
 	}
 	err = tracker.unmarshal(buf)
 	if err != nil {
-		return
+		return err
+	}
+	if dump, addr := wireDumpOf(conn); dump != nil {
+		dump.header("<", addr, tracker, buf)
+	}
+	if tracker.pkgLen < 0 {
+		return newProtocolError("negative package length %d", tracker.pkgLen)
 	}
+	// 出错时调用方不读取响应体, 带响应体的错误响应之后连接不能复用
+	if tracker.status != 0 && tracker.pkgLen > 0 {
+		markBroken(conn)
+	}
+	return nil
+}
+
+// maxMetadataSize 元数据响应的长度上限
+const maxMetadataSize = 16 * 1024 * 1024
+
+// checkPkgLen 分配缓冲区前检查响应体长度在 [min, max] 之间
+func (tracker *trackerHeader) checkPkgLen(min, max int64) error {
+	if tracker.pkgLen < min || tracker.pkgLen > max {
+		return newProtocolError("package length %d out of range [%d, %d]", tracker.pkgLen, min, max)
+	}
+	return nil
 }
 
 type uploadFileRequest struct {
@@ -277,6 +312,35 @@ func (tracker *uploadSlaveFileRequest) marshal() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
+type createLinkRequest struct {
+	groupName      string
+	masterFilename string
+	srcFilename    string
+	srcSignature   []byte
+	prefixName     string
+	fileExtName    string
+}
+
+// #link_fmt: |-master_len(8)-src_len(8)-src_sig_len(8)-group_name(16)-prefix_name(16)-file_ext_name(6)
+// #           -master_filename(master_len)-src_filename(src_len)-src_sig(src_sig_len)-|
+func (req *createLinkRequest) marshal() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, int64(len(req.masterFilename)))
+	binary.Write(buffer, binary.BigEndian, int64(len(req.srcFilename)))
+	binary.Write(buffer, binary.BigEndian, int64(len(req.srcSignature)))
+
+	fixed := make([]byte, FDFS_GROUP_NAME_MAX_LEN+FDFS_FILE_PREFIX_MAX_LEN+FDFS_FILE_EXT_NAME_MAX_LEN)
+	copy(fixed, req.groupName)
+	copy(fixed[FDFS_GROUP_NAME_MAX_LEN:FDFS_GROUP_NAME_MAX_LEN+FDFS_FILE_PREFIX_MAX_LEN], req.prefixName)
+	copy(fixed[FDFS_GROUP_NAME_MAX_LEN+FDFS_FILE_PREFIX_MAX_LEN:], req.fileExtName)
+	buffer.Write(fixed)
+
+	buffer.WriteString(req.masterFilename)
+	buffer.WriteString(req.srcFilename)
+	buffer.Write(req.srcSignature)
+	return buffer.Bytes(), nil
+}
+
 // UploadFileResponse 上传文件返回
 type UploadFileResponse struct {
 	GroupName    string
@@ -378,3 +442,167 @@ type DownloadFileResponse struct {
 	Content      interface{}
 	DownloadSize int64
 }
+
+type appendFileRequest struct {
+	appenderFilename string
+	fileSize         int64
+}
+
+// #append_fmt: |-appender_filename_len(8)-file_size(8)-appender_filename(len)-|
+func (req *appendFileRequest) marshal() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, int64(len(req.appenderFilename)))
+	binary.Write(buffer, binary.BigEndian, req.fileSize)
+	buffer.WriteString(req.appenderFilename)
+	return buffer.Bytes(), nil
+}
+
+type modifyFileRequest struct {
+	appenderFilename string
+	fileOffset       int64
+	fileSize         int64
+}
+
+// #modify_fmt: |-appender_filename_len(8)-file_offset(8)-file_size(8)-appender_filename(len)-|
+func (req *modifyFileRequest) marshal() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, int64(len(req.appenderFilename)))
+	binary.Write(buffer, binary.BigEndian, req.fileOffset)
+	binary.Write(buffer, binary.BigEndian, req.fileSize)
+	buffer.WriteString(req.appenderFilename)
+	return buffer.Bytes(), nil
+}
+
+type truncateFileRequest struct {
+	appenderFilename  string
+	truncatedFileSize int64
+}
+
+// #truncate_fmt: |-appender_filename_len(8)-truncated_file_size(8)-appender_filename(len)-|
+func (req *truncateFileRequest) marshal() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, int64(len(req.appenderFilename)))
+	binary.Write(buffer, binary.BigEndian, req.truncatedFileSize)
+	buffer.WriteString(req.appenderFilename)
+	return buffer.Bytes(), nil
+}
+
+type queryFileInfoRequest struct {
+	groupName      string
+	remoteFilename string
+}
+
+// #query_fmt: |-group_name(16)-filename(len)-|
+func (req *queryFileInfoRequest) marshal() ([]byte, error) {
+	del := &deleteFileRequest{groupName: req.groupName, remoteFilename: req.remoteFilename}
+	return del.marshal()
+}
+
+// FileInfo 文件信息
+type FileInfo struct {
+	FileSize        int64
+	CreateTimestamp time.Time
+	CRC32           uint32
+	SourceIPAddr    string
+}
+
+// recv_fmt: |-file_size(8)-create_timestamp(8)-crc32(8)-source_ip_addr(16)-|
+func (info *FileInfo) unmarshal(data []byte) error {
+	if len(data) < 3*FDFS_PROTO_PKG_LEN_SIZE+IP_ADDRESS_SIZE {
+		return newProtocolError("file info length is %d, expect %d", len(data), 3*FDFS_PROTO_PKG_LEN_SIZE+IP_ADDRESS_SIZE)
+	}
+	var (
+		createTimestamp int64
+		crc             int64
+	)
+	buff := bytes.NewBuffer(data)
+	binary.Read(buff, binary.BigEndian, &info.FileSize)
+	binary.Read(buff, binary.BigEndian, &createTimestamp)
+	binary.Read(buff, binary.BigEndian, &crc)
+	info.CreateTimestamp = time.Unix(createTimestamp, 0)
+	info.CRC32 = uint32(crc)
+	var err error
+	info.SourceIPAddr, err = readCstr(buff, IP_ADDRESS_SIZE)
+	return err
+}
+
+type setMetadataRequest struct {
+	groupName      string
+	remoteFilename string
+	opFlag         byte
+	metaBuff       []byte
+}
+
+// #set_meta_fmt: |-filename_len(8)-meta_len(8)-op_flag(1)-group_name(16)-filename(len)-meta(meta_len)-|
+func (req *setMetadataRequest) marshal() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, int64(len(req.remoteFilename)))
+	binary.Write(buffer, binary.BigEndian, int64(len(req.metaBuff)))
+	buffer.WriteByte(req.opFlag)
+
+	// 16 bit groupName
+	groupNameBytes := bytes.NewBufferString(req.groupName).Bytes()
+	for i := 0; i < 16; i++ {
+		if i >= len(groupNameBytes) {
+			buffer.WriteByte(byte(0))
+		} else {
+			buffer.WriteByte(groupNameBytes[i])
+		}
+	}
+	buffer.WriteString(req.remoteFilename)
+	buffer.Write(req.metaBuff)
+	return buffer.Bytes(), nil
+}
+
+type getMetadataRequest struct {
+	groupName      string
+	remoteFilename string
+}
+
+// #get_meta_fmt: |-group_name(16)-filename(len)-|
+func (req *getMetadataRequest) marshal() ([]byte, error) {
+	del := &deleteFileRequest{groupName: req.groupName, remoteFilename: req.remoteFilename}
+	return del.marshal()
+}
+
+// meta_fmt: |-name-FDFS_FIELD_SEPERATOR-value-FDFS_RECORD_SEPERATOR-name...|
+func packMetadata(meta map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		if len(name) == 0 || len(name) > FDFS_MAX_META_NAME_LEN {
+			return nil, fmt.Errorf("%w: metadata name [%s]", ErrInvalidArgument, name)
+		}
+		if len(meta[name]) > FDFS_MAX_META_VALUE_LEN {
+			return nil, fmt.Errorf("%w: metadata value of [%s] too long", ErrInvalidArgument, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buffer := new(bytes.Buffer)
+	for i, name := range names {
+		if i > 0 {
+			buffer.WriteByte(FDFS_RECORD_SEPERATOR)
+		}
+		buffer.WriteString(name)
+		buffer.WriteByte(FDFS_FIELD_SEPERATOR)
+		buffer.WriteString(meta[name])
+	}
+	return buffer.Bytes(), nil
+}
+
+func unpackMetadata(data []byte) map[string]string {
+	meta := make(map[string]string)
+	if len(data) == 0 {
+		return meta
+	}
+	for _, record := range strings.Split(string(data), string(FDFS_RECORD_SEPERATOR)) {
+		fields := strings.SplitN(record, string(FDFS_FIELD_SEPERATOR), 2)
+		if len(fields) == 2 {
+			meta[fields[0]] = fields[1]
+		} else if len(fields[0]) > 0 {
+			meta[fields[0]] = ""
+		}
+	}
+	return meta
+}
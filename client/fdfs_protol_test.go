@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestFileInfoUnmarshal(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, int64(1024))
+	binary.Write(buffer, binary.BigEndian, int64(1500000000))
+	binary.Write(buffer, binary.BigEndian, int64(0xdeadbeef))
+	ip := make([]byte, IP_ADDRESS_SIZE)
+	copy(ip, "10.0.1.32")
+	buffer.Write(ip)
+
+	info := &FileInfo{}
+	if err := info.unmarshal(buffer.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if info.FileSize != 1024 || info.CreateTimestamp.Unix() != 1500000000 ||
+		info.CRC32 != 0xdeadbeef || info.SourceIPAddr != "10.0.1.32" {
+		t.Errorf("unexpected file info %+v", info)
+	}
+
+	if err := info.unmarshal(buffer.Bytes()[:20]); err == nil {
+		t.Error("short file info should fail")
+	}
+}
+
+func TestModifyFileRequestMarshal(t *testing.T) {
+	req := &modifyFileRequest{appenderFilename: "M00/00/00/a.txt", fileOffset: 5, fileSize: 7}
+	data, err := req.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3*FDFS_PROTO_PKG_LEN_SIZE+len(req.appenderFilename) {
+		t.Fatalf("unexpected length %d", len(data))
+	}
+	if binary.BigEndian.Uint64(data[0:8]) != uint64(len(req.appenderFilename)) ||
+		binary.BigEndian.Uint64(data[8:16]) != 5 || binary.BigEndian.Uint64(data[16:24]) != 7 ||
+		string(data[24:]) != req.appenderFilename {
+		t.Errorf("unexpected request %v", data)
+	}
+}
+
+func TestMetadataPack(t *testing.T) {
+	meta := map[string]string{"width": "1024", "height": "768", "author": ""}
+	data, err := packMetadata(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "author\x02\x01height\x02768\x01width\x021024" {
+		t.Errorf("unexpected metadata %q", data)
+	}
+	unpacked := unpackMetadata(data)
+	if len(unpacked) != len(meta) {
+		t.Fatalf("unexpected metadata %v", unpacked)
+	}
+	for name, value := range meta {
+		if unpacked[name] != value {
+			t.Errorf("metadata %s: expect %q, actual %q", name, value, unpacked[name])
+		}
+	}
+
+	if _, err = packMetadata(map[string]string{"": "x"}); err == nil {
+		t.Error("empty metadata name should fail")
+	}
+}
+
+func TestUploadSlaveFileRequestMarshal(t *testing.T) {
+	req := &uploadSlaveFileRequest{masterFilenameLen: 15, fileSize: 3, prefixName: "_150x150",
+		fileExtName: "jpg", masterFilename: "M00/00/00/a.jpg"}
+	data, err := req.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 38+15 {
+		t.Fatalf("unexpected length %d", len(data))
+	}
+	if binary.BigEndian.Uint64(data[0:8]) != 15 || binary.BigEndian.Uint64(data[8:16]) != 3 ||
+		string(bytes.TrimRight(data[16:32], "\x00")) != "_150x150" ||
+		string(bytes.TrimRight(data[32:38], "\x00")) != "jpg" || string(data[38:]) != "M00/00/00/a.jpg" {
+		t.Errorf("unexpected request %q", data)
+	}
+}
+
+func TestSlaveUploadRequestValidate(t *testing.T) {
+	valid := SlaveUploadRequest{MasterFileID: "group1/M00/00/00/a.jpg", PrefixName: "_150x150", Source: []byte("abc")}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	invalids := []SlaveUploadRequest{valid, valid, valid, valid}
+	invalids[0].MasterFileID = "a.jpg"
+	invalids[1].PrefixName = ""
+	invalids[2].PrefixName = "_01234567890123456"
+	invalids[3].Source = 123
+	for _, req := range invalids {
+		if err := req.Validate(); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("expect invalid argument for %+v: %v", req, err)
+		}
+	}
+}
+
+func TestCreateLinkRequestMarshal(t *testing.T) {
+	req := &createLinkRequest{groupName: "group1", masterFilename: "M00/00/00/m.jpg", srcFilename: "M00/00/00/s.jpg",
+		srcSignature: []byte("sig"), prefixName: "_alias", fileExtName: "jpg"}
+	data, err := req.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 24+16+16+6+15+15+3 {
+		t.Fatalf("unexpected length %d", len(data))
+	}
+	if binary.BigEndian.Uint64(data[0:8]) != 15 || binary.BigEndian.Uint64(data[8:16]) != 15 ||
+		binary.BigEndian.Uint64(data[16:24]) != 3 ||
+		string(bytes.TrimRight(data[24:40], "\x00")) != "group1" ||
+		string(bytes.TrimRight(data[40:56], "\x00")) != "_alias" ||
+		string(bytes.TrimRight(data[56:62], "\x00")) != "jpg" ||
+		string(data[62:]) != "M00/00/00/m.jpgM00/00/00/s.jpgsig" {
+		t.Errorf("unexpected request %q", data)
+	}
+
+	link := &LinkRequest{SourceFileID: "group1/M00/00/00/s.jpg", MasterFileID: "group2/M00/00/00/m.jpg", PrefixName: "_alias"}
+	if err := link.Validate(); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expect group mismatch error: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package client
+
+// FileStore 文件存储接口, 包含上传, 下载, 删除, 元数据和文件信息
+// FdfsClient 实现了该接口, 本地开发和单元测试可以使用 localfs 包中基于本地磁盘的实现
+type FileStore interface {
+	UploadByFilename(filename string, groupName ...string) (*UploadFileResponse, error)
+	UploadByBuffer(filebuffer []byte, fileExtName string, groupName ...string) (*UploadFileResponse, error)
+	UploadByStream(stream ReadStream, size int64, fileExtName string, groupName ...string) (*UploadFileResponse, error)
+	DownloadToFile(localFilename string, remoteFileID string, offset int64, downloadSize int64) (*DownloadFileResponse, error)
+	DownloadToBuffer(remoteFileID string, offset int64, downloadSize int64) (*DownloadFileResponse, error)
+	DeleteFile(remoteFileID string) error
+	SetMetadata(remoteFileID string, meta map[string]string, opFlag byte) error
+	GetMetadata(remoteFileID string) (map[string]string, error)
+	QueryFileInfo(remoteFileID string) (*FileInfo, error)
+}
+
+var _ FileStore = (*FdfsClient)(nil)
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+)
+
+var (
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// FS 以远端文件ID为文件名的 io/fs.FS 实现
+// groupName 不为空时, 文件名为组内的文件名(不含组名)
+// fastdfs 不能列出文件, 根目录 "." 是一个空目录
+type FS struct {
+	client    *FdfsClient
+	groupName string
+}
+
+// NewFS 新建 io/fs.FS
+func NewFS(client *FdfsClient, groupName string) *FS {
+	return &FS{client: client, groupName: groupName}
+}
+
+// HTTPFileSystem 转为 http.FileSystem
+func (fsys *FS) HTTPFileSystem() http.FileSystem {
+	return http.FS(fsys)
+}
+
+// Open 打开文件
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return &fsDir{}, nil
+	}
+	remoteFileID, err := fsys.remoteFileID("open", name)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := fsys.client.Open(context.Background(), remoteFileID)
+	if err != nil {
+		return nil, fsPathError("open", name, err)
+	}
+	return &fsFile{RemoteFile: rf, name: name}, nil
+}
+
+// Stat 文件信息
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return fsDirInfo{}, nil
+	}
+	remoteFileID, err := fsys.remoteFileID("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fsys.client.QueryFileInfo(remoteFileID)
+	if err != nil {
+		return nil, fsPathError("stat", name, err)
+	}
+	return &fsFileInfo{name: path.Base(name), info: info}, nil
+}
+
+// ReadFile 读取整个文件
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	if name == "." {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	remoteFileID, err := fsys.remoteFileID("read", name)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := fsys.client.DownloadToBuffer(remoteFileID, 0, 0)
+	if err != nil {
+		return nil, fsPathError("read", name, err)
+	}
+	buffer, _ := dr.Content.([]byte)
+	return buffer, nil
+}
+
+func (fsys *FS) remoteFileID(op, name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	remoteFileID := name
+	if len(fsys.groupName) > 0 {
+		remoteFileID = fsys.groupName + "/" + name
+	}
+	if _, err := splitRemoteFileID(remoteFileID); err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return remoteFileID, nil
+}
+
+var errIsDir = errors.New("is a directory")
+
+func fsPathError(op, name string, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+type fsFile struct {
+	*RemoteFile
+	name string
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return &fsFileInfo{name: path.Base(f.name), info: f.Info()}, nil
+}
+
+type fsFileInfo struct {
+	name string
+	info *FileInfo
+}
+
+func (fi *fsFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fsFileInfo) Size() int64 {
+	return fi.info.FileSize
+}
+
+func (fi *fsFileInfo) Mode() fs.FileMode {
+	return 0444
+}
+
+func (fi *fsFileInfo) ModTime() time.Time {
+	return fi.info.CreateTimestamp
+}
+
+func (fi *fsFileInfo) IsDir() bool {
+	return false
+}
+
+func (fi *fsFileInfo) Sys() interface{} {
+	return fi.info
+}
+
+// fsDir 根目录
+type fsDir struct{}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return fsDirInfo{}, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errIsDir}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n > 0 {
+		return nil, io.EOF
+	}
+	return nil, nil
+}
+
+type fsDirInfo struct{}
+
+func (fsDirInfo) Name() string {
+	return "."
+}
+
+func (fsDirInfo) Size() int64 {
+	return 0
+}
+
+func (fsDirInfo) Mode() fs.FileMode {
+	return fs.ModeDir | 0555
+}
+
+func (fsDirInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (fsDirInfo) IsDir() bool {
+	return true
+}
+
+func (fsDirInfo) Sys() interface{} {
+	return nil
+}
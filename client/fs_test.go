@@ -0,0 +1,93 @@
+package client
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	data := []byte("hello fs")
+	uploadResponse, err := fdfsClient.UploadByBuffer(data, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimPrefix(uploadResponse.RemoteFileID, uploadResponse.GroupName+"/")
+
+	for _, c := range []struct {
+		fsys *FS
+		name string
+	}{
+		{NewFS(fdfsClient, ""), uploadResponse.RemoteFileID},
+		{NewFS(fdfsClient, uploadResponse.GroupName), name},
+	} {
+		f, err := c.fsys.Open(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(f)
+		_ = f.Close()
+		if err != nil || string(content) != string(data) {
+			t.Errorf("%s: unexpected content %q %v", c.name, content, err)
+		}
+
+		info, err := c.fsys.Stat(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(data)) || info.IsDir() || info.Name() != name[strings.LastIndex(name, "/")+1:] {
+			t.Errorf("%s: unexpected info %s %d", c.name, info.Name(), info.Size())
+		}
+
+		if content, err = c.fsys.ReadFile(c.name); err != nil || string(content) != string(data) {
+			t.Errorf("%s: unexpected content %q %v", c.name, content, err)
+		}
+	}
+
+	fsys := NewFS(fdfsClient, uploadResponse.GroupName)
+	for _, c := range []struct {
+		name string
+		err  error
+	}{
+		{"M00/00/00/missing.txt", fs.ErrNotExist},
+		{"/" + name, fs.ErrInvalid},
+		{"../" + name, fs.ErrInvalid},
+	} {
+		if _, err = fsys.Open(c.name); !errors.Is(err, c.err) {
+			t.Errorf("open %s: expect %v, actual %v", c.name, c.err, err)
+		}
+		if _, err = fsys.Stat(c.name); !errors.Is(err, c.err) {
+			t.Errorf("stat %s: expect %v, actual %v", c.name, c.err, err)
+		}
+	}
+	if _, err = NewFS(fdfsClient, "").Open("group1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expect ErrNotExist: %v", err)
+	}
+
+	// 根目录是空目录
+	if err = fstest.TestFS(fsys); err != nil {
+		t.Error(err)
+	}
+	if _, err = fsys.ReadFile("."); err == nil {
+		t.Error("expect error reading directory")
+	}
+
+	server := httptest.NewServer(http.FileServer(fsys.HTTPFileSystem()))
+	defer server.Close()
+	for path, status := range map[string]int{"/": http.StatusOK, "/" + name: http.StatusOK, "/M00/00/00/missing.txt": http.StatusNotFound} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expect status %d, actual %d", path, status, resp.StatusCode)
+		}
+	}
+}
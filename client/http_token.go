@@ -0,0 +1,112 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jslyzt/goconfig/config"
+)
+
+const defaultTokenTTL = 900 * time.Second
+
+var (
+	// ErrTokenInvalid 防盗链token错误
+	ErrTokenInvalid = errors.New("invalid anti-steal token")
+	// ErrTokenExpired 防盗链token过期
+	ErrTokenExpired = errors.New("anti-steal token expired")
+)
+
+// AntiSteal 防盗链token, 对应 http.conf 中的 http.anti_steal.* 配置
+type AntiSteal struct {
+	SecretKey string
+	TokenTTL  time.Duration
+}
+
+// GetAntiStealConf 解析防盗链配置, 未开启 http.anti_steal.check_token 时返回 nil
+func GetAntiStealConf(confPath, confData string) (*AntiSteal, error) {
+	cf, err := readConf(confPath, confData)
+	if err != nil {
+		return nil, err
+	}
+	return antiStealFromConf(cf)
+}
+
+func antiStealFromConf(cf *config.Config) (*AntiSteal, error) {
+	if checkToken, err := cf.Bool("DEFAULT", "http.anti_steal.check_token"); err != nil || !checkToken {
+		return nil, nil
+	}
+	secretKey, _ := cf.RawString("DEFAULT", "http.anti_steal.secret_key")
+	if len(secretKey) == 0 {
+		return nil, errors.New("http.anti_steal.secret_key is empty")
+	}
+	as := &AntiSteal{SecretKey: secretKey, TokenTTL: defaultTokenTTL}
+	ttl, err := confSeconds(cf, "http.anti_steal.token_ttl", defaultTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		as.TokenTTL = ttl
+	}
+	return as, nil
+}
+
+// GenHTTPToken 生成防盗链token: md5(不含组名的文件名 + secretKey + 时间戳)
+func GenHTTPToken(remoteFileID, secretKey string, ts int64) string {
+	filename := remoteFileID
+	if tmp, err := splitRemoteFileID(remoteFileID); err == nil {
+		filename = tmp[1]
+	}
+	sum := md5.Sum([]byte(filename + secretKey + strconv.FormatInt(ts, 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Token 生成防盗链token
+func (as *AntiSteal) Token(remoteFileID string, ts int64) string {
+	return GenHTTPToken(remoteFileID, as.SecretKey, ts)
+}
+
+// SignURL 为下载地址添加 token 和 ts 参数
+func (as *AntiSteal) SignURL(rawURL, remoteFileID string, now time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	ts := now.Unix()
+	query := u.Query()
+	query.Set("token", as.Token(remoteFileID, ts))
+	query.Set("ts", strconv.FormatInt(ts, 10))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Verify 校验防盗链token
+func (as *AntiSteal) Verify(remoteFileID, token string, ts int64, now time.Time) error {
+	expect := as.Token(remoteFileID, ts)
+	if subtle.ConstantTimeCompare([]byte(expect), []byte(token)) != 1 {
+		return ErrTokenInvalid
+	}
+	ttl := as.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > ttl || age < -ttl {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// VerifyRequest 校验请求中的 token 和 ts 参数
+func (as *AntiSteal) VerifyRequest(r *http.Request, remoteFileID string) error {
+	query := r.URL.Query()
+	ts, err := strconv.ParseInt(query.Get("ts"), 10, 64)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	return as.Verify(remoteFileID, query.Get("token"), ts, time.Now())
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGenHTTPToken(t *testing.T) {
+	// md5("M00/00/00/wKgBaFoAAA.jpg" + "FastDFS1234567890" + "1500000000")
+	token := GenHTTPToken("group1/M00/00/00/wKgBaFoAAA.jpg", "FastDFS1234567890", 1500000000)
+	if token != "7a1f4fef799c7c15e7f0c508e76e7c6e" {
+		t.Errorf("unexpected token %s", token)
+	}
+}
+
+func TestAntiStealVerify(t *testing.T) {
+	as := &AntiSteal{SecretKey: "FastDFS1234567890", TokenTTL: time.Minute}
+	remoteFileID := "group1/M00/00/00/wKgBaFoAAA.jpg"
+	now := time.Unix(1500000000, 0)
+
+	signed, err := as.SignURL("http://img.example.com/"+remoteFileID, remoteFileID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest(http.MethodGet, signed, nil)
+	ts := now.Unix()
+	token := r.URL.Query().Get("token")
+
+	if err = as.Verify(remoteFileID, token, ts, now.Add(30*time.Second)); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+	if err = as.Verify(remoteFileID, token, ts, now.Add(2*time.Minute)); err != ErrTokenExpired {
+		t.Errorf("expect ErrTokenExpired, actual %v", err)
+	}
+	if err = as.Verify("group1/M00/00/00/other.jpg", token, ts, now); err != ErrTokenInvalid {
+		t.Errorf("expect ErrTokenInvalid, actual %v", err)
+	}
+}
+
+func TestGetAntiStealConf(t *testing.T) {
+	as, err := GetAntiStealConf("", "http.anti_steal.check_token=true\nhttp.anti_steal.token_ttl=60\nhttp.anti_steal.secret_key=secret\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if as == nil || as.SecretKey != "secret" || as.TokenTTL != time.Minute {
+		t.Errorf("unexpected anti steal config %+v", as)
+	}
+
+	as, err = GetAntiStealConf("", "http.anti_steal.check_token=false\n")
+	if err != nil || as != nil {
+		t.Errorf("expect disabled anti steal, actual %+v %v", as, err)
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jslyzt/goconfig/config"
+)
+
+const defaultHTTPPort = 80
+
+// HTTPConf http.* 配置
+type HTTPConf struct {
+	// TrackerServerPort http.tracker_server_port, 未配置域名时作为存储服务器的 HTTP 端口
+	TrackerServerPort int
+	// DomainName http.domain_name, 可以带协议, 例如 https://img.example.com
+	DomainName string
+	// AntiSteal http.anti_steal.* 防盗链配置, 未开启时为 nil
+	AntiSteal *AntiSteal
+}
+
+// GetHTTPConf 解析 http.* 配置
+func GetHTTPConf(confPath, confData string) (*HTTPConf, error) {
+	cf, err := readConf(confPath, confData)
+	if err != nil {
+		return nil, err
+	}
+	return httpConfFromConf(cf)
+}
+
+func httpConfFromConf(cf *config.Config) (*HTTPConf, error) {
+	var err error
+	hc := &HTTPConf{TrackerServerPort: defaultHTTPPort}
+	if cf.HasOption("DEFAULT", "http.tracker_server_port") {
+		hc.TrackerServerPort, err = confInt(cf, "http.tracker_server_port", defaultHTTPPort)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hc.DomainName, _ = cf.RawString("DEFAULT", "http.domain_name")
+	hc.AntiSteal, err = antiStealFromConf(cf)
+	if err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+// SetHTTPConf 设置 http 配置
+func (client *FdfsClient) SetHTTPConf(httpConf *HTTPConf) {
+	client.httpConf = httpConf
+}
+
+// URL 生成下载地址
+// 配置了 http.domain_name 时使用域名, 否则使用 tracker 返回的可下载存储服务器,
+// 开启防盗链时附带 token 和 ts 参数
+func (client *FdfsClient) URL(remoteFileID string) (string, error) {
+	tmp, err := splitRemoteFileID(remoteFileID)
+	if err != nil {
+		return "", err
+	}
+	httpConf := client.httpConf
+	if httpConf == nil {
+		httpConf = &HTTPConf{TrackerServerPort: defaultHTTPPort}
+	}
+
+	var base string
+	if len(httpConf.DomainName) > 0 {
+		base = strings.TrimRight(httpConf.DomainName, "/")
+		if !strings.Contains(base, "://") {
+			base = "http://" + base
+		}
+	} else {
+		tc := client.trackerClient()
+		srv, err := tc.trackerQueryStorageFetch(tmp[0], tmp[1])
+		if err != nil {
+			return "", err
+		}
+		host := srv.ipAddr
+		if httpConf.TrackerServerPort > 0 && httpConf.TrackerServerPort != defaultHTTPPort {
+			host = net.JoinHostPort(host, strconv.Itoa(httpConf.TrackerServerPort))
+		}
+		base = "http://" + host
+	}
+
+	fileURL := base + "/" + remoteFileID
+	if httpConf.AntiSteal != nil {
+		return httpConf.AntiSteal.SignURL(fileURL, remoteFileID, time.Now())
+	}
+	return fileURL, nil
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetHTTPConf(t *testing.T) {
+	hc, err := GetHTTPConf("", "tracker_server=10.0.1.32:22122\nhttp.tracker_server_port=8080\nhttp.domain_name=img.example.com\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hc.TrackerServerPort != 8080 || hc.DomainName != "img.example.com" || hc.AntiSteal != nil {
+		t.Errorf("unexpected http config %+v", hc)
+	}
+
+	if _, err = GetHTTPConf("", "http.tracker_server_port=abc\n"); err == nil {
+		t.Error("invalid http.tracker_server_port should fail")
+	}
+}
+
+func TestURLWithDomain(t *testing.T) {
+	client := &FdfsClient{}
+	remoteFileID := "group1/M00/00/00/wKgBaFoAAA.jpg"
+
+	client.SetHTTPConf(&HTTPConf{DomainName: "https://img.example.com/"})
+	fileURL, err := client.URL(remoteFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileURL != "https://img.example.com/"+remoteFileID {
+		t.Errorf("unexpected url %s", fileURL)
+	}
+
+	client.SetHTTPConf(&HTTPConf{DomainName: "img.example.com", AntiSteal: &AntiSteal{SecretKey: "secret"}})
+	fileURL, err = client.URL(remoteFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(fileURL, "http://img.example.com/"+remoteFileID+"?") || !strings.Contains(fileURL, "token=") {
+		t.Errorf("unexpected signed url %s", fileURL)
+	}
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Logger 日志接口, 参数为交替的 key, value, 与 log/slog 相同, *slog.Logger 可以直接使用, 实现需要并发安全
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// 日志级别, log_level 的 emerg, alert, crit 按 error 处理, notice 按 info 处理
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = [...]string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// parseLogLevel log_level 转为日志级别, 未知级别按 info 处理
+func parseLogLevel(logLevel string) int {
+	switch strings.ToLower(logLevel) {
+	case "debug":
+		return levelDebug
+	case "warn":
+		return levelWarn
+	case "error", "crit", "alert", "emerg":
+		return levelError
+	}
+	return levelInfo
+}
+
+// stdLogger 以 "级别 消息 key=value" 格式输出到标准库 log.Logger
+type stdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger 输出到 w 的 Logger, 配置了 log_level 时客户端默认输出到标准错误
+func NewStdLogger(w io.Writer) Logger {
+	return &stdLogger{logger: log.New(w, "", log.LstdFlags)}
+}
+
+func (l *stdLogger) Debug(msg string, args ...interface{}) { l.output(levelDebug, msg, args) }
+func (l *stdLogger) Info(msg string, args ...interface{})  { l.output(levelInfo, msg, args) }
+func (l *stdLogger) Warn(msg string, args ...interface{})  { l.output(levelWarn, msg, args) }
+func (l *stdLogger) Error(msg string, args ...interface{}) { l.output(levelError, msg, args) }
+
+func (l *stdLogger) output(level int, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(levelNames[level])
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	_ = l.logger.Output(3, b.String())
+}
+
+// levelLogger 按 log_level 过滤, nil 时不输出
+type levelLogger struct {
+	logger Logger
+	level  int
+}
+
+func newLevelLogger(logger Logger, logLevel string) *levelLogger {
+	if logger == nil {
+		return nil
+	}
+	return &levelLogger{logger: logger, level: parseLogLevel(logLevel)}
+}
+
+func (l *levelLogger) debug(msg string, args ...interface{}) {
+	if l != nil && l.level <= levelDebug {
+		l.logger.Debug(msg, args...)
+	}
+}
+
+func (l *levelLogger) info(msg string, args ...interface{}) {
+	if l != nil && l.level <= levelInfo {
+		l.logger.Info(msg, args...)
+	}
+}
+
+func (l *levelLogger) warn(msg string, args ...interface{}) {
+	if l != nil && l.level <= levelWarn {
+		l.logger.Warn(msg, args...)
+	}
+}
+
+func (l *levelLogger) error(msg string, args ...interface{}) {
+	if l != nil {
+		l.logger.Error(msg, args...)
+	}
+}
+
+// defaultLogger 配置了 log_level 时输出到标准错误, 没有配置时不输出日志
+func defaultLogger(cfg *ClientConfig) *levelLogger {
+	if len(cfg.LogLevel) == 0 {
+		return nil
+	}
+	return newLevelLogger(NewStdLogger(os.Stderr), cfg.LogLevel)
+}
+
+// SetLogger 设置日志, 按配置的 log_level 过滤, 没有配置时为 info; nil 表示不输出日志
+// 与 SetMetrics 相同, 连接的日志输出到取出连接的客户端的 Logger
+func (client *FdfsClient) SetLogger(logger Logger) {
+	logLevel := defaultLogLevel
+	if client.config != nil && len(client.config.LogLevel) > 0 {
+		logLevel = client.config.LogLevel
+	}
+	client.logger = newLevelLogger(logger, logLevel)
+}
+
+// Logger 当前的日志
+func (client *FdfsClient) Logger() Logger {
+	if client.logger == nil {
+		return nil
+	}
+	return client.logger.logger
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingLogger struct {
+	lines []string
+	mutex sync.Mutex
+}
+
+func (l *recordingLogger) record(level, msg string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, level+" "+msg)
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record("DEBUG", msg) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg) }
+
+func (l *recordingLogger) count(line string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	n := 0
+	for _, recorded := range l.lines {
+		if recorded == line {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLevelLogger(NewStdLogger(&buf), "WARN")
+	logger.debug("debug")
+	logger.info("info")
+	logger.warn("fdfs connect failed", "addr", "127.0.0.1:22122", "err", ErrNotFound)
+	logger.error("odd", "key")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expect 2 lines, actual %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "WARN fdfs connect failed addr=127.0.0.1:22122 err="+ErrNotFound.Error()) {
+		t.Errorf("unexpected line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "ERROR odd !BADKEY=key") {
+		t.Errorf("unexpected line %q", lines[1])
+	}
+
+	var nilLogger *levelLogger
+	nilLogger.error("nothing")
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for logLevel, level := range map[string]int{
+		"debug": levelDebug, "info": levelInfo, "notice": levelInfo, "warn": levelWarn,
+		"error": levelError, "crit": levelError, "alert": levelError, "emerg": levelError, "": levelInfo,
+	} {
+		if parseLogLevel(logLevel) != level {
+			t.Errorf("%s: expect %d, actual %d", logLevel, level, parseLogLevel(logLevel))
+		}
+	}
+}
+
+func TestClientLogger(t *testing.T) {
+	fdfsClient, _ := newTestClient(t, func(cfg *ClientConfig) {
+		cfg.LogLevel = "debug"
+	})
+	logger := &recordingLogger{}
+	fdfsClient.SetLogger(logger)
+	if fdfsClient.Logger() != logger {
+		t.Fatal("unexpected logger")
+	}
+
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello logger"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.count("DEBUG fdfs connection opened") == 0 {
+		t.Errorf("storage connection not logged: %v", logger.lines)
+	}
+
+	fdfsClient.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, Retryable: func(error) bool { return true }})
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0); err == nil {
+		t.Fatal("expect error")
+	}
+	if n := logger.count("INFO fdfs retrying"); n != 1 {
+		t.Errorf("expect 1 retry log, actual %d: %v", n, logger.lines)
+	}
+
+	fdfsClient.SetLogger(nil)
+	if fdfsClient.Logger() != nil {
+		t.Error("expect nil logger")
+	}
+}
+
+// TestDefaultLogger 默认不输出日志, 配置了 log_level 时输出到标准错误
+func TestDefaultLogger(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	if logger := fdfsClient.Logger(); logger != nil {
+		t.Errorf("expect no logger by default, actual %T", logger)
+	}
+	fdfsClient, _ = newTestClient(t, func(cfg *ClientConfig) {
+		cfg.LogLevel = "warn"
+	})
+	if _, ok := fdfsClient.Logger().(*stdLogger); !ok || fdfsClient.logger.level != levelWarn {
+		t.Errorf("expect warn std logger with log_level, actual %T", fdfsClient.Logger())
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	pool := newTestPool(t)
+	logger := &recordingLogger{}
+	hooks := &connHooks{logger: newLevelLogger(logger, "warn")}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := listener.Addr().String()
+	_ = listener.Close()
+
+	pool.hosts = []string{dead, pool.hostAddr(pool.hosts[0])}
+	pool.connectTimeout = time.Second
+	for i := 0; i < 20; i++ {
+		conn, err := pool.makeConn(hooks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+	}
+
+	pool.hosts = []string{dead}
+	if _, err = pool.makeConn(hooks); err == nil {
+		t.Fatal("expect connect error")
+	}
+	if logger.count("WARN fdfs connect failed") != 1 {
+		t.Errorf("unexpected logs %v", logger.lines)
+	}
+	if logger.count("DEBUG fdfs connection opened") != 0 {
+		t.Errorf("debug should be filtered: %v", logger.lines)
+	}
+}
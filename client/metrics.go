@@ -0,0 +1,93 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 传输方向, 用于 Metrics.AddBytes
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// Metrics 客户端指标, 实现需要并发安全
+type Metrics interface {
+	// ObserveOp 一次操作结束, op 为 upload, download, delete, query_store, query_fetch, active_test 等
+	// addr 为 storage 或 tracker 的 ip:port, 还没有确定服务器时为空; 可以重试的操作每次尝试单独记录
+	ObserveOp(op string, addr string, duration time.Duration, err error)
+	// AddBytes 与 addr 之间收发的字节数, direction 为 DirectionSent 或 DirectionReceived
+	AddBytes(addr string, direction string, n int64)
+}
+
+// PoolStats 连接池状态
+type PoolStats struct {
+	// Addr tracker 或 storage 地址, tracker 有多个时以逗号分隔
+	Addr string
+	// Idle 连接池中的空闲连接数
+	Idle int
+	// InUse 已经取出还没有放回的连接数
+	InUse int
+	// MaxConns 最大连接数
+	MaxConns int
+}
+
+// SetMetrics 设置指标, nil 表示不记录
+// 字节数和 active test 记录到取出连接的客户端的 Metrics, 共享的 storage 连接池不会记录到其他客户端
+func (client *FdfsClient) SetMetrics(metrics Metrics) {
+	client.metrics = metrics
+}
+
+// Metrics 当前的指标
+func (client *FdfsClient) Metrics() Metrics {
+	return client.metrics
+}
+
+// PoolStats tracker 和本客户端用过的 storage 连接池的状态
+func (client *FdfsClient) PoolStats() []PoolStats {
+	stats := []PoolStats{client.trackerPool.stats()}
+	client.storagePools.Range(func(key, value interface{}) bool {
+		stats = append(stats, value.(*ConnectionPool).stats())
+		return true
+	})
+	return stats
+}
+
+func (pool *ConnectionPool) stats() PoolStats {
+	addrs := make([]string, len(pool.hosts))
+	for i, host := range pool.hosts {
+		addrs[i] = pool.hostAddr(host)
+	}
+	return PoolStats{
+		Addr:     strings.Join(addrs, ","),
+		Idle:     pool.Len(),
+		InUse:    int(atomic.LoadInt64(&pool.inUse)),
+		MaxConns: pool.maxConns,
+	}
+}
+
+// observeActiveTest 记录 active test, 连接池复用连接前都会发送
+func (hooks *connHooks) observeActiveTest(conn net.Conn, start time.Time, err error) {
+	if metrics := hooks.getMetrics(); metrics != nil {
+		metrics.ObserveOp("active_test", conn.RemoteAddr().String(), time.Since(start), err)
+	}
+}
+
+// observe 记录 tracker 查询, 返回 trackerOpError 包装后的错误
+func (client *TrackerClient) observe(op string, conn net.Conn, start time.Time, err error) error {
+	err = trackerOpError(op, conn, err)
+	if errors.Is(err, ErrProtocol) {
+		client.hooks.getLogger().error("fdfs protocol error", "op", op, "err", err)
+	}
+	if metrics := client.hooks.getMetrics(); metrics != nil {
+		var addr string
+		if conn != nil {
+			addr = conn.RemoteAddr().String()
+		}
+		metrics.ObserveOp(op, addr, time.Since(start), err)
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedOp struct {
+	op   string
+	addr string
+	err  error
+}
+
+type recordingMetrics struct {
+	ops   []recordedOp
+	bytes map[string]int64
+	mutex sync.Mutex
+}
+
+func (m *recordingMetrics) ObserveOp(op string, addr string, duration time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ops = append(m.ops, recordedOp{op, addr, err})
+}
+
+func (m *recordingMetrics) AddBytes(addr string, direction string, n int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.bytes == nil {
+		m.bytes = make(map[string]int64)
+	}
+	m.bytes[direction+" "+addr] += n
+}
+
+func (m *recordingMetrics) find(op string) []recordedOp {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var ops []recordedOp
+	for _, r := range m.ops {
+		if r.op == op {
+			ops = append(ops, r)
+		}
+	}
+	return ops
+}
+
+func TestMetrics(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	metrics := &recordingMetrics{}
+	fdfsClient.SetMetrics(metrics)
+
+	uploadResponse, err := fdfsClient.UploadByBuffer([]byte("hello metrics"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); err != nil {
+		t.Fatal(err)
+	}
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound: %v", err)
+	}
+
+	for op, count := range map[string]int{"query_store": 1, "query_fetch": 1, "query_update": 2, "upload": 1, "download": 1, "delete": 2} {
+		if ops := metrics.find(op); len(ops) != count {
+			t.Errorf("%s: expect %d observations, actual %v", op, count, ops)
+		}
+	}
+	if ops := metrics.find("upload"); len(ops) == 1 && (ops[0].addr != server.StorageAddr() || ops[0].err != nil) {
+		t.Errorf("unexpected upload observation %+v", ops[0])
+	}
+	if ops := metrics.find("delete"); len(ops) == 2 && !errors.Is(ops[1].err, ErrNotFound) {
+		t.Errorf("unexpected delete observation %+v", ops[1])
+	}
+	if ops := metrics.find("active_test"); len(ops) == 0 {
+		t.Error("active test not observed")
+	}
+	if metrics.bytes["sent "+server.StorageAddr()] < int64(len("hello metrics")) ||
+		metrics.bytes["received "+server.StorageAddr()] < int64(len("hello metrics")) {
+		t.Errorf("unexpected bytes %v", metrics.bytes)
+	}
+
+	stats := fdfsClient.PoolStats()
+	if len(stats) != 2 || stats[0].Addr != server.TrackerAddr() || stats[1].Addr != server.StorageAddr() {
+		t.Fatalf("unexpected pool stats %+v", stats)
+	}
+	for _, s := range stats {
+		if s.InUse != 0 || s.Idle == 0 {
+			t.Errorf("unexpected pool stats %+v", s)
+		}
+	}
+}
+
+func TestPoolStatsInUse(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	pool := fdfsClient.trackerPool
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.stats(); stats.InUse != 1 {
+		t.Errorf("expect 1 in use, actual %+v", stats)
+	}
+	_ = conn.Close()
+	_ = conn.Close()
+	if stats := pool.stats(); stats.InUse != 0 || stats.Idle != 1 {
+		t.Errorf("unexpected stats after close %+v", stats)
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// GroupStat 组状态, 对应 tracker 的 list groups 响应
+type GroupStat struct {
+	GroupName          string `json:"group_name"`
+	TotalMB            int64  `json:"total_mb"`
+	FreeMB             int64  `json:"free_mb"`
+	TrunkFreeMB        int64  `json:"trunk_free_mb"`
+	StorageCount       int64  `json:"storage_count"`
+	StoragePort        int64  `json:"storage_port"`
+	StorageHTTPPort    int64  `json:"storage_http_port"`
+	ActiveCount        int64  `json:"active_count"`
+	CurrentWriteServer int64  `json:"current_write_server"`
+	StorePathCount     int64  `json:"store_path_count"`
+	SubdirCountPerPath int64  `json:"subdir_count_per_path"`
+	CurrentTrunkFileID int64  `json:"current_trunk_file_id"`
+}
+
+// StorageStat storage 状态, 对应 tracker 的 list storage 响应
+type StorageStat struct {
+	Status             int             `json:"status"`
+	ID                 string          `json:"id"`
+	IPAddr             string          `json:"ip_addr"`
+	DomainName         string          `json:"domain_name"`
+	SrcID              string          `json:"src_id"`
+	Version            string          `json:"version"`
+	JoinTime           time.Time       `json:"join_time"`
+	UpTime             time.Time       `json:"up_time"`
+	TotalMB            int64           `json:"total_mb"`
+	FreeMB             int64           `json:"free_mb"`
+	UploadPriority     int64           `json:"upload_priority"`
+	StorePathCount     int64           `json:"store_path_count"`
+	SubdirCountPerPath int64           `json:"subdir_count_per_path"`
+	CurrentWritePath   int64           `json:"current_write_path"`
+	StoragePort        int64           `json:"storage_port"`
+	StorageHTTPPort    int64           `json:"storage_http_port"`
+	Counters           StorageCounters `json:"counters"`
+	LastSourceUpdate   time.Time       `json:"last_source_update"`
+	LastSyncUpdate     time.Time       `json:"last_sync_update"`
+	LastSyncedTime     time.Time       `json:"last_synced_time"`
+	LastHeartBeatTime  time.Time       `json:"last_heart_beat_time"`
+	IsTrunkServer      bool            `json:"is_trunk_server"`
+}
+
+// StorageCounters storage 的操作计数
+type StorageCounters struct {
+	TotalUploadCount       int64 `json:"total_upload_count"`
+	SuccessUploadCount     int64 `json:"success_upload_count"`
+	TotalAppendCount       int64 `json:"total_append_count"`
+	SuccessAppendCount     int64 `json:"success_append_count"`
+	TotalModifyCount       int64 `json:"total_modify_count"`
+	SuccessModifyCount     int64 `json:"success_modify_count"`
+	TotalTruncateCount     int64 `json:"total_truncate_count"`
+	SuccessTruncateCount   int64 `json:"success_truncate_count"`
+	TotalSetMetaCount      int64 `json:"total_set_meta_count"`
+	SuccessSetMetaCount    int64 `json:"success_set_meta_count"`
+	TotalDeleteCount       int64 `json:"total_delete_count"`
+	SuccessDeleteCount     int64 `json:"success_delete_count"`
+	TotalDownloadCount     int64 `json:"total_download_count"`
+	SuccessDownloadCount   int64 `json:"success_download_count"`
+	TotalGetMetaCount      int64 `json:"total_get_meta_count"`
+	SuccessGetMetaCount    int64 `json:"success_get_meta_count"`
+	TotalCreateLinkCount   int64 `json:"total_create_link_count"`
+	SuccessCreateLinkCount int64 `json:"success_create_link_count"`
+	TotalDeleteLinkCount   int64 `json:"total_delete_link_count"`
+	SuccessDeleteLinkCount int64 `json:"success_delete_link_count"`
+	TotalUploadBytes       int64 `json:"total_upload_bytes"`
+	SuccessUploadBytes     int64 `json:"success_upload_bytes"`
+	TotalAppendBytes       int64 `json:"total_append_bytes"`
+	SuccessAppendBytes     int64 `json:"success_append_bytes"`
+	TotalModifyBytes       int64 `json:"total_modify_bytes"`
+	SuccessModifyBytes     int64 `json:"success_modify_bytes"`
+	TotalDownloadBytes     int64 `json:"total_download_bytes"`
+	SuccessDownloadBytes   int64 `json:"success_download_bytes"`
+	TotalSyncInBytes       int64 `json:"total_sync_in_bytes"`
+	SuccessSyncInBytes     int64 `json:"success_sync_in_bytes"`
+	TotalSyncOutBytes      int64 `json:"total_sync_out_bytes"`
+	SuccessSyncOutBytes    int64 `json:"success_sync_out_bytes"`
+	TotalFileOpenCount     int64 `json:"total_file_open_count"`
+	SuccessFileOpenCount   int64 `json:"success_file_open_count"`
+	TotalFileReadCount     int64 `json:"total_file_read_count"`
+	SuccessFileReadCount   int64 `json:"success_file_read_count"`
+	TotalFileWriteCount    int64 `json:"total_file_write_count"`
+	SuccessFileWriteCount  int64 `json:"success_file_write_count"`
+}
+
+// StatusName 状态名, 与 fdfs_monitor 的输出一致
+func (stat *StorageStat) StatusName() string {
+	return StorageStatusName(stat.Status)
+}
+
+// StorageStatusName storage 状态码对应的名字
+func StorageStatusName(status int) string {
+	switch status {
+	case FDFS_STORAGE_STATUS_INIT:
+		return "INIT"
+	case FDFS_STORAGE_STATUS_WAIT_SYNC:
+		return "WAIT_SYNC"
+	case FDFS_STORAGE_STATUS_SYNCING:
+		return "SYNCING"
+	case FDFS_STORAGE_STATUS_IP_CHANGED:
+		return "IP_CHANGED"
+	case FDFS_STORAGE_STATUS_DELETED:
+		return "DELETED"
+	case FDFS_STORAGE_STATUS_OFFLINE:
+		return "OFFLINE"
+	case FDFS_STORAGE_STATUS_ONLINE:
+		return "ONLINE"
+	case FDFS_STORAGE_STATUS_ACTIVE:
+		return "ACTIVE"
+	case FDFS_STORAGE_STATUS_RECOVERY:
+		return "RECOVERY"
+	case FDFS_STORAGE_STATUS_NONE:
+		return "NONE"
+	}
+	return "UNKNOWN"
+}
+
+// SyncDelay 同步延迟, 计算方式与 fdfs_monitor 相同: 组内其它 storage 最后的源文件更新时间减去本 storage 已同步到的时间
+// 组内只有一个 storage 或者还没有同步过时返回0
+func SyncDelay(stat *StorageStat, group []*StorageStat) time.Duration {
+	if stat.LastSyncedTime.IsZero() {
+		return 0
+	}
+	var maxSourceUpdate time.Time
+	for _, other := range group {
+		if other != stat && other.ID != stat.ID && other.LastSourceUpdate.After(maxSourceUpdate) {
+			maxSourceUpdate = other.LastSourceUpdate
+		}
+	}
+	if delay := maxSourceUpdate.Sub(stat.LastSyncedTime); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// ListGroups 所有组的状态
+func (client *FdfsClient) ListGroups() ([]*GroupStat, error) {
+	tc := client.trackerClient()
+	var recvBuff []byte
+	err := client.retry(func() (err error) {
+		recvBuff, err = tc.trackerListGroups()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*GroupStat, 0, len(recvBuff)/TRACKER_GROUP_STAT_SIZE)
+	for i := 0; i < len(recvBuff); i += TRACKER_GROUP_STAT_SIZE {
+		group := &GroupStat{}
+		if err = group.unmarshal(recvBuff[i : i+TRACKER_GROUP_STAT_SIZE]); err != nil {
+			return nil, &OpError{Op: "list_groups", Err: err}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// ListStorages 组内所有 storage 的状态
+func (client *FdfsClient) ListStorages(groupName string) ([]*StorageStat, error) {
+	tc := client.trackerClient()
+	var recvBuff []byte
+	err := client.retry(func() (err error) {
+		recvBuff, err = tc.trackerListStorages(groupName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	storages := make([]*StorageStat, 0, len(recvBuff)/TRACKER_STORAGE_STAT_SIZE)
+	for i := 0; i < len(recvBuff); i += TRACKER_STORAGE_STAT_SIZE {
+		storage := &StorageStat{}
+		if err = storage.unmarshal(recvBuff[i : i+TRACKER_STORAGE_STAT_SIZE]); err != nil {
+			return nil, &OpError{Op: "list_storage", Err: err}
+		}
+		storages = append(storages, storage)
+	}
+	return storages, nil
+}
+
+// recv_fmt: |-group_name(17)-total_mb(8)-free_mb(8)-trunk_free_mb(8)-count(8)-storage_port(8)-storage_http_port(8)
+// -active_count(8)-current_write_server(8)-store_path_count(8)-subdir_count_per_path(8)-current_trunk_file_id(8)-|
+func (group *GroupStat) unmarshal(data []byte) error {
+	if len(data) != TRACKER_GROUP_STAT_SIZE {
+		return newProtocolError("group stat length is %d, expect %d", len(data), TRACKER_GROUP_STAT_SIZE)
+	}
+	buff := bytes.NewBuffer(data)
+	var err error
+	if group.GroupName, err = readCstr(buff, FDFS_GROUP_NAME_MAX_LEN+1); err != nil {
+		return err
+	}
+	return readInt64s(buff, &group.TotalMB, &group.FreeMB, &group.TrunkFreeMB, &group.StorageCount,
+		&group.StoragePort, &group.StorageHTTPPort, &group.ActiveCount, &group.CurrentWriteServer,
+		&group.StorePathCount, &group.SubdirCountPerPath, &group.CurrentTrunkFileID)
+}
+
+// recv_fmt: |-status(1)-id(16)-ip_addr(16)-domain_name(128)-src_id(16)-version(6)-join_time(8)-up_time(8)
+// -total_mb(8)-free_mb(8)-upload_priority(8)-store_path_count(8)-subdir_count_per_path(8)-current_write_path(8)
+// -storage_port(8)-storage_http_port(8)-counters(38*8)-last_source_update(8)-last_sync_update(8)
+// -last_synced_timestamp(8)-last_heart_beat_time(8)-if_trunk_server(1)-|
+func (stat *StorageStat) unmarshal(data []byte) error {
+	if len(data) != TRACKER_STORAGE_STAT_SIZE {
+		return newProtocolError("storage stat length is %d, expect %d", len(data), TRACKER_STORAGE_STAT_SIZE)
+	}
+	buff := bytes.NewBuffer(data)
+	stat.Status = int(buff.Next(1)[0])
+
+	var err error
+	for _, field := range []struct {
+		str    *string
+		length int
+	}{
+		{&stat.ID, FDFS_STORAGE_ID_MAX_SIZE},
+		{&stat.IPAddr, IP_ADDRESS_SIZE},
+		{&stat.DomainName, FDFS_DOMAIN_NAME_MAX_LEN},
+		{&stat.SrcID, FDFS_STORAGE_ID_MAX_SIZE},
+		{&stat.Version, FDFS_VERSION_SIZE},
+	} {
+		if *field.str, err = readCstr(buff, field.length); err != nil {
+			return err
+		}
+	}
+
+	var joinTime, upTime int64
+	if err = readInt64s(buff, &joinTime, &upTime, &stat.TotalMB, &stat.FreeMB, &stat.UploadPriority,
+		&stat.StorePathCount, &stat.SubdirCountPerPath, &stat.CurrentWritePath, &stat.StoragePort,
+		&stat.StorageHTTPPort); err != nil {
+		return err
+	}
+	if err = binary.Read(buff, binary.BigEndian, &stat.Counters); err != nil {
+		return err
+	}
+	var lastSourceUpdate, lastSyncUpdate, lastSyncedTime, lastHeartBeatTime int64
+	if err = readInt64s(buff, &lastSourceUpdate, &lastSyncUpdate, &lastSyncedTime, &lastHeartBeatTime); err != nil {
+		return err
+	}
+	stat.JoinTime = unixTime(joinTime)
+	stat.UpTime = unixTime(upTime)
+	stat.LastSourceUpdate = unixTime(lastSourceUpdate)
+	stat.LastSyncUpdate = unixTime(lastSyncUpdate)
+	stat.LastSyncedTime = unixTime(lastSyncedTime)
+	stat.LastHeartBeatTime = unixTime(lastHeartBeatTime)
+	stat.IsTrunkServer = buff.Next(1)[0] != 0
+	return nil
+}
+
+func readInt64s(buff *bytes.Buffer, values ...*int64) error {
+	for _, value := range values {
+		if err := binary.Read(buff, binary.BigEndian, value); err != nil {
+			return newProtocolError("read int64 failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// unixTime 0 表示没有设置, 返回零值
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
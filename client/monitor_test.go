@@ -0,0 +1,66 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func TestListGroupsAndStorages(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	if _, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := fdfsClient.ListGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].GroupName != fdfstest.DefaultGroupName || groups[0].ActiveCount != 1 ||
+		groups[0].TotalMB != 1024 || groups[0].FreeMB != 1023 {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+
+	storages, err := fdfsClient.ListStorages(fdfstest.DefaultGroupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storages) != 1 {
+		t.Fatalf("unexpected storages %+v", storages)
+	}
+	stat := storages[0]
+	if stat.StatusName() != "ACTIVE" || stat.IPAddr != "127.0.0.1" || stat.Version != "4.06" ||
+		int(stat.StoragePort) != server.StoragePort() || stat.LastHeartBeatTime.IsZero() {
+		t.Errorf("unexpected storage %+v", stat)
+	}
+	if stat.Counters.TotalUploadCount != 1 || stat.Counters.SuccessUploadCount != 1 {
+		t.Errorf("unexpected counters %+v", stat.Counters)
+	}
+
+	if _, err = fdfsClient.ListStorages("group2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect ErrNotFound: %v", err)
+	}
+}
+
+func TestSyncDelay(t *testing.T) {
+	now := time.Now()
+	a := &StorageStat{ID: "a", LastSourceUpdate: now, LastSyncedTime: now.Add(-time.Minute)}
+	b := &StorageStat{ID: "b", LastSourceUpdate: now.Add(-time.Hour), LastSyncedTime: now.Add(-10 * time.Second)}
+	c := &StorageStat{ID: "c", LastSourceUpdate: now}
+	group := []*StorageStat{a, b, c}
+
+	for _, tc := range []struct {
+		stat  *StorageStat
+		delay time.Duration
+	}{
+		{a, time.Minute},
+		{b, 10 * time.Second},
+		{c, 0},
+	} {
+		if delay := SyncDelay(tc.stat, group); delay != tc.delay {
+			t.Errorf("%s: expect %v, actual %v", tc.stat.ID, tc.delay, delay)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MultipartPart 已完成的分片
+type MultipartPart struct {
+	PartNumber int    `json:"part_number"`
+	Offset     int64  `json:"offset"`
+	Size       int64  `json:"size"`
+	CRC32      uint32 `json:"crc32"`
+}
+
+type multipartJournal struct {
+	GroupName    string          `json:"group_name"`
+	RemoteFileID string          `json:"remote_file_id"`
+	FileExtName  string          `json:"file_ext_name"`
+	Parts        []MultipartPart `json:"parts"`
+}
+
+// MultipartUpload 基于appender文件的分片上传
+// 已完成的分片记录在本地journal文件中, 中断后可通过 ResumeMultipartUpload 续传
+type MultipartUpload struct {
+	client      *FdfsClient
+	journalPath string
+	journal     multipartJournal
+	mutex       sync.Mutex
+}
+
+// InitiateMultipartUpload 初始化分片上传, 创建空的appender文件并写入journal
+func (client *FdfsClient) InitiateMultipartUpload(journalPath string, fileExtName string) (*MultipartUpload, error) {
+	if _, err := os.Stat(journalPath); err == nil {
+		return nil, fmt.Errorf("multipart journal [%s] already exists", journalPath)
+	}
+	ur, err := client.UploadAppenderByBuffer([]byte{}, fileExtName)
+	if err != nil {
+		return nil, err
+	}
+	mu := &MultipartUpload{
+		client:      client,
+		journalPath: journalPath,
+		journal: multipartJournal{
+			GroupName:    ur.GroupName,
+			RemoteFileID: ur.RemoteFileID,
+			FileExtName:  fileExtName,
+			Parts:        []MultipartPart{},
+		},
+	}
+	if err = mu.saveJournal(); err != nil {
+		_ = client.DeleteFile(ur.RemoteFileID)
+		return nil, err
+	}
+	return mu, nil
+}
+
+// ResumeMultipartUpload 从journal恢复分片上传
+// 远端文件会被截断到journal记录的长度, 丢弃未记录完成的分片数据
+func (client *FdfsClient) ResumeMultipartUpload(journalPath string) (*MultipartUpload, error) {
+	data, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	mu := &MultipartUpload{
+		client:      client,
+		journalPath: journalPath,
+	}
+	if err = json.Unmarshal(data, &mu.journal); err != nil {
+		return nil, err
+	}
+	if mu.journal.RemoteFileID == "" {
+		return nil, fmt.Errorf("multipart journal [%s] has no remote file id", journalPath)
+	}
+	for i, part := range mu.journal.Parts {
+		if part.PartNumber != i+1 {
+			return nil, fmt.Errorf("multipart journal [%s] is corrupted at part %d", journalPath, i+1)
+		}
+	}
+	if err = client.TruncateFile(mu.journal.RemoteFileID, mu.uploadedSize()); err != nil {
+		return nil, err
+	}
+	return mu, nil
+}
+
+// RemoteFileID 远端appender文件ID
+func (mu *MultipartUpload) RemoteFileID() string {
+	return mu.journal.RemoteFileID
+}
+
+// Parts 已完成的分片
+func (mu *MultipartUpload) Parts() []MultipartPart {
+	mu.mutex.Lock()
+	defer mu.mutex.Unlock()
+	parts := make([]MultipartPart, len(mu.journal.Parts))
+	copy(parts, mu.journal.Parts)
+	return parts
+}
+
+// NextPartNumber 下一个待上传的分片号
+func (mu *MultipartUpload) NextPartNumber() int {
+	mu.mutex.Lock()
+	defer mu.mutex.Unlock()
+	return len(mu.journal.Parts) + 1
+}
+
+// UploadedSize 已上传的字节数
+func (mu *MultipartUpload) UploadedSize() int64 {
+	mu.mutex.Lock()
+	defer mu.mutex.Unlock()
+	return mu.uploadedSize()
+}
+
+func (mu *MultipartUpload) uploadedSize() int64 {
+	parts := mu.journal.Parts
+	if len(parts) == 0 {
+		return 0
+	}
+	last := parts[len(parts)-1]
+	return last.Offset + last.Size
+}
+
+// UploadPart 上传分片, 分片号从1开始且必须连续
+// 已完成的分片可以重传: 最后一个分片大小不限, 之前的分片大小必须与原来一致
+func (mu *MultipartUpload) UploadPart(partNumber int, data []byte) error {
+	mu.mutex.Lock()
+	defer mu.mutex.Unlock()
+
+	if len(data) == 0 {
+		return errors.New("multipart part is empty")
+	}
+	offset, err := mu.partOffset(partNumber, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	if partNumber == len(mu.journal.Parts) {
+		// 重传最后一个分片: 先从journal中移除再截断, 中途失败时journal与远端仍然一致
+		mu.journal.Parts = mu.journal.Parts[:partNumber-1]
+		if err = mu.saveJournal(); err != nil {
+			return err
+		}
+		if err = mu.client.TruncateFile(mu.journal.RemoteFileID, offset); err != nil {
+			return err
+		}
+	}
+	if offset == mu.uploadedSize() {
+		err = mu.client.AppendByBuffer(data, mu.journal.RemoteFileID)
+	} else {
+		err = mu.client.ModifyByBuffer(data, offset, mu.journal.RemoteFileID)
+	}
+	if err != nil {
+		return err
+	}
+
+	part := MultipartPart{
+		PartNumber: partNumber,
+		Offset:     offset,
+		Size:       int64(len(data)),
+		CRC32:      crc32.ChecksumIEEE(data),
+	}
+	if partNumber > len(mu.journal.Parts) {
+		mu.journal.Parts = append(mu.journal.Parts, part)
+	} else {
+		mu.journal.Parts[partNumber-1] = part
+	}
+	return mu.saveJournal()
+}
+
+// Complete 完成分片上传, 删除journal
+func (mu *MultipartUpload) Complete() (*UploadFileResponse, error) {
+	mu.mutex.Lock()
+	defer mu.mutex.Unlock()
+
+	if len(mu.journal.Parts) == 0 {
+		return nil, errors.New("multipart upload has no parts")
+	}
+	if err := os.Remove(mu.journalPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &UploadFileResponse{
+		GroupName:    mu.journal.GroupName,
+		RemoteFileID: mu.journal.RemoteFileID,
+	}, nil
+}
+
+// Abort 放弃分片上传, 删除远端文件和journal
+func (mu *MultipartUpload) Abort() error {
+	mu.mutex.Lock()
+	defer mu.mutex.Unlock()
+
+	if err := mu.client.DeleteFile(mu.journal.RemoteFileID); err != nil {
+		return err
+	}
+	if err := os.Remove(mu.journalPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (mu *MultipartUpload) partOffset(partNumber int, size int64) (int64, error) {
+	parts := mu.journal.Parts
+	switch {
+	case partNumber < 1 || partNumber > len(parts)+1:
+		return 0, fmt.Errorf("multipart part %d out of order, next part is %d", partNumber, len(parts)+1)
+	case partNumber == len(parts)+1:
+		return mu.uploadedSize(), nil
+	case partNumber == len(parts):
+		return parts[partNumber-1].Offset, nil
+	default:
+		part := parts[partNumber-1]
+		if part.Size != size {
+			return 0, fmt.Errorf("multipart part %d size mismatch, expect: %d, actual: %d", partNumber, part.Size, size)
+		}
+		return part.Offset, nil
+	}
+}
+
+func (mu *MultipartUpload) saveJournal() error {
+	data, err := json.MarshalIndent(&mu.journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(mu.journalPath), filepath.Base(mu.journalPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), mu.journalPath)
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func TestMultipartPartOffset(t *testing.T) {
+	mu := &MultipartUpload{}
+	mu.journal.Parts = []MultipartPart{
+		{PartNumber: 1, Offset: 0, Size: 10},
+		{PartNumber: 2, Offset: 10, Size: 10},
+		{PartNumber: 3, Offset: 20, Size: 5},
+	}
+
+	cases := []struct {
+		partNumber int
+		size       int64
+		offset     int64
+		ok         bool
+	}{
+		{0, 10, 0, false},
+		{1, 10, 0, true},
+		{2, 9, 0, false},
+		{3, 7, 20, true},
+		{4, 3, 25, true},
+		{5, 3, 0, false},
+	}
+	for _, c := range cases {
+		offset, err := mu.partOffset(c.partNumber, c.size)
+		if (err == nil) != c.ok {
+			t.Errorf("part %d: unexpected error %v", c.partNumber, err)
+			continue
+		}
+		if c.ok && offset != c.offset {
+			t.Errorf("part %d: expect offset %d, actual %d", c.partNumber, c.offset, offset)
+		}
+	}
+}
+
+func TestMultipartJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fdfs_multipart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mu := &MultipartUpload{journalPath: filepath.Join(dir, "upload.journal")}
+	mu.journal.RemoteFileID = "group1/M00/00/00/test.bin"
+	mu.journal.Parts = []MultipartPart{{PartNumber: 1, Offset: 0, Size: 10, CRC32: 1}}
+	if err = mu.saveJournal(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &FdfsClient{}
+	if _, err = client.InitiateMultipartUpload(mu.journalPath, "bin"); err == nil {
+		t.Error("InitiateMultipartUpload should refuse an existing journal")
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	if len(matches) != 0 {
+		t.Errorf("temporary journal files left behind: %v", matches)
+	}
+}
+
+func downloadContent(t *testing.T, fdfsClient *FdfsClient, remoteFileID string) []byte {
+	dr, err := fdfsClient.DownloadToBuffer(remoteFileID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := dr.Content.([]byte)
+	return content
+}
+
+func TestMultipartUpload(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	journalPath := filepath.Join(t.TempDir(), "upload.journal")
+	parts := [][]byte{[]byte("part one;"), []byte("part two;"), []byte("part three")}
+
+	mu, err := fdfsClient.InitiateMultipartUpload(journalPath, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, part := range parts[:2] {
+		if err = mu.UploadPart(i+1, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第三个分片追加到了远端, 但响应出错, journal 中没有记录
+	server.InjectFault(STORAGE_PROTO_CMD_APPEND_FILE, fdfstest.Fault{PkgLenDelta: 1, Times: 1})
+	if err = mu.UploadPart(3, parts[2]); err == nil {
+		t.Fatal("expect interrupted upload")
+	}
+	// 进程退出前还有未记录的数据写到了远端
+	if err = fdfsClient.AppendByBuffer([]byte("garbage"), mu.RemoteFileID()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu, err = fdfsClient.ResumeMultipartUpload(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mu.NextPartNumber() != 3 || mu.UploadedSize() != int64(len(parts[0])+len(parts[1])) {
+		t.Fatalf("unexpected resumed state, next part %d, uploaded %d", mu.NextPartNumber(), mu.UploadedSize())
+	}
+	// 远端被截断到 journal 记录的长度
+	if content := downloadContent(t, fdfsClient, mu.RemoteFileID()); !bytes.Equal(content, bytes.Join(parts[:2], nil)) {
+		t.Fatalf("unexpected content after resume %q", content)
+	}
+
+	if err = mu.UploadPart(3, parts[2]); err != nil {
+		t.Fatal(err)
+	}
+	// 重传最后一个分片
+	parts[2] = []byte("part 3")
+	if err = mu.UploadPart(3, parts[2]); err != nil {
+		t.Fatal(err)
+	}
+	ur, err := mu.Complete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := downloadContent(t, fdfsClient, ur.RemoteFileID); !bytes.Equal(content, bytes.Join(parts, nil)) {
+		t.Errorf("unexpected content %q", content)
+	}
+	if _, err = os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Errorf("journal not removed: %v", err)
+	}
+}
+
+func TestMultipartAbort(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	journalPath := filepath.Join(t.TempDir(), "upload.journal")
+	mu, err := fdfsClient.InitiateMultipartUpload(journalPath, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mu.UploadPart(1, []byte("part one")); err != nil {
+		t.Fatal(err)
+	}
+
+	mu, err = fdfsClient.ResumeMultipartUpload(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mu.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fdfsClient.QueryFileInfo(mu.RemoteFileID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expect remote file deleted: %v", err)
+	}
+	if _, err = os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Errorf("journal not removed: %v", err)
+	}
+	if _, err = fdfsClient.ResumeMultipartUpload(journalPath); err == nil {
+		t.Error("expect error resuming aborted upload")
+	}
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+const defaultReadAhead = 256 * 1024
+
+var (
+	// ErrFileClosed 文件已关闭
+	ErrFileClosed = errors.New("remote file is closed")
+)
+
+// RemoteFile 远端文件, 通过分段下载实现 io.ReadSeeker, io.ReaderAt 和 io.Closer
+type RemoteFile struct {
+	ctx          context.Context
+	client       *FdfsClient
+	remoteFileID string
+	info         *FileInfo
+	offset       int64
+	readAhead    int
+	buffer       []byte
+	bufferOffset int64
+	closed       bool
+	mutex        sync.Mutex
+}
+
+// Open 打开远端文件, 文件大小通过 QUERY_FILE_INFO 获取
+// ctx 用于查询文件信息和之后的每次下载, 取消后读取失败, 截止时间同时限制连接的读写
+func (client *FdfsClient) Open(ctx context.Context, remoteFileID string) (*RemoteFile, error) {
+	info, err := client.queryFileInfo(ctx, remoteFileID)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteFile{
+		ctx:          ctx,
+		client:       client,
+		remoteFileID: remoteFileID,
+		info:         info,
+		readAhead:    defaultReadAhead,
+	}, nil
+}
+
+// Name 远端文件ID
+func (rf *RemoteFile) Name() string {
+	return rf.remoteFileID
+}
+
+// Size 文件大小
+func (rf *RemoteFile) Size() int64 {
+	return rf.info.FileSize
+}
+
+// Info 文件信息
+func (rf *RemoteFile) Info() *FileInfo {
+	return rf.info
+}
+
+// SetReadAhead 设置顺序读取时的预读大小
+func (rf *RemoteFile) SetReadAhead(size int) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if size <= 0 {
+		size = defaultReadAhead
+	}
+	rf.readAhead = size
+}
+
+// Read 顺序读取
+func (rf *RemoteFile) Read(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.closed {
+		return 0, ErrFileClosed
+	}
+	if rf.offset >= rf.info.FileSize {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if rf.offset < rf.bufferOffset || rf.offset >= rf.bufferOffset+int64(len(rf.buffer)) {
+		size := rf.readAhead
+		if len(p) > size {
+			size = len(p)
+		}
+		buffer, err := rf.download(rf.offset, int64(size))
+		if err != nil {
+			return 0, err
+		}
+		if len(buffer) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		rf.buffer = buffer
+		rf.bufferOffset = rf.offset
+	}
+
+	n := copy(p, rf.buffer[rf.offset-rf.bufferOffset:])
+	rf.offset += int64(n)
+	return n, nil
+}
+
+// ReadAt 从指定位置读取, 不影响顺序读取的位置
+func (rf *RemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	rf.mutex.Lock()
+	closed := rf.closed
+	if !closed && off >= rf.bufferOffset && off+int64(len(p)) <= rf.bufferOffset+int64(len(rf.buffer)) {
+		n := copy(p, rf.buffer[off-rf.bufferOffset:])
+		rf.mutex.Unlock()
+		return n, nil
+	}
+	rf.mutex.Unlock()
+
+	if closed {
+		return 0, ErrFileClosed
+	}
+	if off >= rf.info.FileSize {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	buffer, err := rf.download(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, buffer)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek 设置顺序读取的位置
+func (rf *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.closed {
+		return 0, ErrFileClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rf.offset
+	case io.SeekEnd:
+		offset += rf.info.FileSize
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	rf.offset = offset
+	return offset, nil
+}
+
+// Close 关闭
+func (rf *RemoteFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.closed {
+		return ErrFileClosed
+	}
+	rf.closed = true
+	rf.buffer = nil
+	return nil
+}
+
+func (rf *RemoteFile) download(offset int64, size int64) ([]byte, error) {
+	if offset+size > rf.info.FileSize {
+		size = rf.info.FileSize - offset
+	}
+	dr, err := rf.client.downloadToBuffer(rf.ctx, rf.remoteFileID, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	buffer, _ := dr.Content.([]byte)
+	return buffer, nil
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func openTestFile(t *testing.T, ctx context.Context, size int) (*FdfsClient, *fdfstest.Server, *RemoteFile, []byte) {
+	fdfsClient, server := newTestClient(t)
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	uploadResponse, err := fdfsClient.UploadByBuffer(data, "bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err := fdfsClient.Open(ctx, uploadResponse.RemoteFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fdfsClient, server, rf, data
+}
+
+func TestRemoteFileRead(t *testing.T) {
+	_, _, rf, data := openTestFile(t, context.Background(), 1000)
+	if rf.Size() != int64(len(data)) {
+		t.Fatalf("expect size %d, actual %d", len(data), rf.Size())
+	}
+	// 预读小于读取的缓冲区和文件大小, 每次读取都跨越预读的边界
+	rf.SetReadAhead(64)
+	var content []byte
+	p := make([]byte, 100)
+	for {
+		n, err := rf.Read(p)
+		content = append(content, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(content, data) {
+		t.Fatal("unexpected content")
+	}
+
+	if _, err := rf.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rf.SetReadAhead(7)
+	if err := iotest.TestReader(rf, data); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoteFileSeek(t *testing.T) {
+	_, _, rf, data := openTestFile(t, context.Background(), 100)
+	rf.SetReadAhead(16)
+	cases := []struct {
+		offset int64
+		whence int
+		expect int64
+	}{
+		{10, io.SeekStart, 10},
+		{5, io.SeekCurrent, 15},
+		{-5, io.SeekCurrent, 10},
+		{-20, io.SeekEnd, 80},
+		{0, io.SeekEnd, 100},
+		{50, io.SeekEnd, 150},
+	}
+	for _, c := range cases {
+		pos, err := rf.Seek(c.offset, c.whence)
+		if err != nil || pos != c.expect {
+			t.Errorf("seek %d %d: expect %d, actual %d %v", c.offset, c.whence, c.expect, pos, err)
+		}
+	}
+	// 超过文件末尾
+	if n, err := rf.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("expect EOF after end, actual %d %v", n, err)
+	}
+
+	if _, err := rf.Seek(-1, io.SeekStart); err == nil {
+		t.Error("expect error for negative position")
+	}
+	if _, err := rf.Seek(-101, io.SeekEnd); err == nil {
+		t.Error("expect error for negative position")
+	}
+	if _, err := rf.Seek(0, 3); err == nil {
+		t.Error("expect error for invalid whence")
+	}
+	// 出错的 Seek 不改变位置
+	if pos, _ := rf.Seek(0, io.SeekCurrent); pos != 150 {
+		t.Errorf("expect position 150, actual %d", pos)
+	}
+
+	if _, err := rf.Seek(-20, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(rf)
+	if err != nil || !bytes.Equal(content, data[80:]) {
+		t.Errorf("unexpected content after seek %v", err)
+	}
+}
+
+func TestRemoteFileReadAt(t *testing.T) {
+	_, _, rf, data := openTestFile(t, context.Background(), 100)
+	p := make([]byte, 30)
+	n, err := rf.ReadAt(p, 10)
+	if n != 30 || err != nil || !bytes.Equal(p, data[10:40]) {
+		t.Errorf("unexpected read at 10: %d %v", n, err)
+	}
+	// 文件末尾不足 len(p) 时返回 io.EOF
+	n, err = rf.ReadAt(p, 80)
+	if n != 20 || err != io.EOF || !bytes.Equal(p[:n], data[80:]) {
+		t.Errorf("unexpected read at tail: %d %v", n, err)
+	}
+	n, err = rf.ReadAt(p, 70)
+	if n != 30 || err != nil || !bytes.Equal(p, data[70:]) {
+		t.Errorf("unexpected read to end: %d %v", n, err)
+	}
+	if n, err = rf.ReadAt(p, 100); n != 0 || err != io.EOF {
+		t.Errorf("expect EOF at end, actual %d %v", n, err)
+	}
+	if _, err = rf.ReadAt(p, -1); err == nil {
+		t.Error("expect error for negative offset")
+	}
+	// ReadAt 不影响顺序读取的位置
+	if pos, _ := rf.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("expect position 0, actual %d", pos)
+	}
+
+	if err = rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rf.ReadAt(p, 0); err != ErrFileClosed {
+		t.Errorf("expect ErrFileClosed, actual %v", err)
+	}
+	if _, err = rf.Read(p); err != ErrFileClosed {
+		t.Errorf("expect ErrFileClosed, actual %v", err)
+	}
+}
+
+func TestRemoteFileContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, _, rf, _ := openTestFile(t, ctx, 100)
+	cancel()
+	if _, err := rf.Read(make([]byte, 10)); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect context.Canceled, actual %v", err)
+	}
+	if _, err := rf.ReadAt(make([]byte, 10), 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect context.Canceled, actual %v", err)
+	}
+
+	// context 的截止时间早于网络超时, 下载在截止时间中断
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	fdfsClient, server, rf, _ := openTestFile(t, ctx, 100)
+	fdfsClient.SetRetryPolicy(DefaultRetryPolicy())
+	server.InjectFault(STORAGE_PROTO_CMD_DOWNLOAD_FILE, fdfstest.Fault{Delay: 2 * time.Second, Times: 1})
+	start := time.Now()
+	if _, err := rf.Read(make([]byte, 10)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect context.DeadlineExceeded, actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("read not interrupted by context deadline, elapsed %v", elapsed)
+	}
+
+	// Open 查询 tracker 和文件信息时也受 context 限制
+	for _, cmd := range []byte{TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE, STORAGE_PROTO_CMD_QUERY_FILE_INFO} {
+		server.InjectFault(cmd, fdfstest.Fault{Delay: 2 * time.Second, Times: 1})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start = time.Now()
+		if _, err := fdfsClient.Open(ctx, rf.Name()); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("cmd %d: expect context.DeadlineExceeded, actual %v", cmd, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("cmd %d: open not interrupted by context deadline, elapsed %v", cmd, elapsed)
+		}
+		cancel()
+	}
+	if _, err := fdfsClient.Open(ctx, rf.Name()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect expired context rejected, actual %v", err)
+	}
+}
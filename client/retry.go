@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// RetryPolicy 重试策略
+// 只用于幂等操作: 下载, 查询文件信息, 获取元数据和 tracker 查询, 每次重试都会重新向 tracker 查询存储服务器
+type RetryPolicy struct {
+	// MaxAttempts 最多尝试次数(包括第一次), 小于等于1时不重试
+	MaxAttempts int
+	// Backoff 第一次重试前的等待时间, 之后每次翻倍
+	Backoff time.Duration
+	// MaxBackoff 等待时间上限
+	MaxBackoff time.Duration
+	// Jitter 随机抖动比例 [0, 1], 实际等待时间在 backoff*(1-Jitter) 到 backoff 之间
+	Jitter float64
+	// Retryable 判断错误是否可以重试, 为空时使用 IsRetryable
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy 默认重试策略
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: defaultRetryAttempts,
+		Backoff:     defaultRetryBackoff,
+		MaxBackoff:  defaultRetryMaxBackoff,
+		Jitter:      0.5,
+	}
+}
+
+// IsRetryable 网络错误, 连接断开和服务器暂时不可用(EAGAIN, EBUSY, ETIMEDOUT, ECONNREFUSED)可以重试
+// 文件不存在, 参数错误和协议错误不重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, target := range []error{ErrAgain, ErrBusy, ErrTimeout, ErrConnRefused,
+		io.EOF, io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// SetRetryPolicy 设置重试策略, nil 表示不重试
+func (client *FdfsClient) SetRetryPolicy(policy *RetryPolicy) {
+	client.retryPolicy = policy
+}
+
+// RetryPolicy 当前的重试策略
+func (client *FdfsClient) RetryPolicy() *RetryPolicy {
+	return client.retryPolicy
+}
+
+// retry 按客户端的重试策略执行 fn, op.noRetry 时只执行一次
+func (op *opTrace) retry(fn func() error) error {
+	if op.noRetry {
+		return fn()
+	}
+	return op.client.retry(fn)
+}
+
+// retry 按重试策略执行 fn, 返回最后一次的错误
+func (client *FdfsClient) retry(fn func() error) error {
+	policy := client.retryPolicy
+	err := fn()
+	if policy == nil {
+		return err
+	}
+	for attempt := 1; attempt < policy.MaxAttempts && err != nil && policy.retryable(err); attempt++ {
+		backoff := policy.backoff(attempt)
+		client.logger.info("fdfs retrying", "attempt", attempt+1, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		err = fn()
+	}
+	return err
+}
+
+func (policy *RetryPolicy) retryable(err error) bool {
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff 第 attempt 次重试前的等待时间
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := policy.Backoff
+	for i := 1; i < attempt && (policy.MaxBackoff <= 0 || backoff < policy.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	jitter := policy.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		backoff -= time.Duration(rand.Float64() * jitter * float64(backoff))
+	}
+	return backoff
+}
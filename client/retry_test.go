@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{&OpError{Op: "download", Err: Errno{11}}, true},
+		{&OpError{Op: "download", Err: Errno{2}}, false},
+		{&OpError{Op: "query_fetch", Err: io.EOF}, true},
+		{&OpError{Op: "query_fetch", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{newProtocolError("bad"), false},
+		{ErrInvalidArgument, false},
+	}
+	for _, c := range cases {
+		if IsRetryable(c.err) != c.retryable {
+			t.Errorf("IsRetryable(%v) expect %v", c.err, c.retryable)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	client := &FdfsClient{}
+	calls := 0
+	fn := func() error {
+		calls++
+		if calls < 3 {
+			return Errno{16}
+		}
+		return nil
+	}
+	if err := client.retry(fn); err == nil || calls != 1 {
+		t.Fatalf("expect no retry without policy, calls %d err %v", calls, err)
+	}
+
+	calls = 0
+	client.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	if err := client.retry(fn); err != nil || calls != 3 {
+		t.Fatalf("expect success after 3 calls, calls %d err %v", calls, err)
+	}
+
+	calls = 0
+	notFound := func() error {
+		calls++
+		return Errno{2}
+	}
+	if err := client.retry(notFound); !errors.Is(err, ErrNotFound) || calls != 1 {
+		t.Fatalf("expect not retry ErrNotFound, calls %d err %v", calls, err)
+	}
+
+	calls = 0
+	client.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, Retryable: func(error) bool { return true }})
+	if err := client.retry(notFound); err == nil || calls != 2 {
+		t.Fatalf("expect custom classifier, calls %d err %v", calls, err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	expects := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, expect := range expects {
+		if backoff := policy.backoff(i + 1); backoff != expect {
+			t.Errorf("attempt %d expect %v, actual %v", i+1, expect, backoff)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if backoff := policy.backoff(1); backoff < 50*time.Millisecond || backoff > 100*time.Millisecond {
+			t.Fatalf("backoff out of range: %v", backoff)
+		}
+	}
+}
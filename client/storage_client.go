@@ -1,16 +1,16 @@
 package client
 
 import (
-	"errors"
-	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 )
 
 // StorageClient 存储客户端
 type StorageClient struct {
-	pool *ConnectionPool
+	pool  *ConnectionPool
+	hooks *connHooks
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -56,37 +56,31 @@ func (client *StorageClient) storageUploadByStream(tc *TrackerClient,
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 // upload slave
-func (client *StorageClient) storageUploadSlaveByFilename(tc *TrackerClient,
-	storeServ *StorageServer, filename string, prefixName string, remoteFileID string) (*UploadFileResponse, error) {
-	fileInfo, err := os.Stat(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	fileSize := fileInfo.Size()
-	fileExtName := getFileExt(filename)
-
-	return client.storageUploadFile(tc, storeServ, filename, int64(fileSize), FDFS_UPLOAD_BY_FILENAME,
-		STORAGE_PROTO_CMD_UPLOAD_SLAVE_FILE, remoteFileID, prefixName, fileExtName)
-}
-
-func (client *StorageClient) storageUploadSlaveByBuffer(tc *TrackerClient,
-	storeServ *StorageServer, fileBuffer []byte, remoteFileID string, fileExtName string) (*UploadFileResponse, error) {
-	bufferSize := len(fileBuffer)
-
-	return client.storageUploadFile(tc, storeServ, fileBuffer, int64(bufferSize), FDFS_UPLOAD_BY_BUFFER,
-		STORAGE_PROTO_CMD_UPLOAD_SLAVE_FILE, "", remoteFileID, fileExtName)
-}
-
-func (client *StorageClient) storageUploadSlaveByStream(tc *TrackerClient,
-	storeServ *StorageServer, stream ReadStream, remoteFileID string, fileExtName string, size int64) (*UploadFileResponse, error) {
-	if size <= 0 && stream != nil {
-		_, _ = stream.Seek(0, io.SeekStart)
-		size, _ = stream.Seek(0, io.SeekEnd)
-		_, _ = stream.Seek(0, io.SeekStart)
+func (client *StorageClient) storageUploadSlave(tc *TrackerClient,
+	storeServ *StorageServer, req *SlaveUploadRequest, masterFilename string) (*UploadFileResponse, error) {
+	var (
+		uploadType int
+		size       = req.Size
+	)
+	switch source := req.Source.(type) {
+	case string:
+		fileInfo, err := os.Stat(source)
+		if err != nil {
+			return nil, err
+		}
+		uploadType, size = FDFS_UPLOAD_BY_FILENAME, fileInfo.Size()
+	case []byte:
+		uploadType, size = FDFS_UPLOAD_BY_BUFFER, int64(len(source))
+	case ReadStream:
+		if size <= 0 {
+			_, _ = source.Seek(0, io.SeekStart)
+			size, _ = source.Seek(0, io.SeekEnd)
+			_, _ = source.Seek(0, io.SeekStart)
+		}
+		uploadType = FDFS_UPLOAD_BY_STREAM
 	}
-	return client.storageUploadFile(tc, storeServ, stream, size, FDFS_UPLOAD_BY_STREAM,
-		STORAGE_PROTO_CMD_UPLOAD_SLAVE_FILE, "", remoteFileID, fileExtName)
+	return client.storageUploadFile(tc, storeServ, req.Source, size, uploadType,
+		STORAGE_PROTO_CMD_UPLOAD_SLAVE_FILE, masterFilename, req.PrefixName, req.Ext)
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -128,27 +122,26 @@ func (client *StorageClient) storageUploadAppenderByStream(tc *TrackerClient,
 
 func (client *StorageClient) storageUploadFile(tc *TrackerClient,
 	storeServ *StorageServer, fileContent interface{}, fileSize int64, uploadType int,
-	cmd int8, masterFilename string, prefixName string, fileExtName string) (*UploadFileResponse, error) {
+	cmd int8, masterFilename string, prefixName string, fileExtName string) (ur *UploadFileResponse, err error) {
 
 	var (
 		conn        net.Conn
 		uploadSlave bool
 		headerLen   int64 = 15
 		reqBuf      []byte
-		err         error
 	)
 
-	conn, err = client.pool.Get()
+	conn, err = client.pool.get(client.hooks)
 	if err != nil {
 		return nil, err
 	}
 
 	defer func() {
-		_ = conn.Close()
+		closeConn(conn, err)
 	}()
 
 	masterFilenameLen := int64(len(masterFilename))
-	if len(storeServ.groupName) > 0 && len(masterFilename) > 0 {
+	if len(masterFilename) > 0 {
 		uploadSlave = true
 		// #slave_fmt |-master_len(8)-file_size(8)-prefix_name(16)-file_ext_name(6)
 		//       #           -master_name(master_filename_len)-|
@@ -159,7 +152,9 @@ func (client *StorageClient) storageUploadFile(tc *TrackerClient,
 	th.pkgLen = headerLen
 	th.pkgLen += int64(fileSize)
 	th.cmd = cmd
-	th.sendHeader(conn)
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
 
 	if uploadSlave {
 		req := &uploadSlaveFileRequest{}
@@ -185,6 +180,38 @@ func (client *StorageClient) storageUploadFile(tc *TrackerClient,
 		return nil, err
 	}
 
+	err = sendFileContent(conn, fileContent, fileSize, uploadType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = th.recvHeader(conn); err != nil {
+		return nil, err
+	}
+	if th.status != 0 {
+		return nil, Errno{int(th.status)}
+	}
+	if err = th.checkPkgLen(FDFS_GROUP_NAME_MAX_LEN+1, FDFS_GROUP_NAME_MAX_LEN+FDFS_REMOTE_NAME_MAX_SIZE); err != nil {
+		return nil, err
+	}
+	recvBuff, recvSize, err := TCPRecvResponse(conn, th.pkgLen)
+	if err != nil {
+		return nil, err
+	}
+	if recvSize != th.pkgLen || recvSize <= int64(FDFS_GROUP_NAME_MAX_LEN) {
+		return nil, newProtocolError("response length is not match, expect: %d, actual: %d", th.pkgLen, recvSize)
+	}
+	ur = &UploadFileResponse{}
+	err = ur.unmarshal(recvBuff)
+	if err != nil {
+		return nil, newProtocolError("recvBuf can not unmarshal: %s", err.Error())
+	}
+
+	return ur, nil
+}
+
+func sendFileContent(conn net.Conn, fileContent interface{}, fileSize int64, uploadType int) error {
+	var err error
 	switch uploadType {
 	case FDFS_UPLOAD_BY_FILENAME:
 		{
@@ -213,57 +240,135 @@ func (client *StorageClient) storageUploadFile(tc *TrackerClient,
 						readPos += int64(readLen)
 					}
 					if err != nil && err != io.EOF {
-						return nil, err
+						return err
+					}
+					if readLen == 0 && err == io.EOF {
+						return io.ErrUnexpectedEOF
 					}
 				}
+				err = nil
 			}
 		}
 	}
+	return err
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// append & modify
+func (client *StorageClient) storageAppendByBuffer(tc *TrackerClient,
+	storeServ *StorageServer, fileBuffer []byte, appenderFilename string) error {
+	req := &appendFileRequest{appenderFilename: appenderFilename, fileSize: int64(len(fileBuffer))}
+	return client.storageModifyFile(tc, storeServ, req, fileBuffer, req.fileSize, FDFS_UPLOAD_BY_BUFFER,
+		STORAGE_PROTO_CMD_APPEND_FILE)
+}
+
+func (client *StorageClient) storageAppendByStream(tc *TrackerClient,
+	storeServ *StorageServer, stream ReadStream, size int64, appenderFilename string) error {
+	if size <= 0 && stream != nil {
+		_, _ = stream.Seek(0, io.SeekStart)
+		size, _ = stream.Seek(0, io.SeekEnd)
+		_, _ = stream.Seek(0, io.SeekStart)
+	}
+	req := &appendFileRequest{appenderFilename: appenderFilename, fileSize: size}
+	return client.storageModifyFile(tc, storeServ, req, stream, size, FDFS_UPLOAD_BY_STREAM,
+		STORAGE_PROTO_CMD_APPEND_FILE)
+}
+
+func (client *StorageClient) storageModifyByBuffer(tc *TrackerClient,
+	storeServ *StorageServer, fileBuffer []byte, offset int64, appenderFilename string) error {
+	req := &modifyFileRequest{appenderFilename: appenderFilename, fileOffset: offset, fileSize: int64(len(fileBuffer))}
+	return client.storageModifyFile(tc, storeServ, req, fileBuffer, req.fileSize, FDFS_UPLOAD_BY_BUFFER,
+		STORAGE_PROTO_CMD_MODIFY_FILE)
+}
+
+func (client *StorageClient) storageModifyByStream(tc *TrackerClient,
+	storeServ *StorageServer, stream ReadStream, size int64, offset int64, appenderFilename string) error {
+	if size <= 0 && stream != nil {
+		_, _ = stream.Seek(0, io.SeekStart)
+		size, _ = stream.Seek(0, io.SeekEnd)
+		_, _ = stream.Seek(0, io.SeekStart)
+	}
+	req := &modifyFileRequest{appenderFilename: appenderFilename, fileOffset: offset, fileSize: size}
+	return client.storageModifyFile(tc, storeServ, req, stream, size, FDFS_UPLOAD_BY_STREAM,
+		STORAGE_PROTO_CMD_MODIFY_FILE)
+}
+
+func (client *StorageClient) storageTruncateFile(tc *TrackerClient,
+	storeServ *StorageServer, truncatedFileSize int64, appenderFilename string) error {
+	req := &truncateFileRequest{appenderFilename: appenderFilename, truncatedFileSize: truncatedFileSize}
+	return client.storageModifyFile(tc, storeServ, req, nil, 0, 0, STORAGE_PROTO_CMD_TRUNCATE_FILE)
+}
+
+func (client *StorageClient) storageModifyFile(tc *TrackerClient,
+	storeServ *StorageServer, req Request, fileContent interface{}, fileSize int64, uploadType int,
+	cmd int8) (err error) {
+	var (
+		conn   net.Conn
+		reqBuf []byte
+	)
+
+	reqBuf, err = req.marshal()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	th.recvHeader(conn)
-	if th.status != 0 {
-		return nil, Errno{int(th.status)}
+	conn, err = client.pool.get(client.hooks)
+	if err != nil {
+		return err
 	}
-	recvBuff, recvSize, err := TCPRecvResponse(conn, th.pkgLen)
-	if recvSize <= int64(FDFS_GROUP_NAME_MAX_LEN) {
-		errmsg := "[-] Error: Storage response length is not match, "
-		errmsg += fmt.Sprintf("expect: %d, actual: %d", th.pkgLen, recvSize)
-		return nil, errors.New(errmsg)
+
+	defer func() {
+		closeConn(conn, err)
+	}()
+
+	th := &trackerHeader{}
+	th.cmd = cmd
+	th.pkgLen = int64(len(reqBuf)) + fileSize
+	if err = th.sendHeader(conn); err != nil {
+		return err
 	}
-	ur := &UploadFileResponse{}
-	err = ur.unmarshal(recvBuff)
+
+	err = TCPSendData(conn, reqBuf)
 	if err != nil {
-		errmsg := fmt.Sprintf("recvBuf can not unmarshal :%s", err.Error())
-		return nil, errors.New(errmsg)
+		return err
 	}
 
-	return ur, nil
+	err = sendFileContent(conn, fileContent, fileSize, uploadType)
+	if err != nil {
+		return err
+	}
+
+	if err = th.recvHeader(conn); err != nil {
+		return err
+	}
+	if th.status != 0 {
+		return Errno{int(th.status)}
+	}
+	return th.checkPkgLen(0, 0)
 }
 
-func (client *StorageClient) storageDeleteFile(tc *TrackerClient, storeServ *StorageServer, remoteFilename string) error {
+func (client *StorageClient) storageDeleteFile(tc *TrackerClient, storeServ *StorageServer, remoteFilename string) (err error) {
 	var (
 		conn   net.Conn
 		reqBuf []byte
-		err    error
 	)
 
-	conn, err = client.pool.Get()
+	conn, err = client.pool.get(client.hooks)
 	if err != nil {
 		return err
 	}
 
 	defer func() {
-		_ = conn.Close()
+		closeConn(conn, err)
 	}()
 
 	th := &trackerHeader{}
 	th.cmd = STORAGE_PROTO_CMD_DELETE_FILE
 	fileNameLen := len(remoteFilename)
 	th.pkgLen = int64(FDFS_GROUP_NAME_MAX_LEN + fileNameLen)
-	th.sendHeader(conn)
+	if err = th.sendHeader(conn); err != nil {
+		return err
+	}
 
 	req := &deleteFileRequest{}
 	req.groupName = storeServ.groupName
@@ -278,11 +383,181 @@ func (client *StorageClient) storageDeleteFile(tc *TrackerClient, storeServ *Sto
 		return err
 	}
 
-	th.recvHeader(conn)
+	if err = th.recvHeader(conn); err != nil {
+		return err
+	}
+	if th.status != 0 {
+		return Errno{int(th.status)}
+	}
+	return th.checkPkgLen(0, 0)
+}
+
+func (client *StorageClient) storageQueryFileInfo(tc *TrackerClient, storeServ *StorageServer, remoteFilename string) (info *FileInfo, err error) {
+	var (
+		conn     net.Conn
+		reqBuf   []byte
+		recvBuff []byte
+		recvSize int64
+	)
+
+	conn, err = client.pool.get(client.hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		closeConn(conn, err)
+	}()
+
+	th := &trackerHeader{}
+	th.cmd = STORAGE_PROTO_CMD_QUERY_FILE_INFO
+	th.pkgLen = int64(FDFS_GROUP_NAME_MAX_LEN + len(remoteFilename))
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
+
+	req := &queryFileInfoRequest{}
+	req.groupName = storeServ.groupName
+	req.remoteFilename = remoteFilename
+	reqBuf, err = req.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	err = TCPSendData(conn, reqBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = th.recvHeader(conn); err != nil {
+		return nil, err
+	}
+	if th.status != 0 {
+		return nil, Errno{int(th.status)}
+	}
+	if err = th.checkPkgLen(3*FDFS_PROTO_PKG_LEN_SIZE+IP_ADDRESS_SIZE, 3*FDFS_PROTO_PKG_LEN_SIZE+IP_ADDRESS_SIZE); err != nil {
+		return nil, err
+	}
+	recvBuff, recvSize, err = TCPRecvResponse(conn, th.pkgLen)
+	if err != nil {
+		return nil, err
+	}
+	if recvSize != th.pkgLen {
+		return nil, newProtocolError("response length is not match, expect: %d, actual: %d", th.pkgLen, recvSize)
+	}
+
+	info = &FileInfo{}
+	if err = info.unmarshal(recvBuff); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (client *StorageClient) storageSetMetadata(tc *TrackerClient, storeServ *StorageServer,
+	remoteFilename string, meta map[string]string, opFlag byte) (err error) {
+	var (
+		conn   net.Conn
+		reqBuf []byte
+	)
+
+	req := &setMetadataRequest{}
+	req.groupName = storeServ.groupName
+	req.remoteFilename = remoteFilename
+	req.opFlag = opFlag
+	req.metaBuff, err = packMetadata(meta)
+	if err != nil {
+		return err
+	}
+	reqBuf, err = req.marshal()
+	if err != nil {
+		return err
+	}
+
+	conn, err = client.pool.get(client.hooks)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		closeConn(conn, err)
+	}()
+
+	th := &trackerHeader{}
+	th.cmd = STORAGE_PROTO_CMD_SET_METADATA
+	th.pkgLen = int64(len(reqBuf))
+	if err = th.sendHeader(conn); err != nil {
+		return err
+	}
+
+	err = TCPSendData(conn, reqBuf)
+	if err != nil {
+		return err
+	}
+
+	if err = th.recvHeader(conn); err != nil {
+		return err
+	}
 	if th.status != 0 {
 		return Errno{int(th.status)}
 	}
-	return nil
+	return th.checkPkgLen(0, 0)
+}
+
+func (client *StorageClient) storageGetMetadata(tc *TrackerClient, storeServ *StorageServer,
+	remoteFilename string) (meta map[string]string, err error) {
+	var (
+		conn     net.Conn
+		reqBuf   []byte
+		recvBuff []byte
+		recvSize int64
+	)
+
+	conn, err = client.pool.get(client.hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		closeConn(conn, err)
+	}()
+
+	th := &trackerHeader{}
+	th.cmd = STORAGE_PROTO_CMD_GET_METADATA
+	th.pkgLen = int64(FDFS_GROUP_NAME_MAX_LEN + len(remoteFilename))
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
+
+	req := &getMetadataRequest{}
+	req.groupName = storeServ.groupName
+	req.remoteFilename = remoteFilename
+	reqBuf, err = req.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	err = TCPSendData(conn, reqBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = th.recvHeader(conn); err != nil {
+		return nil, err
+	}
+	if th.status != 0 {
+		return nil, Errno{int(th.status)}
+	}
+	if err = th.checkPkgLen(0, maxMetadataSize); err != nil {
+		return nil, err
+	}
+	recvBuff, recvSize, err = TCPRecvResponse(conn, th.pkgLen)
+	if err != nil {
+		return nil, err
+	}
+	if recvSize != th.pkgLen {
+		return nil, newProtocolError("response length is not match, expect: %d, actual: %d", th.pkgLen, recvSize)
+	}
+	return unpackMetadata(recvBuff), nil
 }
 
 func (client *StorageClient) storageDownloadToFile(tc *TrackerClient,
@@ -299,7 +574,7 @@ func (client *StorageClient) storageDownloadToBuffer(tc *TrackerClient,
 
 func (client *StorageClient) storageDownloadFile(tc *TrackerClient,
 	storeServ *StorageServer, fileContent interface{}, offset int64, downloadSize int64,
-	downloadType int, remoteFilename string) (*DownloadFileResponse, error) {
+	downloadType int, remoteFilename string) (dr *DownloadFileResponse, err error) {
 
 	var (
 		conn          net.Conn
@@ -307,22 +582,23 @@ func (client *StorageClient) storageDownloadFile(tc *TrackerClient,
 		localFilename string
 		recvBuff      []byte
 		recvSize      int64
-		err           error
 	)
 
-	conn, err = client.pool.Get()
+	conn, err = client.pool.get(client.hooks)
 	if err != nil {
 		return nil, err
 	}
 
 	defer func() {
-		_ = conn.Close()
+		closeConn(conn, err)
 	}()
 
 	th := &trackerHeader{}
 	th.cmd = STORAGE_PROTO_CMD_DOWNLOAD_FILE
 	th.pkgLen = int64(FDFS_PROTO_PKG_LEN_SIZE*2 + FDFS_GROUP_NAME_MAX_LEN + len(remoteFilename))
-	th.sendHeader(conn)
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
 
 	req := &downloadFileRequest{}
 	req.offset = offset
@@ -339,10 +615,21 @@ func (client *StorageClient) storageDownloadFile(tc *TrackerClient,
 		return nil, err
 	}
 
-	th.recvHeader(conn)
+	if err = th.recvHeader(conn); err != nil {
+		return nil, err
+	}
 	if th.status != 0 {
 		return nil, Errno{int(th.status)}
 	}
+	// 指定了下载长度时响应体必须正好是该长度, 否则是整个文件, 长度未知, 接收时随读取分配内存
+	if downloadSize > 0 {
+		err = th.checkPkgLen(downloadSize, downloadSize)
+	} else {
+		err = th.checkPkgLen(0, math.MaxInt64)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	switch downloadType {
 	case FDFS_DOWNLOAD_TO_FILE:
@@ -357,13 +644,11 @@ func (client *StorageClient) storageDownloadFile(tc *TrackerClient,
 	if err != nil {
 		return nil, err
 	}
-	if recvSize < downloadSize {
-		errmsg := "[-] Error: Storage response length is not match, "
-		errmsg += fmt.Sprintf("expect: %d, actual: %d", th.pkgLen, recvSize)
-		return nil, errors.New(errmsg)
+	if recvSize != th.pkgLen || recvSize < downloadSize {
+		return nil, newProtocolError("response length is not match, expect: %d, actual: %d", th.pkgLen, recvSize)
 	}
 
-	dr := &DownloadFileResponse{}
+	dr = &DownloadFileResponse{}
 	dr.RemoteFileID = storeServ.groupName + string(os.PathSeparator) + remoteFilename
 	if downloadType == FDFS_DOWNLOAD_TO_FILE {
 		dr.Content = localFilename
@@ -373,3 +658,62 @@ func (client *StorageClient) storageDownloadFile(tc *TrackerClient,
 	dr.DownloadSize = recvSize
 	return dr, nil
 }
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// create link
+func (client *StorageClient) storageCreateLink(tc *TrackerClient, storeServ *StorageServer,
+	srcFilename string, masterFilename string, prefixName string, fileExtName string, signature []byte) (ur *UploadFileResponse, err error) {
+	conn, err := client.pool.get(client.hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		closeConn(conn, err)
+	}()
+
+	req := &createLinkRequest{
+		groupName:      storeServ.groupName,
+		masterFilename: masterFilename,
+		srcFilename:    srcFilename,
+		srcSignature:   signature,
+		prefixName:     prefixName,
+		fileExtName:    fileExtName,
+	}
+	reqBuf, err := req.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	th := &trackerHeader{}
+	th.cmd = STORAGE_PROTO_CMD_CREATE_LINK
+	th.pkgLen = int64(len(reqBuf))
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
+	if err = TCPSendData(conn, reqBuf); err != nil {
+		return nil, err
+	}
+
+	if err = th.recvHeader(conn); err != nil {
+		return nil, err
+	}
+	if th.status != 0 {
+		return nil, Errno{int(th.status)}
+	}
+	if err = th.checkPkgLen(FDFS_GROUP_NAME_MAX_LEN+1, FDFS_GROUP_NAME_MAX_LEN+FDFS_REMOTE_NAME_MAX_SIZE); err != nil {
+		return nil, err
+	}
+	recvBuff, recvSize, err := TCPRecvResponse(conn, th.pkgLen)
+	if err != nil {
+		return nil, err
+	}
+	if recvSize != th.pkgLen || recvSize <= int64(FDFS_GROUP_NAME_MAX_LEN) {
+		return nil, newProtocolError("response length is not match, expect: %d, actual: %d", th.pkgLen, recvSize)
+	}
+	ur = &UploadFileResponse{}
+	if err = ur.unmarshal(recvBuff); err != nil {
+		return nil, err
+	}
+	return ur, nil
+}
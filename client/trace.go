@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Tracer 链路追踪, 接口足够小, 可以适配 OpenTelemetry 等实现, 实现需要并发安全
+// 每次操作创建根 span "fdfs.<op>", 其下有 tracker 查询 "fdfs.tracker.<query>" 和存储服务器传输 "fdfs.storage.<op>" 两个子 span
+// 可以重试的操作(下载, 查询文件信息, 获取元数据)每次尝试是一个单独的根 span
+type Tracer interface {
+	// StartSpan 开始 span, parent 为 nil 时是根 span
+	StartSpan(name string, parent Span) Span
+}
+
+// Span 追踪的一段
+type Span interface {
+	// SetAttribute 设置属性, value 为 string, int64 或 bool
+	SetAttribute(key string, value interface{})
+	// End 结束, err 不为 nil 时表示失败
+	End(err error)
+}
+
+// span 属性
+const (
+	AttrOp             = "fdfs.op"
+	AttrFileID         = "fdfs.file_id"
+	AttrGroup          = "fdfs.group"
+	AttrStorageAddr    = "fdfs.storage.addr"
+	AttrStorePathIndex = "fdfs.store_path_index"
+	AttrBytes          = "fdfs.bytes"
+	// AttrStatus 服务端返回的状态码, 成功为0, 没有收到状态码(网络错误等)时不设置
+	AttrStatus = "fdfs.status"
+)
+
+// SetTracer 设置链路追踪, nil 表示不追踪
+func (client *FdfsClient) SetTracer(tracer Tracer) {
+	client.tracer = tracer
+}
+
+// Tracer 当前的链路追踪
+func (client *FdfsClient) Tracer() Tracer {
+	return client.tracer
+}
+
+// opTrace 一次操作的指标和追踪
+type opTrace struct {
+	client  *FdfsClient
+	ctx     context.Context
+	name    string
+	fileID  string
+	start   time.Time
+	span    Span
+	storage Span
+	bytes   int64
+	// noRetry 不按客户端的重试策略重试 tracker 查询, 由调用方整体重试
+	noRetry bool
+}
+
+func (client *FdfsClient) startOp(name, fileID string) *opTrace {
+	op := &opTrace{client: client, name: name, fileID: fileID, start: time.Now()}
+	if client.tracer != nil {
+		op.span = client.tracer.StartSpan("fdfs."+name, nil)
+		op.span.SetAttribute(AttrOp, name)
+		if len(fileID) > 0 {
+			op.span.SetAttribute(AttrFileID, fileID)
+		}
+	}
+	return op
+}
+
+// setBytes 上传下载的字节数
+func (op *opTrace) setBytes(n int64) {
+	op.bytes = n
+}
+
+// setFileBytes 上传本地文件的字节数, 只在追踪时读取文件大小
+func (op *opTrace) setFileBytes(filename string) {
+	if op.span == nil {
+		return
+	}
+	if info, err := os.Stat(filename); err == nil {
+		op.bytes = info.Size()
+	}
+}
+
+func (op *opTrace) startTracker(query string) Span {
+	if op.span == nil {
+		return nil
+	}
+	return op.client.tracer.StartSpan("fdfs.tracker."+query, op.span)
+}
+
+func (op *opTrace) endTracker(span Span, srv *StorageServer, err error) {
+	if span == nil {
+		return
+	}
+	if srv != nil {
+		setStorageAttributes(span, srv)
+	}
+	setStatus(span, err)
+	span.End(err)
+}
+
+func (op *opTrace) startStorage(srv *StorageServer) {
+	if op.span == nil {
+		return
+	}
+	setStorageAttributes(op.span, srv)
+	op.storage = op.client.tracer.StartSpan("fdfs.storage."+op.name, op.span)
+	setStorageAttributes(op.storage, srv)
+}
+
+// end 结束操作, 记录指标和追踪, 返回 opError 包装后的错误
+func (op *opTrace) end(srv *StorageServer, err error) error {
+	err = opError(op.name, op.fileID, srv, err)
+	// tracker 查询的协议错误已经在 TrackerClient.observe 中记录
+	var opErr *OpError
+	if errors.Is(err, ErrProtocol) && errors.As(err, &opErr) && opErr.Op == op.name {
+		op.client.logger.error("fdfs protocol error", "op", op.name, "err", err)
+	}
+	if metrics := op.client.metrics; metrics != nil {
+		var addr string
+		if srv != nil {
+			addr = net.JoinHostPort(srv.ipAddr, strconv.Itoa(srv.port))
+		}
+		metrics.ObserveOp(op.name, addr, time.Since(op.start), err)
+	}
+	for _, span := range []Span{op.storage, op.span} {
+		if span == nil {
+			continue
+		}
+		if op.bytes > 0 {
+			span.SetAttribute(AttrBytes, op.bytes)
+		}
+		setStatus(span, err)
+		span.End(err)
+	}
+	return err
+}
+
+func setStorageAttributes(span Span, srv *StorageServer) {
+	span.SetAttribute(AttrGroup, srv.groupName)
+	span.SetAttribute(AttrStorageAddr, net.JoinHostPort(srv.ipAddr, strconv.Itoa(srv.port)))
+	span.SetAttribute(AttrStorePathIndex, int64(srv.storePathIndex))
+}
+
+func setStatus(span Span, err error) {
+	var errno Errno
+	if err == nil {
+		span.SetAttribute(AttrStatus, int64(0))
+	} else if errors.As(err, &errno) {
+		span.SetAttribute(AttrStatus, int64(errno.Status()))
+	}
+}
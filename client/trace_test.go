@@ -0,0 +1,162 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	ended  bool
+	err    error
+	tracer *recordingTracer
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.ended = true
+	s.err = err
+}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+	mutex sync.Mutex
+}
+
+func (t *recordingTracer) StartSpan(name string, parent Span) Span {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	span := &recordedSpan{name: name, attrs: make(map[string]interface{}), tracer: t}
+	if parent != nil {
+		span.parent = parent.(*recordedSpan)
+	}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func (t *recordingTracer) find(name string) []*recordedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var spans []*recordedSpan
+	for _, span := range t.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestTracer(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	tracer := &recordingTracer{}
+	fdfsClient.SetTracer(tracer)
+
+	data := []byte("hello tracer")
+	uploadResponse, err := fdfsClient.UploadByBuffer(data, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := tracer.find("fdfs.upload")
+	if len(roots) != 1 {
+		t.Fatalf("expect 1 upload span, actual %d", len(roots))
+	}
+	root := roots[0]
+	if root.parent != nil || !root.ended || root.err != nil {
+		t.Errorf("unexpected upload span %+v", root)
+	}
+	for key, value := range map[string]interface{}{
+		AttrOp:             "upload",
+		AttrGroup:          uploadResponse.GroupName,
+		AttrStorageAddr:    server.StorageAddr(),
+		AttrStorePathIndex: int64(0),
+		AttrBytes:          int64(len(data)),
+		AttrStatus:         int64(0),
+	} {
+		if root.attrs[key] != value {
+			t.Errorf("%s: expect %v, actual %v", key, value, root.attrs[key])
+		}
+	}
+	for _, name := range []string{"fdfs.tracker.query_store", "fdfs.storage.upload"} {
+		spans := tracer.find(name)
+		if len(spans) != 1 || spans[0].parent != root || !spans[0].ended {
+			t.Errorf("%s: unexpected spans %+v", name, spans)
+			continue
+		}
+		if spans[0].attrs[AttrStorageAddr] != server.StorageAddr() || spans[0].attrs[AttrStatus] != int64(0) {
+			t.Errorf("%s: unexpected attributes %v", name, spans[0].attrs)
+		}
+	}
+
+	if err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID); err != nil {
+		t.Fatal(err)
+	}
+	err = fdfsClient.DeleteFile(uploadResponse.RemoteFileID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound: %v", err)
+	}
+	deletes := tracer.find("fdfs.delete")
+	if len(deletes) != 2 {
+		t.Fatalf("expect 2 delete spans, actual %d", len(deletes))
+	}
+	failed := deletes[1]
+	if failed.attrs[AttrFileID] != uploadResponse.RemoteFileID || failed.attrs[AttrStatus] != int64(2) || !errors.Is(failed.err, ErrNotFound) {
+		t.Errorf("unexpected failed delete span %v %v", failed.attrs, failed.err)
+	}
+	if spans := tracer.find("fdfs.storage.delete"); len(spans) != 2 || spans[1].parent != failed || spans[1].attrs[AttrStatus] != int64(2) {
+		t.Errorf("unexpected storage delete spans %+v", spans)
+	}
+}
+
+func TestTracerDisabled(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	if fdfsClient.Tracer() != nil {
+		t.Fatal("tracer should be nil by default")
+	}
+	op := fdfsClient.startOp("upload", "")
+	op.setFileBytes("not-exist")
+	if span := op.startTracker("query_store"); span != nil {
+		t.Error("expect no span without tracer")
+	}
+	if err := op.end(nil, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTracerInvalidFileID(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	tracer := &recordingTracer{}
+	fdfsClient.SetTracer(tracer)
+
+	for i, call := range []func() error{
+		func() error { return fdfsClient.AppendByBuffer([]byte("a"), "invalid") },
+		func() error { return fdfsClient.AppendByStream(nil, 1, "invalid") },
+		func() error { return fdfsClient.ModifyByBuffer([]byte("a"), 0, "invalid") },
+		func() error { return fdfsClient.ModifyByStream(nil, 1, 0, "invalid") },
+		func() error { return fdfsClient.TruncateFile("invalid", 0) },
+		func() error { return fdfsClient.DeleteFile("invalid") },
+		func() error { return fdfsClient.SetMetadata("invalid", nil, STORAGE_SET_METADATA_FLAG_MERGE) },
+	} {
+		if err := call(); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("call %d: expect ErrInvalidArgument, actual %v", i, err)
+		}
+	}
+	// 文件ID不合法时不发请求, 也不能留下没有结束的 span
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	for _, span := range tracer.spans {
+		if !span.ended {
+			t.Errorf("span %s not ended", span.name)
+		}
+	}
+}
@@ -4,71 +4,59 @@ import (
 	"bytes"
 	"encoding/binary"
 	"net"
+	"time"
 )
 
 // TrackerClient 追踪客户端
 type TrackerClient struct {
-	pool *ConnectionPool
+	pool  *ConnectionPool
+	hooks *connHooks
 }
 
-func (client *TrackerClient) trackerQueryStorageStorWithoutGroup() (*StorageServer, error) {
-	var (
-		conn     net.Conn
-		recvBuff []byte
-		err      error
-	)
+func (client *TrackerClient) trackerQueryStorageStorWithoutGroup() (srv *StorageServer, err error) {
+	var conn net.Conn
 
-	conn, err = client.pool.Get()
+	start := time.Now()
+	conn, err = client.pool.get(client.hooks)
 	if err != nil {
-		return nil, err
+		return nil, client.observe("query_store", conn, start, err)
 	}
-	defer conn.Close()
+	defer func() {
+		closeConn(conn, err)
+	}()
+	defer func() {
+		err = client.observe("query_store", conn, start, err)
+	}()
 
 	th := &trackerHeader{}
 	th.cmd = TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE
-	th.sendHeader(conn)
-
-	th.recvHeader(conn)
-	if th.status != 0 {
-		return nil, Errno{int(th.status)}
-	}
-
-	var (
-		groupName      string
-		ipAddr         string
-		port           int64
-		storePathIndex uint8
-	)
-	recvBuff, _, err = TCPRecvResponse(conn, th.pkgLen)
-	if err != nil {
+	if err = th.sendHeader(conn); err != nil {
 		return nil, err
 	}
-	buff := bytes.NewBuffer(recvBuff)
-	// #recv_fmt |-group_name(16)-ipaddr(16-1)-port(8)-store_path_index(1)|
-	groupName, err = readCstr(buff, FDFS_GROUP_NAME_MAX_LEN)
-	ipAddr, err = readCstr(buff, IP_ADDRESS_SIZE-1)
-	binary.Read(buff, binary.BigEndian, &port)
-	binary.Read(buff, binary.BigEndian, &storePathIndex)
-	return &StorageServer{ipAddr, int(port), groupName, int(storePathIndex)}, nil
+	return recvStorageServer(conn, th)
 }
 
-func (client *TrackerClient) trackerQueryStorageStorWithGroup(groupName string) (*StorageServer, error) {
-	var (
-		conn     net.Conn
-		recvBuff []byte
-		err      error
-	)
+func (client *TrackerClient) trackerQueryStorageStorWithGroup(groupName string) (srv *StorageServer, err error) {
+	var conn net.Conn
 
-	conn, err = client.pool.Get()
-	defer conn.Close()
+	start := time.Now()
+	conn, err = client.pool.get(client.hooks)
 	if err != nil {
-		return nil, err
+		return nil, client.observe("query_store", conn, start, err)
 	}
+	defer func() {
+		closeConn(conn, err)
+	}()
+	defer func() {
+		err = client.observe("query_store", conn, start, err)
+	}()
 
 	th := &trackerHeader{}
 	th.cmd = TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITH_GROUP_ONE
 	th.pkgLen = int64(FDFS_GROUP_NAME_MAX_LEN)
-	th.sendHeader(conn)
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
 
 	groupBuffer := new(bytes.Buffer)
 	// 16 bit groupName
@@ -86,28 +74,7 @@ func (client *TrackerClient) trackerQueryStorageStorWithGroup(groupName string)
 	if err != nil {
 		return nil, err
 	}
-
-	th.recvHeader(conn)
-	if th.status != 0 {
-		return nil, Errno{int(th.status)}
-	}
-
-	var (
-		ipAddr         string
-		port           int64
-		storePathIndex uint8
-	)
-	recvBuff, _, err = TCPRecvResponse(conn, th.pkgLen)
-	if err != nil {
-		return nil, err
-	}
-	buff := bytes.NewBuffer(recvBuff)
-	// #recv_fmt |-group_name(16)-ipaddr(16-1)-port(8)-store_path_index(1)|
-	groupName, err = readCstr(buff, FDFS_GROUP_NAME_MAX_LEN)
-	ipAddr, err = readCstr(buff, IP_ADDRESS_SIZE-1)
-	binary.Read(buff, binary.BigEndian, &port)
-	binary.Read(buff, binary.BigEndian, &storePathIndex)
-	return &StorageServer{ipAddr, int(port), groupName, int(storePathIndex)}, nil
+	return recvStorageServer(conn, th)
 }
 
 func (client *TrackerClient) trackerQueryStorageUpdate(groupName string, remoteFilename string) (*StorageServer, error) {
@@ -118,23 +85,31 @@ func (client *TrackerClient) trackerQueryStorageFetch(groupName string, remoteFi
 	return client.trackerQueryStorage(groupName, remoteFilename, TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE)
 }
 
-func (client *TrackerClient) trackerQueryStorage(groupName string, remoteFilename string, cmd int8) (*StorageServer, error) {
-	var (
-		conn     net.Conn
-		recvBuff []byte
-		err      error
-	)
+func (client *TrackerClient) trackerQueryStorage(groupName string, remoteFilename string, cmd int8) (srv *StorageServer, err error) {
+	var conn net.Conn
 
-	conn, err = client.pool.Get()
-	defer conn.Close()
+	start := time.Now()
+	op := "query_fetch"
+	if cmd == TRACKER_PROTO_CMD_SERVICE_QUERY_UPDATE {
+		op = "query_update"
+	}
+	conn, err = client.pool.get(client.hooks)
 	if err != nil {
-		return nil, err
+		return nil, client.observe(op, conn, start, err)
 	}
+	defer func() {
+		closeConn(conn, err)
+	}()
+	defer func() {
+		err = client.observe(op, conn, start, err)
+	}()
 
 	th := &trackerHeader{}
 	th.pkgLen = int64(FDFS_GROUP_NAME_MAX_LEN + len(remoteFilename))
 	th.cmd = cmd
-	th.sendHeader(conn)
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
 
 	// #query_fmt: |-group_name(16)-filename(file_name_len)-|
 	queryBuffer := new(bytes.Buffer)
@@ -156,26 +131,120 @@ func (client *TrackerClient) trackerQueryStorage(groupName string, remoteFilenam
 	if err != nil {
 		return nil, err
 	}
+	return recvStorageServer(conn, th)
+}
 
-	th.recvHeader(conn)
+// #recv_fmt |-group_name(16)-ipaddr(16-1)-port(8)-store_path_index(1)|
+// fetch 和 update 查询没有 store_path_index
+func recvStorageServer(conn net.Conn, th *trackerHeader) (*StorageServer, error) {
+	if err := th.recvHeader(conn); err != nil {
+		return nil, err
+	}
 	if th.status != 0 {
 		return nil, Errno{int(th.status)}
 	}
+	if err := th.checkPkgLen(TRACKER_QUERY_STORAGE_FETCH_BODY_LEN, TRACKER_QUERY_STORAGE_STORE_BODY_LEN); err != nil {
+		return nil, err
+	}
+
+	recvBuff, recvSize, err := TCPRecvResponse(conn, th.pkgLen)
+	if err != nil {
+		return nil, err
+	}
+	if recvSize != th.pkgLen || recvSize < TRACKER_QUERY_STORAGE_FETCH_BODY_LEN {
+		return nil, newProtocolError("response length is %d, expect %d and at least %d", recvSize, th.pkgLen,
+			TRACKER_QUERY_STORAGE_FETCH_BODY_LEN)
+	}
 
 	var (
+		groupName      string
 		ipAddr         string
 		port           int64
 		storePathIndex uint8
 	)
-	recvBuff, _, err = TCPRecvResponse(conn, th.pkgLen)
-	if err != nil {
+	buff := bytes.NewBuffer(recvBuff)
+	if groupName, err = readCstr(buff, FDFS_GROUP_NAME_MAX_LEN); err != nil {
+		return nil, err
+	}
+	if ipAddr, err = readCstr(buff, IP_ADDRESS_SIZE-1); err != nil {
 		return nil, err
 	}
-	buff := bytes.NewBuffer(recvBuff)
-	// #recv_fmt |-group_name(16)-ipaddr(16-1)-port(8)-store_path_index(1)|
-	groupName, err = readCstr(buff, FDFS_GROUP_NAME_MAX_LEN)
-	ipAddr, err = readCstr(buff, IP_ADDRESS_SIZE-1)
 	binary.Read(buff, binary.BigEndian, &port)
-	binary.Read(buff, binary.BigEndian, &storePathIndex)
+	if buff.Len() > 0 {
+		binary.Read(buff, binary.BigEndian, &storePathIndex)
+	}
 	return &StorageServer{ipAddr, int(port), groupName, int(storePathIndex)}, nil
 }
+
+func trackerOpError(op string, conn net.Conn, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*OpError); ok {
+		return err
+	}
+	e := &OpError{Op: op, Err: err}
+	if conn != nil {
+		e.Addr = conn.RemoteAddr().String()
+	}
+	return e
+}
+
+func (client *TrackerClient) trackerListGroups() ([]byte, error) {
+	return client.trackerList("list_groups", TRACKER_PROTO_CMD_SERVER_LIST_ALL_GROUPS, nil, TRACKER_GROUP_STAT_SIZE, FDFS_MAX_GROUPS)
+}
+
+// #query_fmt: |-group_name(16)-|
+func (client *TrackerClient) trackerListStorages(groupName string) ([]byte, error) {
+	body := make([]byte, FDFS_GROUP_NAME_MAX_LEN)
+	copy(body, groupName)
+	return client.trackerList("list_storage", TRACKER_PROTO_CMD_SERVER_LIST_STORAGE, body, TRACKER_STORAGE_STAT_SIZE,
+		FDFS_MAX_SERVERS_EACH_GROUP)
+}
+
+// trackerList 发送 list 命令, 返回的响应体是 recordSize 的整数倍, 最多 maxRecords 条
+func (client *TrackerClient) trackerList(op string, cmd int8, body []byte, recordSize int, maxRecords int) (recvBuff []byte, err error) {
+	var conn net.Conn
+
+	start := time.Now()
+	conn, err = client.pool.get(client.hooks)
+	if err != nil {
+		return nil, client.observe(op, conn, start, err)
+	}
+	defer func() {
+		closeConn(conn, err)
+	}()
+	defer func() {
+		err = client.observe(op, conn, start, err)
+	}()
+
+	th := &trackerHeader{}
+	th.cmd = cmd
+	th.pkgLen = int64(len(body))
+	if err = th.sendHeader(conn); err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		if err = TCPSendData(conn, body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = th.recvHeader(conn); err != nil {
+		return nil, err
+	}
+	if th.status != 0 {
+		return nil, Errno{int(th.status)}
+	}
+	if err = th.checkPkgLen(0, int64(recordSize*maxRecords)); err != nil {
+		return nil, err
+	}
+	recvBuff, recvSize, err := TCPRecvResponse(conn, th.pkgLen)
+	if err != nil {
+		return nil, err
+	}
+	if recvSize != th.pkgLen || recvSize%int64(recordSize) != 0 {
+		return nil, newProtocolError("response length is %d, expect %d and multiple of %d", recvSize, th.pkgLen, recordSize)
+	}
+	return recvBuff, nil
+}
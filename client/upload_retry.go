@@ -0,0 +1,238 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// MetaUploadTag SafeUpload 写入的元数据, 值为 <随机串>/<第几次尝试>
+// 每次尝试写入内容前先更新该元数据, 值表示文件中是哪一次尝试的内容
+const MetaUploadTag = "upload_tag"
+
+// UploadAttempt 一次失败的上传尝试, 存储服务器可能已经保存了文件
+type UploadAttempt struct {
+	// Attempt 第几次尝试, 从1开始
+	Attempt int
+	// Tag 本次尝试的标记, 为空表示还没有创建文件, 没有写入标记
+	Tag string
+	// FileID 已知的文件ID, 为空表示创建空文件时失败, 不确定服务器是否保存了空文件
+	FileID string
+	// Addr 存储服务器地址 ip:port
+	Addr string
+	// Err 本次尝试的错误
+	Err error
+}
+
+// SafeUploadOptions 上传重试选项
+type SafeUploadOptions struct {
+	// Policy 重试策略, 为空时使用客户端的重试策略, 客户端也没有设置时使用 DefaultRetryPolicy
+	Policy *RetryPolicy
+	// OnOrphan 可能产生孤儿文件的尝试, 创建空文件时失败或者最终失败后删除文件失败
+	OnOrphan func(attempt *UploadAttempt)
+}
+
+// safeUploader 先创建空的 appender 文件, 每次尝试截断后重新追加内容
+// 写入内容的尝试都在同一个已知ID的文件上, 重试不会留下带内容的孤儿文件
+type safeUploader struct {
+	policy   *RetryPolicy
+	onOrphan func(attempt *UploadAttempt)
+	logger   *levelLogger
+	// create 创建空的 appender 文件
+	create func() (*UploadFileResponse, error)
+	setTag func(fileID, tag string) error
+	// truncate 截断到0, 丢弃之前尝试写入的内容
+	truncate func(fileID string) error
+	// write 追加本次上传的内容
+	write  func(fileID string) error
+	delete func(fileID string) error
+}
+
+// SafeUploadByBuffer 可重试的上传, 文件为 appender 文件, 重试在同一个文件上进行
+// 最终失败时删除文件, 可能产生孤儿文件的尝试通过 OnOrphan 回调报告
+func (client *FdfsClient) SafeUploadByBuffer(filebuffer []byte, fileExtName string, opts *SafeUploadOptions, groupName ...string) (*UploadFileResponse, error) {
+	su := client.newSafeUploader(opts, fileExtName, groupName...)
+	su.write = func(fileID string) error {
+		if len(filebuffer) == 0 {
+			return nil
+		}
+		return client.updateOnce("append", fileID, func(tc *TrackerClient, srv *StorageServer, store *StorageClient, filename string) error {
+			return store.storageAppendByBuffer(tc, srv, filebuffer, filename)
+		})
+	}
+	return su.upload()
+}
+
+// SafeUploadByFilename 可重试的上传本地文件, 参考 SafeUploadByBuffer
+func (client *FdfsClient) SafeUploadByFilename(filename string, opts *SafeUploadOptions, groupName ...string) (*UploadFileResponse, error) {
+	if err := fdfsCheckFile(filename); err != nil {
+		return nil, err
+	}
+	su := client.newSafeUploader(opts, getFileExt(filename), groupName...)
+	su.write = func(fileID string) error {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.Size() == 0 {
+			return err
+		}
+		return client.updateOnce("append", fileID, func(tc *TrackerClient, srv *StorageServer, store *StorageClient, remoteFilename string) error {
+			return store.storageAppendByStream(tc, srv, file, info.Size(), remoteFilename)
+		})
+	}
+	return su.upload()
+}
+
+func (client *FdfsClient) newSafeUploader(opts *SafeUploadOptions, fileExtName string, groupName ...string) *safeUploader {
+	su := &safeUploader{
+		policy: client.retryPolicy,
+		logger: client.logger,
+		create: func() (*UploadFileResponse, error) {
+			op := client.startOp("upload_appender", "")
+			op.noRetry = true
+			tc, srv, store, err := client.getUploadArg(op, groupName...)
+			if err != nil {
+				return nil, op.end(srv, err)
+			}
+			resp, err := store.storageUploadAppenderByBuffer(tc, srv, nil, fileExtName)
+			return resp, op.end(srv, err)
+		},
+		setTag: func(fileID, tag string) error {
+			return client.updateOnce("set_metadata", fileID, func(tc *TrackerClient, srv *StorageServer, store *StorageClient, filename string) error {
+				return store.storageSetMetadata(tc, srv, filename, map[string]string{MetaUploadTag: tag}, STORAGE_SET_METADATA_FLAG_MERGE)
+			})
+		},
+		truncate: func(fileID string) error {
+			return client.updateOnce("truncate", fileID, func(tc *TrackerClient, srv *StorageServer, store *StorageClient, filename string) error {
+				return store.storageTruncateFile(tc, srv, 0, filename)
+			})
+		},
+		delete: func(fileID string) error {
+			return client.updateOnce("delete", fileID, func(tc *TrackerClient, srv *StorageServer, store *StorageClient, filename string) error {
+				return store.storageDeleteFile(tc, srv, filename)
+			})
+		},
+	}
+	if opts != nil {
+		if opts.Policy != nil {
+			su.policy = opts.Policy
+		}
+		su.onOrphan = opts.OnOrphan
+	}
+	if su.policy == nil {
+		su.policy = DefaultRetryPolicy()
+	}
+	return su
+}
+
+// updateOnce 不重试 tracker 查询的更新操作, 由 safeUploader 整体重试
+func (client *FdfsClient) updateOnce(name, fileID string,
+	fn func(tc *TrackerClient, srv *StorageServer, store *StorageClient, filename string) error) error {
+	tmp, err := splitRemoteFileID(fileID)
+	if err != nil {
+		return err
+	}
+	op := client.startOp(name, fileID)
+	op.noRetry = true
+	tc, srv, store, err := client.getUpdateArg(op, tmp[0], tmp[1])
+	if err != nil {
+		return op.end(srv, err)
+	}
+	return op.end(srv, fn(tc, srv, store, tmp[1]))
+}
+
+func (su *safeUploader) upload() (*UploadFileResponse, error) {
+	token, err := newUploadToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		resp *UploadFileResponse
+		// written 之前的尝试可能已经写入了内容
+		written bool
+		tag     string
+	)
+	for attempt := 1; ; attempt++ {
+		if resp == nil {
+			if resp, err = su.create(); err != nil {
+				resp = nil
+				if mayUploaded(err) {
+					su.orphan(&UploadAttempt{Attempt: attempt, Addr: errorAddr(err), Err: err})
+				}
+			}
+		}
+		if resp != nil {
+			tag = fmt.Sprintf("%s/%d", token, attempt)
+			if err = su.setTag(resp.RemoteFileID, tag); err == nil && written {
+				err = su.truncate(resp.RemoteFileID)
+			}
+			if err == nil {
+				written = true
+				if err = su.write(resp.RemoteFileID); err == nil {
+					return resp, nil
+				}
+			}
+		}
+
+		if attempt >= su.policy.MaxAttempts || !su.policy.retryable(err) {
+			if resp != nil {
+				if delErr := su.delete(resp.RemoteFileID); delErr != nil {
+					su.orphan(&UploadAttempt{Attempt: attempt, Tag: tag, FileID: resp.RemoteFileID, Addr: errorAddr(err), Err: err})
+				}
+			}
+			return nil, err
+		}
+		backoff := su.policy.backoff(attempt)
+		su.logger.info("fdfs retrying upload", "attempt", attempt+1, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+	}
+}
+
+func (su *safeUploader) orphan(attempt *UploadAttempt) {
+	su.logger.warn("fdfs upload may leave orphan file", "attempt", attempt.Attempt, "tag", attempt.Tag,
+		"file_id", attempt.FileID, "addr", attempt.Addr, "err", attempt.Err)
+	if su.onOrphan != nil {
+		su.onOrphan(attempt)
+	}
+}
+
+// mayUploaded tracker 查询阶段, 连接存储服务器失败和参数错误不会在存储服务器上产生文件
+func mayUploaded(err error) bool {
+	if errors.Is(err, ErrInvalidArgument) {
+		return false
+	}
+	var netErr *net.OpError
+	if errors.As(err, &netErr) && netErr.Op == "dial" {
+		return false
+	}
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return !strings.HasPrefix(opErr.Op, "query_") && len(opErr.Addr) > 0
+	}
+	return true
+}
+
+func errorAddr(err error) string {
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return opErr.Addr
+	}
+	return ""
+}
+
+func newUploadToken() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func TestSafeUploadRetry(t *testing.T) {
+	var (
+		orphans   []*UploadAttempt
+		tags      []string
+		calls     []string
+		creates   int
+		setTags   int
+		truncates int
+	)
+	const fileID = "group1/M00/00/00/a.txt"
+	su := &safeUploader{
+		policy:   &RetryPolicy{MaxAttempts: 4, Backoff: time.Millisecond},
+		onOrphan: func(attempt *UploadAttempt) { orphans = append(orphans, attempt) },
+		create: func() (*UploadFileResponse, error) {
+			calls = append(calls, "create")
+			if creates++; creates == 1 {
+				// 存储服务器超时, 不确定是否已保存空文件
+				return nil, &OpError{Op: "upload_appender", Addr: "127.0.0.1:23000", Err: Errno{110}}
+			}
+			return &UploadFileResponse{"group1", fileID}, nil
+		},
+		setTag: func(id, tag string) error {
+			calls = append(calls, "tag")
+			if setTags++; setTags == 2 {
+				return &OpError{Op: "set_metadata", Err: Errno{11}}
+			}
+			tags = append(tags, tag)
+			return nil
+		},
+		truncate: func(id string) error {
+			calls = append(calls, "truncate")
+			truncates++
+			return nil
+		},
+		write: func(id string) error {
+			calls = append(calls, "write")
+			if truncates == 0 {
+				// 内容可能已经写入, 但没有收到响应
+				return &OpError{Op: "append", Addr: "127.0.0.1:23000", Err: Errno{110}}
+			}
+			return nil
+		},
+		delete: func(id string) error {
+			t.Errorf("unexpected delete %s", id)
+			return nil
+		},
+	}
+
+	resp, err := su.upload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RemoteFileID != fileID {
+		t.Fatalf("unexpect result %v", resp)
+	}
+	if expect := "create create tag write tag tag truncate write"; strings.Join(calls, " ") != expect {
+		t.Errorf("expect calls %q, actual %q", expect, strings.Join(calls, " "))
+	}
+	if len(tags) != 2 || !strings.HasSuffix(tags[0], "/2") || !strings.HasSuffix(tags[1], "/4") {
+		t.Errorf("unexpect tags %v", tags)
+	}
+	if len(orphans) != 1 || orphans[0].Attempt != 1 || orphans[0].Addr != "127.0.0.1:23000" ||
+		len(orphans[0].FileID) > 0 || len(orphans[0].Tag) > 0 {
+		t.Errorf("unexpect orphans: %+v", orphans)
+	}
+}
+
+func TestSafeUploadNotRetryable(t *testing.T) {
+	var (
+		writes  int
+		orphans []*UploadAttempt
+	)
+	su := &safeUploader{
+		policy:   &RetryPolicy{MaxAttempts: 3},
+		onOrphan: func(attempt *UploadAttempt) { orphans = append(orphans, attempt) },
+		create: func() (*UploadFileResponse, error) {
+			return &UploadFileResponse{"group1", "group1/M00/00/00/a.txt"}, nil
+		},
+		setTag: func(id, tag string) error { return nil },
+		write: func(id string) error {
+			writes++
+			return &OpError{Op: "append", Addr: "127.0.0.1:23000", Err: Errno{28}}
+		},
+		// 最终失败后删除文件, 删除失败时报告已知的文件ID
+		delete: func(id string) error { return Errno{16} },
+	}
+	_, err := su.upload()
+	if !errors.Is(err, ErrNoSpace) || writes != 1 {
+		t.Fatalf("expect no retry, writes %d err %v", writes, err)
+	}
+	if len(orphans) != 1 || orphans[0].FileID != "group1/M00/00/00/a.txt" || !strings.HasSuffix(orphans[0].Tag, "/1") {
+		t.Errorf("unexpect orphans: %+v", orphans)
+	}
+}
+
+func TestMayUploaded(t *testing.T) {
+	cases := []struct {
+		err    error
+		expect bool
+	}{
+		{&OpError{Op: "upload_appender", Addr: "127.0.0.1:23000", Err: Errno{110}}, true},
+		{&OpError{Op: "query_store", Addr: "127.0.0.1:22122", Err: Errno{16}}, false},
+		{&OpError{Op: "upload_appender", Addr: "127.0.0.1:23000", Err: &net.OpError{Op: "dial", Err: Errno{111}}}, false},
+		{&OpError{Op: "upload_appender", Err: ErrInvalidArgument}, false},
+	}
+	for i, c := range cases {
+		if actual := mayUploaded(c.err); actual != c.expect {
+			t.Errorf("case %d: expect %v, actual %v", i, c.expect, actual)
+		}
+	}
+}
+
+func TestSafeUpload(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	fdfsClient.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+	// 内容已经追加, 但响应出错, 重试时截断后重新追加, 不产生新文件
+	server.InjectFault(STORAGE_PROTO_CMD_APPEND_FILE, fdfstest.Fault{PkgLenDelta: 1, Times: 1})
+	opts := &SafeUploadOptions{
+		Policy: &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Retryable: func(err error) bool {
+			return IsRetryable(err) || errors.Is(err, ErrProtocol)
+		}},
+		OnOrphan: func(attempt *UploadAttempt) { t.Errorf("unexpected orphan %+v", attempt) },
+	}
+	resp, err := fdfsClient.SafeUploadByBuffer([]byte("hello"), "txt", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := server.Files(); len(files) != 1 || files[0] != resp.RemoteFileID {
+		t.Errorf("expect only %s on server, actual %v", resp.RemoteFileID, files)
+	}
+	if content := downloadContent(t, fdfsClient, resp.RemoteFileID); string(content) != "hello" {
+		t.Errorf("unexpected content %q", content)
+	}
+	meta, err := fdfsClient.GetMetadata(resp.RemoteFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(meta[MetaUploadTag], "/2") {
+		t.Errorf("unexpected tag %q", meta[MetaUploadTag])
+	}
+
+	// 每次尝试只查询一次 tracker, 不叠加客户端的重试; 最终失败后删除文件也失败, 报告文件ID
+	server.InjectFault(TRACKER_PROTO_CMD_SERVICE_QUERY_UPDATE, fdfstest.Fault{Status: 16})
+	var orphans []*UploadAttempt
+	opts = &SafeUploadOptions{
+		Policy:   &RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond},
+		OnOrphan: func(attempt *UploadAttempt) { orphans = append(orphans, attempt) },
+	}
+	if _, err = fdfsClient.SafeUploadByBuffer([]byte("hello"), "txt", opts); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expect ErrBusy, actual %v", err)
+	}
+	if hits := server.FaultHits(TRACKER_PROTO_CMD_SERVICE_QUERY_UPDATE); hits != 3 {
+		t.Errorf("expect 2 attempts and 1 delete querying tracker, actual %d", hits)
+	}
+	server.ClearFaults()
+	if len(orphans) != 1 || orphans[0].Attempt != 2 {
+		t.Fatalf("unexpected orphans %+v", orphans)
+	}
+	if _, err = fdfsClient.QueryFileInfo(orphans[0].FileID); err != nil {
+		t.Errorf("expect orphan %s on server: %v", orphans[0].FileID, err)
+	}
+}
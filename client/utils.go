@@ -1,42 +1,80 @@
 package client
 
 import (
-	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/jslyzt/goconfig/config"
 )
 
-// Errno 错误
-type Errno struct {
-	status int
-}
+// FdfsConfigParser 配置文件解析器, 支持 "#include filename" 指令
+type FdfsConfigParser struct{}
 
-func (e Errno) Error() string {
-	errmsg := fmt.Sprintf("errno [%d] ", e.status)
-	switch e.status {
-	case 17:
-		errmsg += "File Exist"
-	case 22:
-		errmsg += "Argument Invlid"
+// ReadFile 读取文件, include 的文件相对于该文件所在目录
+func (parser *FdfsConfigParser) ReadFile(filename string) (*config.Config, error) {
+	data, err := expandConfigFile(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	data = mergeRepeatedOption(data, "tracker_server")
+	cf, err := config.ReadDefaultValue(data)
+	if cf == nil && err == nil {
+		cf = config.NewDefault()
 	}
-	return errmsg
+	return cf, err
 }
 
-// FdfsConfigParser 配置文件解析器
-type FdfsConfigParser struct{}
+// ReadData 读取内容, include 的文件相对于当前目录
+func (parser *FdfsConfigParser) ReadData(filename string) (*config.Config, error) {
+	data, err := expandConfigData(filename, ".", nil)
+	if err != nil {
+		return nil, err
+	}
+	data = mergeRepeatedOption(data, "tracker_server")
+	return config.ReadDefaultValue(data)
+}
 
-// ReadFile 读取文件
-func (parser *FdfsConfigParser) ReadFile(filename string) (*config.Config, error) {
-	return config.ReadDefault(filename)
+func expandConfigFile(filename string, including []string) (string, error) {
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range including {
+		if f == absFilename {
+			return "", fmt.Errorf("config include cycle: %s -> %s", strings.Join(including, " -> "), absFilename)
+		}
+	}
+	data, err := ioutil.ReadFile(absFilename)
+	if err != nil {
+		return "", err
+	}
+	return expandConfigData(string(data), filepath.Dir(absFilename), append(including, absFilename))
 }
 
-// ReadData 读取内容
-func (parser *FdfsConfigParser) ReadData(filename string) (*config.Config, error) {
-	return config.ReadDefaultValue(filename)
+func expandConfigData(data string, baseDir string, including []string) (string, error) {
+	buffer := new(strings.Builder)
+	for _, line := range strings.Split(data, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "#include" {
+			includeFile := fields[1]
+			if !filepath.IsAbs(includeFile) {
+				includeFile = filepath.Join(baseDir, includeFile)
+			}
+			included, err := expandConfigFile(includeFile, including)
+			if err != nil {
+				return "", err
+			}
+			buffer.WriteString(included)
+			continue
+		}
+		// goconfig 会丢弃没有换行结尾的最后一行, 每行都补上换行
+		buffer.WriteString(line)
+		buffer.WriteString("\n")
+	}
+	return buffer.String(), nil
 }
 
 func fdfsCheckFile(filename string) error {
@@ -50,7 +88,7 @@ func readCstr(buff io.Reader, length int) (string, error) {
 	str := make([]byte, length)
 	n, err := buff.Read(str)
 	if err != nil || n != len(str) {
-		return "", Errno{255}
+		return "", newProtocolError("read %d bytes string failed, actual: %d", length, n)
 	}
 
 	for i, v := range str {
@@ -72,7 +110,7 @@ func getFileExt(filename string) string {
 func splitRemoteFileID(remoteFileID string) ([]string, error) {
 	parts := strings.SplitN(remoteFileID, "/", 2)
 	if len(parts) < 2 {
-		return nil, errors.New("error remoteFileId")
+		return nil, fmt.Errorf("%w: remote file id [%s]", ErrInvalidArgument, remoteFileID)
 	}
 	return parts, nil
 }
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "fdfs_conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		filename := filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestConfigInclude(t *testing.T) {
+	dir := writeConfFiles(t, map[string]string{
+		"client.conf":            "tracker_server=10.0.1.32:22122\n#include conf.d/http.conf\n##include ignored.conf",
+		"conf.d/http.conf":       "http.tracker_server_port=8080\n#include anti_steal.conf",
+		"conf.d/anti_steal.conf": "http.anti_steal.check_token=true\nhttp.anti_steal.secret_key=secret",
+	})
+	defer os.RemoveAll(dir)
+
+	fc := &FdfsConfigParser{}
+	cf, err := fc.ReadFile(filepath.Join(dir, "client.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port, _ := cf.Int("DEFAULT", "http.tracker_server_port"); port != 8080 {
+		t.Errorf("included http.tracker_server_port not loaded: %d", port)
+	}
+	if secretKey, _ := cf.RawString("DEFAULT", "http.anti_steal.secret_key"); secretKey != "secret" {
+		t.Errorf("nested include not loaded: %s", secretKey)
+	}
+}
+
+func TestConfigIncludeCycle(t *testing.T) {
+	dir := writeConfFiles(t, map[string]string{
+		"a.conf": "#include b.conf\n",
+		"b.conf": "#include a.conf\n",
+	})
+	defer os.RemoveAll(dir)
+
+	fc := &FdfsConfigParser{}
+	if _, err := fc.ReadFile(filepath.Join(dir, "a.conf")); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expect include cycle error, actual %v", err)
+	}
+	if _, err := fc.ReadFile(filepath.Join(dir, "missing.conf")); err == nil {
+		t.Error("missing config should fail")
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// WireDumpMaxBytes 每次写入最多以十六进制输出的字节数, 超出部分只记录长度
+const WireDumpMaxBytes = 1024
+
+// cmdNames 客户端用到的命令名, 用于注释包头
+var cmdNames = map[int8]string{
+	TRACKER_PROTO_CMD_SERVER_LIST_ONE_GROUP:                 "TRACKER_PROTO_CMD_SERVER_LIST_ONE_GROUP",
+	TRACKER_PROTO_CMD_SERVER_LIST_ALL_GROUPS:                "TRACKER_PROTO_CMD_SERVER_LIST_ALL_GROUPS",
+	TRACKER_PROTO_CMD_SERVER_LIST_STORAGE:                   "TRACKER_PROTO_CMD_SERVER_LIST_STORAGE",
+	TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE: "TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE",
+	TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE:               "TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE",
+	TRACKER_PROTO_CMD_SERVICE_QUERY_UPDATE:                  "TRACKER_PROTO_CMD_SERVICE_QUERY_UPDATE",
+	TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITH_GROUP_ONE:    "TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITH_GROUP_ONE",
+	TRACKER_PROTO_CMD_RESP:                                  "TRACKER_PROTO_CMD_RESP",
+	FDFS_PROTO_CMD_ACTIVE_TEST:                              "FDFS_PROTO_CMD_ACTIVE_TEST",
+	STORAGE_PROTO_CMD_UPLOAD_FILE:                           "STORAGE_PROTO_CMD_UPLOAD_FILE",
+	STORAGE_PROTO_CMD_DELETE_FILE:                           "STORAGE_PROTO_CMD_DELETE_FILE",
+	STORAGE_PROTO_CMD_SET_METADATA:                          "STORAGE_PROTO_CMD_SET_METADATA",
+	STORAGE_PROTO_CMD_DOWNLOAD_FILE:                         "STORAGE_PROTO_CMD_DOWNLOAD_FILE",
+	STORAGE_PROTO_CMD_GET_METADATA:                          "STORAGE_PROTO_CMD_GET_METADATA",
+	STORAGE_PROTO_CMD_UPLOAD_SLAVE_FILE:                     "STORAGE_PROTO_CMD_UPLOAD_SLAVE_FILE",
+	STORAGE_PROTO_CMD_QUERY_FILE_INFO:                       "STORAGE_PROTO_CMD_QUERY_FILE_INFO",
+	STORAGE_PROTO_CMD_UPLOAD_APPENDER_FILE:                  "STORAGE_PROTO_CMD_UPLOAD_APPENDER_FILE",
+	STORAGE_PROTO_CMD_APPEND_FILE:                           "STORAGE_PROTO_CMD_APPEND_FILE",
+	STORAGE_PROTO_CMD_MODIFY_FILE:                           "STORAGE_PROTO_CMD_MODIFY_FILE",
+	STORAGE_PROTO_CMD_TRUNCATE_FILE:                         "STORAGE_PROTO_CMD_TRUNCATE_FILE",
+}
+
+// wireDump 把发送的包头和请求体, 以及收到的包头以十六进制输出, 响应体不输出
+// 每条记录以 "> addr" (发送) 或 "< addr" (接收) 开头, 一条记录只调用一次 Write
+type wireDump struct {
+	w     io.Writer
+	mutex sync.Mutex
+}
+
+// SetWireDump 输出本客户端的协议数据用于调试, nil 表示不输出
+// 连接池复用连接前的 active test 不输出
+func (client *FdfsClient) SetWireDump(w io.Writer) {
+	client.wireDump = nil
+	if w != nil {
+		client.wireDump = &wireDump{w: w}
+	}
+}
+
+// wireDumpOf 连接池中的连接才会输出
+func wireDumpOf(conn net.Conn) (*wireDump, string) {
+	if c, ok := conn.(*pConn); ok {
+		return c.hooks.getWireDump(), c.addr
+	}
+	return nil, ""
+}
+
+// header 注释包头, 接收时同时输出包头数据
+func (dump *wireDump) header(direction, addr string, th *trackerHeader, data []byte) {
+	name, ok := cmdNames[th.cmd]
+	if !ok {
+		name = "unknown"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s header pkg_len=%d cmd=%d(%s) status=%d\n", direction, addr, th.pkgLen, th.cmd, name, th.status)
+	if data != nil {
+		b.WriteString(hex.Dump(data))
+	}
+	dump.write(b.String())
+}
+
+func (dump *wireDump) data(direction, addr string, data []byte) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %d bytes\n", direction, addr, len(data))
+	if len(data) > WireDumpMaxBytes {
+		b.WriteString(hex.Dump(data[:WireDumpMaxBytes]))
+		fmt.Fprintf(&b, "... %d bytes omitted\n", len(data)-WireDumpMaxBytes)
+	} else {
+		b.WriteString(hex.Dump(data))
+	}
+	dump.write(b.String())
+}
+
+func (dump *wireDump) write(s string) {
+	dump.mutex.Lock()
+	defer dump.mutex.Unlock()
+	_, _ = io.WriteString(dump.w, s)
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWireDump(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	var buf bytes.Buffer
+	fdfsClient.SetWireDump(&buf)
+
+	data := []byte("hello wire dump")
+	uploadResponse, err := fdfsClient.UploadByBuffer(data, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := buf.String()
+	for _, expect := range []string{
+		fmt.Sprintf("> %s header pkg_len=0 cmd=101(TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE) status=0\n", server.TrackerAddr()),
+		fmt.Sprintf("< %s header pkg_len=40 cmd=100(TRACKER_PROTO_CMD_RESP) status=0\n", server.TrackerAddr()),
+		fmt.Sprintf("> %s header pkg_len=%d cmd=11(STORAGE_PROTO_CMD_UPLOAD_FILE) status=0\n", server.StorageAddr(), 15+len(data)),
+		fmt.Sprintf("> %s %d bytes\n", server.StorageAddr(), len(data)),
+		"|hello wire dump|",
+		fmt.Sprintf("> %s header pkg_len=%d cmd=14(STORAGE_PROTO_CMD_DOWNLOAD_FILE) status=0\n", server.StorageAddr(),
+			16+FDFS_GROUP_NAME_MAX_LEN+len(uploadResponse.RemoteFileID)-len(uploadResponse.GroupName)-1),
+		fmt.Sprintf("< %s header pkg_len=%d cmd=100(TRACKER_PROTO_CMD_RESP) status=0\n", server.StorageAddr(), len(data)),
+	} {
+		if !strings.Contains(dump, expect) {
+			t.Errorf("dump does not contain %q:\n%s", expect, dump)
+		}
+	}
+	// 响应体不输出
+	if strings.Count(dump, "|hello wire dump|") != 1 {
+		t.Errorf("response body should not be dumped:\n%s", dump)
+	}
+
+	fdfsClient.SetWireDump(nil)
+	buf.Reset()
+	if _, err = fdfsClient.DownloadToBuffer(uploadResponse.RemoteFileID, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected dump after disabled:\n%s", buf.String())
+	}
+}
+
+func TestWireDumpTruncate(t *testing.T) {
+	var buf bytes.Buffer
+	dump := &wireDump{w: &buf}
+	dump.data(">", "127.0.0.1:23000", make([]byte, WireDumpMaxBytes+10))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != fmt.Sprintf("> 127.0.0.1:23000 %d bytes", WireDumpMaxBytes+10) || lines[len(lines)-1] != "... 10 bytes omitted" {
+		t.Errorf("unexpected dump:\n%s", buf.String())
+	}
+	if len(lines) != WireDumpMaxBytes/16+2 {
+		t.Errorf("expect %d lines, actual %d", WireDumpMaxBytes/16+2, len(lines))
+	}
+}
+
+// TestHooksPerClient 共享 storage 连接池的两个客户端, 指标和协议调试输出互不影响
+func TestHooksPerClient(t *testing.T) {
+	client1, server := newTestClient(t)
+	cfg, err := LoadClientConfig("", server.ConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client2, err := NewFdfsClientByConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf1, buf2 bytes.Buffer
+	metrics1, metrics2 := &recordingMetrics{}, &recordingMetrics{}
+	client1.SetWireDump(&buf1)
+	client1.SetMetrics(metrics1)
+	client2.SetWireDump(&buf2)
+	client2.SetMetrics(metrics2)
+
+	if _, err = client1.UploadByBuffer([]byte("client1"), "txt"); err != nil {
+		t.Fatal(err)
+	}
+	// client1 取得 storage 连接池后, client2 使用同一个连接池, client1 的输出仍然只写到 client1
+	tc, srv, store, err := client1.getUploadArg(client1.startOp("upload", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client2.UploadByBuffer([]byte("client2"), "txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.storageUploadByBuffer(tc, srv, []byte("client1"), "txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf1.String(), "|client2|") || strings.Count(buf1.String(), "|client1|") != 2 {
+		t.Errorf("unexpected dump of client1:\n%s", buf1.String())
+	}
+	if strings.Contains(buf2.String(), "|client1|") || strings.Count(buf2.String(), "|client2|") != 1 {
+		t.Errorf("unexpected dump of client2:\n%s", buf2.String())
+	}
+	storage := server.StorageAddr()
+	metrics1.mutex.Lock()
+	defer metrics1.mutex.Unlock()
+	metrics2.mutex.Lock()
+	defer metrics2.mutex.Unlock()
+	// 每次上传发送 10 字节包头和 15 字节请求参数
+	if sent := metrics1.bytes[DirectionSent+" "+storage]; sent != 2*(25+int64(len("client1"))) {
+		t.Errorf("unexpected bytes sent by client1: %d", sent)
+	}
+	if sent := metrics2.bytes[DirectionSent+" "+storage]; sent != 25+int64(len("client2")) {
+		t.Errorf("unexpected bytes sent by client2: %d", sent)
+	}
+}
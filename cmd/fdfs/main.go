@@ -0,0 +1,405 @@
+// fdfs 命令行工具, 对应 fastdfs 自带的 fdfs_upload_file, fdfs_download_file, fdfs_delete_file, fdfs_file_info, fdfs_monitor 等命令
+//
+//	fdfs [-c client.conf] [-json] [-dump] <command> [arguments]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+// defaultConfPath 默认配置文件, 可以用环境变量 FDFS_CLIENT_CONF 或 -c 参数指定
+const defaultConfPath = "/etc/fdfs/client.conf"
+
+const usage = `usage: fdfs [-c client.conf] [-json] [-dump] <command> [arguments]
+
+commands:
+  upload [-group name] [-appender] [-ext ext] <local_file|->
+  download [-offset n] [-size n] <file_id> [local_file|-]
+  delete <file_id>
+  info <file_id>
+  append <appender_file_id> <local_file|->
+  meta get <file_id>
+  meta set [-merge] <file_id> <name=value>...
+  monitor [-group name] [-format text|json|prometheus] [-watch interval]
+  sync upload -manifest file [-group name] [-concurrency n] <local_dir>
+  sync download -manifest file [-concurrency n] <local_dir>
+`
+
+// errUsage 参数错误, 退出码为2
+var errUsage = errors.New("usage error")
+
+type command struct {
+	client *client.FdfsClient
+	json   bool
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run 执行命令并返回退出码
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	confPath := os.Getenv("FDFS_CLIENT_CONF")
+	if len(confPath) == 0 {
+		confPath = defaultConfPath
+	}
+
+	fs := flag.NewFlagSet("fdfs", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	fs.StringVar(&confPath, "c", confPath, "client.conf path")
+	jsonOutput := fs.Bool("json", false, "print result as json")
+	dump := fs.Bool("dump", false, "dump protocol headers and request bodies to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// 子命令先解析参数, 返回执行函数, 参数错误时不需要连接 tracker
+	handlers := map[string]func(*command, []string) (func() error, error){
+		"upload":   (*command).upload,
+		"download": (*command).download,
+		"delete":   (*command).delete,
+		"info":     (*command).info,
+		"append":   (*command).append,
+		"meta":     (*command).meta,
+		"monitor":  (*command).monitor,
+		"sync":     (*command).sync,
+	}
+	handler, ok := handlers[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "fdfs: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	cmd := &command{json: *jsonOutput, stdin: stdin, stdout: stdout, stderr: stderr}
+	exec, err := handler(cmd, fs.Args()[1:])
+	if err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "fdfs: %v\n", err)
+		return 1
+	}
+
+	if cmd.client, err = client.NewFdfsClient(confPath); err != nil {
+		fmt.Fprintf(stderr, "fdfs: %v\n", err)
+		return 1
+	}
+	if *dump {
+		cmd.client.SetWireDump(stderr)
+	}
+	if err = exec(); err != nil {
+		fmt.Fprintf(stderr, "fdfs: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (cmd *command) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(cmd.stderr)
+	fs.Usage = func() {}
+	return fs
+}
+
+// parse 解析子命令参数, 检查位置参数个数在 [min, max] 之间, max 小于0表示不限
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return errUsage
+	}
+	return nil
+}
+
+func (cmd *command) upload(args []string) (func() error, error) {
+	fs := cmd.flagSet("upload")
+	groupName := fs.String("group", "", "group name")
+	appender := fs.Bool("appender", false, "upload as appender file")
+	ext := fs.String("ext", "", "file ext name, default is ext of local file")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		var (
+			resp *client.UploadFileResponse
+			err  error
+		)
+		localFile := fs.Arg(0)
+		if localFile == "-" || len(*ext) > 0 {
+			resp, err = cmd.uploadStream(localFile, *ext, *groupName, *appender)
+		} else if *appender {
+			resp, err = cmd.client.UploadAppenderByFilename(localFile, *groupName)
+		} else {
+			resp, err = cmd.client.UploadByFilename(localFile, *groupName)
+		}
+		if err != nil {
+			return err
+		}
+		return cmd.print(map[string]interface{}{
+			"group_name": resp.GroupName,
+			"file_id":    resp.RemoteFileID,
+		}, resp.RemoteFileID)
+	}, nil
+}
+
+// uploadStream 按流上传本地文件或标准输入, 不把整个文件读到内存
+func (cmd *command) uploadStream(name, ext, groupName string, appender bool) (*client.UploadFileResponse, error) {
+	in, err := cmd.openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	switch {
+	case in.size == 0 && appender:
+		return cmd.client.UploadAppenderByBuffer(nil, ext, groupName)
+	case in.size == 0:
+		return cmd.client.UploadByBuffer(nil, ext, groupName)
+	case appender:
+		return cmd.client.UploadAppenderByStream(in, in.size, ext, groupName)
+	}
+	return cmd.client.UploadByStream(in, in.size, ext, groupName)
+}
+
+func (cmd *command) download(args []string) (func() error, error) {
+	fs := cmd.flagSet("download")
+	offset := fs.Int64("offset", 0, "download offset")
+	size := fs.Int64("size", 0, "download size, 0 for the whole file")
+	if err := parse(fs, args, 1, 2); err != nil {
+		return nil, err
+	}
+
+	fileID := fs.Arg(0)
+	localFile := fs.Arg(1)
+	if len(localFile) == 0 {
+		localFile = path.Base(fileID)
+	}
+	return func() error {
+		if localFile == "-" {
+			resp, err := cmd.client.DownloadToBuffer(fileID, *offset, *size)
+			if err != nil {
+				return err
+			}
+			content, _ := resp.Content.([]byte)
+			_, err = cmd.stdout.Write(content)
+			return err
+		}
+
+		resp, err := cmd.client.DownloadToFile(localFile, fileID, *offset, *size)
+		if err != nil {
+			return err
+		}
+		return cmd.print(map[string]interface{}{
+			"file_id":    fileID,
+			"local_file": localFile,
+			"size":       resp.DownloadSize,
+		}, localFile)
+	}, nil
+}
+
+func (cmd *command) delete(args []string) (func() error, error) {
+	fs := cmd.flagSet("delete")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return nil, err
+	}
+	return func() error {
+		if err := cmd.client.DeleteFile(fs.Arg(0)); err != nil {
+			return err
+		}
+		if cmd.json {
+			return cmd.print(map[string]interface{}{"file_id": fs.Arg(0)}, "")
+		}
+		return nil
+	}, nil
+}
+
+func (cmd *command) info(args []string) (func() error, error) {
+	fs := cmd.flagSet("info")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return nil, err
+	}
+	return func() error {
+		info, err := cmd.client.QueryFileInfo(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		text := fmt.Sprintf("source ip address: %s\nfile create timestamp: %s\nfile size: %d\nfile crc32: %d (0x%08x)",
+			info.SourceIPAddr, info.CreateTimestamp.Format("2006-01-02 15:04:05"), info.FileSize, info.CRC32, info.CRC32)
+		return cmd.print(map[string]interface{}{
+			"file_id":          fs.Arg(0),
+			"source_ip_addr":   info.SourceIPAddr,
+			"create_timestamp": info.CreateTimestamp.Format(time.RFC3339),
+			"file_size":        info.FileSize,
+			"crc32":            info.CRC32,
+		}, text)
+	}, nil
+}
+
+func (cmd *command) append(args []string) (func() error, error) {
+	fs := cmd.flagSet("append")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return nil, err
+	}
+	return func() error {
+		in, err := cmd.openInput(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		if in.size > 0 {
+			if err = cmd.client.AppendByStream(in, in.size, fs.Arg(0)); err != nil {
+				return err
+			}
+		}
+		if cmd.json {
+			return cmd.print(map[string]interface{}{"file_id": fs.Arg(0), "size": in.size}, "")
+		}
+		return nil
+	}, nil
+}
+
+func (cmd *command) meta(args []string) (func() error, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+	switch args[0] {
+	case "get":
+		fs := cmd.flagSet("meta get")
+		if err := parse(fs, args[1:], 1, 1); err != nil {
+			return nil, err
+		}
+		return func() error {
+			meta, err := cmd.client.GetMetadata(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			names := make([]string, 0, len(meta))
+			for name := range meta {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			lines := make([]string, 0, len(names))
+			for _, name := range names {
+				lines = append(lines, name+"="+meta[name])
+			}
+			return cmd.print(map[string]interface{}{"file_id": fs.Arg(0), "metadata": meta}, strings.Join(lines, "\n"))
+		}, nil
+	case "set":
+		fs := cmd.flagSet("meta set")
+		merge := fs.Bool("merge", false, "merge with existing metadata instead of overwrite")
+		if err := parse(fs, args[1:], 2, -1); err != nil {
+			return nil, err
+		}
+		meta := make(map[string]string)
+		for _, arg := range fs.Args()[1:] {
+			i := strings.Index(arg, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid metadata %q, expect name=value", arg)
+			}
+			meta[arg[:i]] = arg[i+1:]
+		}
+		opFlag := byte(client.STORAGE_SET_METADATA_FLAG_OVERWRITE)
+		if *merge {
+			opFlag = client.STORAGE_SET_METADATA_FLAG_MERGE
+		}
+		return func() error {
+			if err := cmd.client.SetMetadata(fs.Arg(0), meta, opFlag); err != nil {
+				return err
+			}
+			if cmd.json {
+				return cmd.print(map[string]interface{}{"file_id": fs.Arg(0), "metadata": meta}, "")
+			}
+			return nil
+		}, nil
+	}
+	return nil, errUsage
+}
+
+// input 本地文件或标准输入
+type input struct {
+	*os.File
+	size  int64
+	close func() error
+}
+
+func (in *input) Close() error {
+	return in.close()
+}
+
+// openInput 打开本地文件, "-" 表示标准输入
+// 标准输入不是普通文件时先写到临时文件, 以便按流上传而不是整个读到内存
+func (cmd *command) openInput(name string) (*input, error) {
+	var in *input
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		in = &input{File: file, close: file.Close}
+	} else if file, ok := cmd.stdin.(*os.File); ok && isRegular(file) {
+		in = &input{File: file, close: func() error { return nil }}
+	} else {
+		file, err := os.CreateTemp("", "fdfs-stdin-")
+		if err != nil {
+			return nil, err
+		}
+		in = &input{File: file, close: func() error {
+			err := file.Close()
+			_ = os.Remove(file.Name())
+			return err
+		}}
+		if _, err = io.Copy(file, cmd.stdin); err != nil {
+			_ = in.Close()
+			return nil, err
+		}
+	}
+
+	info, err := in.Stat()
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	in.size = info.Size()
+	return in, nil
+}
+
+func isRegular(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// print 输出结果, -json 时输出 value, 否则输出 text
+func (cmd *command) print(value interface{}, text string) error {
+	if cmd.json {
+		encoder := json.NewEncoder(cmd.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	if len(text) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintln(cmd.stdout, text)
+	return err
+}
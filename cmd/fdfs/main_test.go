@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func newTestConf(t *testing.T) (string, *fdfstest.Server) {
+	server, err := fdfstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	confPath := filepath.Join(t.TempDir(), "client.conf")
+	if err = os.WriteFile(confPath, []byte(server.ConfigData()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return confPath, server
+}
+
+func runCmd(t *testing.T, stdin string, args ...string) (string, int) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	if code != 0 {
+		t.Logf("fdfs %v: %s", args, stderr.String())
+	}
+	return stdout.String(), code
+}
+
+func TestCommands(t *testing.T) {
+	confPath, server := newTestConf(t)
+
+	out, code := runCmd(t, "hello", "-c", confPath, "-json", "upload", "-appender", "-ext", "log", "-")
+	if code != 0 {
+		t.Fatalf("upload exit %d", code)
+	}
+	var uploaded struct {
+		FileID string `json:"file_id"`
+	}
+	if err := json.Unmarshal([]byte(out), &uploaded); err != nil || !strings.HasSuffix(uploaded.FileID, ".log") {
+		t.Fatalf("unexpected upload output %q %v", out, err)
+	}
+	fileID := uploaded.FileID
+
+	if _, code = runCmd(t, " world", "-c", confPath, "append", fileID, "-"); code != 0 {
+		t.Fatalf("append exit %d", code)
+	}
+	if out, code = runCmd(t, "", "-c", confPath, "download", "-offset", "6", fileID, "-"); code != 0 || out != "world" {
+		t.Errorf("download exit %d, output %q", code, out)
+	}
+	if out, code = runCmd(t, "", "-c", confPath, "info", fileID); code != 0 || !strings.Contains(out, "file size: 11\n") {
+		t.Errorf("info exit %d, output %q", code, out)
+	}
+
+	if _, code = runCmd(t, "", "-c", confPath, "meta", "set", fileID, "b=2", "a=1"); code != 0 {
+		t.Fatalf("meta set exit %d", code)
+	}
+	if out, code = runCmd(t, "", "-c", confPath, "meta", "get", fileID); code != 0 || out != "a=1\nb=2\n" {
+		t.Errorf("meta get exit %d, output %q", code, out)
+	}
+
+	if _, code = runCmd(t, "", "-c", confPath, "delete", fileID); code != 0 {
+		t.Fatalf("delete exit %d", code)
+	}
+	if len(server.Files()) != 0 {
+		t.Errorf("file not deleted: %v", server.Files())
+	}
+	if _, code = runCmd(t, "", "-c", confPath, "delete", fileID); code != 1 {
+		t.Errorf("expect exit 1 deleting missing file, actual %d", code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	// 参数错误在连接 tracker 之前返回, 配置文件不存在也不影响
+	confPath := filepath.Join(t.TempDir(), "missing.conf")
+	for _, args := range [][]string{
+		{},
+		{"-c", confPath, "unknown"},
+		{"-c", confPath, "upload"},
+		{"-c", confPath, "download", "a", "b", "c"},
+		{"-c", confPath, "meta", "set", "group1/M00/00/00/a.txt"},
+		{"-c", confPath, "monitor", "-format", "xml"},
+		{"-c", confPath, "sync", "upload", "dir"},
+		{"-c", confPath, "sync", "copy", "-manifest", "m.json", "dir"},
+	} {
+		if _, code := runCmd(t, "", args...); code != 2 {
+			t.Errorf("fdfs %v: expect exit 2, actual %d", args, code)
+		}
+	}
+
+	var stderr bytes.Buffer
+	args := []string{"-c", confPath, "meta", "set", "group1/M00/00/00/a.txt", "a"}
+	if code := run(args, strings.NewReader(""), &bytes.Buffer{}, &stderr); code != 1 || !strings.Contains(stderr.String(), "invalid metadata") {
+		t.Errorf("fdfs %v: expect invalid metadata, exit %d, stderr %q", args, code, stderr.String())
+	}
+}
+
+func TestUploadStream(t *testing.T) {
+	confPath, _ := newTestConf(t)
+	download := func(fileID string) string {
+		out, code := runCmd(t, "", "-c", confPath, "download", fileID, "-")
+		if code != 0 {
+			t.Fatalf("download exit %d", code)
+		}
+		return out
+	}
+
+	// 本地文件指定扩展名
+	localFile := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(localFile, []byte("local file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileID, code := runCmd(t, "", "-c", confPath, "upload", "-ext", "txt", localFile)
+	fileID = strings.TrimSpace(fileID)
+	if code != 0 || !strings.HasSuffix(fileID, ".txt") {
+		t.Fatalf("upload exit %d, file id %q", code, fileID)
+	}
+	if out := download(fileID); out != "local file" {
+		t.Errorf("unexpected content %q", out)
+	}
+
+	// 标准输入为空
+	fileID, code = runCmd(t, "", "-c", confPath, "upload", "-")
+	if fileID = strings.TrimSpace(fileID); code != 0 {
+		t.Fatalf("upload empty stdin exit %d", code)
+	}
+	if out := download(fileID); out != "" {
+		t.Errorf("unexpected content %q", out)
+	}
+
+	// 标准输入为普通文件时直接按流上传
+	stdin, err := os.Open(localFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	var stdout, stderr bytes.Buffer
+	if code = run([]string{"-c", confPath, "upload", "-appender", "-"}, stdin, &stdout, &stderr); code != 0 {
+		t.Fatalf("upload regular stdin exit %d: %s", code, stderr.String())
+	}
+	fileID = strings.TrimSpace(stdout.String())
+	if _, code = runCmd(t, "", "-c", confPath, "append", fileID, localFile); code != 0 {
+		t.Fatalf("append exit %d", code)
+	}
+	if out := download(fileID); out != "local filelocal file" {
+		t.Errorf("unexpected content %q", out)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	confPath, server := newTestConf(t)
+	if _, code := runCmd(t, "hello", "-c", confPath, "upload", "-ext", "txt", "-"); code != 0 {
+		t.Fatalf("upload exit %d", code)
+	}
+
+	out, code := runCmd(t, "", "-c", confPath, "monitor")
+	if code != 0 || !strings.Contains(out, "group name = group1\n") || !strings.Contains(out, "ip_addr = 127.0.0.1  ACTIVE\n") {
+		t.Errorf("monitor exit %d, output %q", code, out)
+	}
+
+	out, code = runCmd(t, "", "-c", confPath, "-json", "monitor")
+	var status struct {
+		Groups []struct {
+			GroupName string `json:"group_name"`
+			Storages  []struct {
+				StatusName  string `json:"status_name"`
+				StoragePort int    `json:"storage_port"`
+			} `json:"storages"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal([]byte(out), &status); code != 0 || err != nil {
+		t.Fatalf("monitor exit %d, output %q, error %v", code, out, err)
+	}
+	if len(status.Groups) != 1 || len(status.Groups[0].Storages) != 1 ||
+		status.Groups[0].Storages[0].StoragePort != server.StoragePort() {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	out, code = runCmd(t, "", "-c", confPath, "monitor", "-format", "prometheus")
+	for _, line := range []string{
+		"# TYPE fdfs_group_free_mb gauge\n",
+		`fdfs_storage_up{group="group1",id="127.0.0.1",ip="127.0.0.1"} 1` + "\n",
+		`fdfs_storage_operations_success_total{group="group1",id="127.0.0.1",ip="127.0.0.1",op="upload"} 1` + "\n",
+	} {
+		if code != 0 || !strings.Contains(out, line) {
+			t.Errorf("monitor exit %d, expect %q in output %q", code, line, out)
+		}
+	}
+
+	if _, code = runCmd(t, "", "-c", confPath, "monitor", "-group", "group2"); code != 1 {
+		t.Errorf("expect exit 1 for unknown group, actual %d", code)
+	}
+}
+
+func TestSync(t *testing.T) {
+	confPath, server := newTestConf(t)
+	src := t.TempDir()
+	for rel, content := range map[string]string{"a.txt": "a", "sub/b.txt": "b"} {
+		filename := filepath.Join(src, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := filepath.Join(t.TempDir(), "manifest.json")
+
+	if out, code := runCmd(t, "", "-c", confPath, "sync", "upload", "-manifest", manifest, src); code != 0 ||
+		!strings.HasSuffix(out, "2 uploaded, 0 skipped\n") {
+		t.Fatalf("sync upload exit %d, output %q", code, out)
+	}
+	if out, code := runCmd(t, "", "-c", confPath, "sync", "upload", "-manifest", manifest, src); code != 0 ||
+		!strings.HasSuffix(out, "0 uploaded, 2 skipped\n") {
+		t.Errorf("sync upload exit %d, output %q", code, out)
+	}
+	if len(server.Files()) != 2 {
+		t.Errorf("unexpected files %v", server.Files())
+	}
+
+	dst := t.TempDir()
+	if out, code := runCmd(t, "", "-c", confPath, "sync", "download", "-manifest", manifest, dst); code != 0 ||
+		!strings.HasSuffix(out, "2 downloaded, 0 skipped\n") {
+		t.Fatalf("sync download exit %d, output %q", code, out)
+	}
+	if content, err := os.ReadFile(filepath.Join(dst, "sub", "b.txt")); err != nil || string(content) != "b" {
+		t.Errorf("unexpected content %q %v", content, err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+// groupStatus 组及其 storage 的状态
+type groupStatus struct {
+	*client.GroupStat
+	Storages []storageStatus `json:"storages"`
+}
+
+type storageStatus struct {
+	*client.StorageStat
+	StatusName       string  `json:"status_name"`
+	SyncDelaySeconds float64 `json:"sync_delay_seconds"`
+}
+
+func (cmd *command) monitor(args []string) (func() error, error) {
+	fs := cmd.flagSet("monitor")
+	groupName := fs.String("group", "", "only show this group")
+	format := fs.String("format", "text", "output format: text, json or prometheus")
+	watch := fs.Duration("watch", 0, "refresh interval, 0 to print once")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return nil, err
+	}
+	if cmd.json {
+		*format = "json"
+	}
+	var write func(io.Writer, []groupStatus) error
+	switch *format {
+	case "text":
+		write = writeMonitorText
+	case "json":
+		write = writeMonitorJSON
+	case "prometheus":
+		write = writeMonitorPrometheus
+	default:
+		return nil, errUsage
+	}
+
+	return func() error {
+		if *watch <= 0 {
+			groups, err := cmd.clusterStatus(*groupName)
+			if err != nil {
+				return err
+			}
+			return write(cmd.stdout, groups)
+		}
+
+		// watch 模式下单次查询失败只打印错误, 直到收到中断信号
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		ticker := time.NewTicker(*watch)
+		defer ticker.Stop()
+		for {
+			if *format == "text" {
+				fmt.Fprintf(cmd.stdout, "==== %s ====\n", time.Now().Format("2006-01-02 15:04:05"))
+			}
+			groups, err := cmd.clusterStatus(*groupName)
+			if err == nil {
+				err = write(cmd.stdout, groups)
+			}
+			if err != nil {
+				fmt.Fprintf(cmd.stderr, "fdfs: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}, nil
+}
+
+func (cmd *command) clusterStatus(groupName string) ([]groupStatus, error) {
+	groups, err := cmd.client.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]groupStatus, 0, len(groups))
+	for _, group := range groups {
+		if len(groupName) > 0 && group.GroupName != groupName {
+			continue
+		}
+		storages, err := cmd.client.ListStorages(group.GroupName)
+		if err != nil {
+			return nil, err
+		}
+		gs := groupStatus{GroupStat: group, Storages: make([]storageStatus, 0, len(storages))}
+		for _, storage := range storages {
+			gs.Storages = append(gs.Storages, storageStatus{
+				StorageStat:      storage,
+				StatusName:       storage.StatusName(),
+				SyncDelaySeconds: client.SyncDelay(storage, storages).Seconds(),
+			})
+		}
+		status = append(status, gs)
+	}
+	if len(groupName) > 0 && len(status) == 0 {
+		return nil, fmt.Errorf("group %s not found", groupName)
+	}
+	return status, nil
+}
+
+// writeMonitorText 与 fdfs_monitor 的输出格式相近
+func writeMonitorText(w io.Writer, groups []groupStatus) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "group count: %d\n", len(groups))
+	for i, group := range groups {
+		fmt.Fprintf(&b, "\nGroup %d:\n", i+1)
+		fmt.Fprintf(&b, "group name = %s\n", group.GroupName)
+		fmt.Fprintf(&b, "disk total space = %d MB\n", group.TotalMB)
+		fmt.Fprintf(&b, "disk free space = %d MB\n", group.FreeMB)
+		fmt.Fprintf(&b, "trunk free space = %d MB\n", group.TrunkFreeMB)
+		fmt.Fprintf(&b, "storage server count = %d\n", group.StorageCount)
+		fmt.Fprintf(&b, "active server count = %d\n", group.ActiveCount)
+		fmt.Fprintf(&b, "storage server port = %d\n", group.StoragePort)
+		fmt.Fprintf(&b, "storage HTTP port = %d\n", group.StorageHTTPPort)
+		fmt.Fprintf(&b, "store path count = %d\n", group.StorePathCount)
+		fmt.Fprintf(&b, "subdir count per path = %d\n", group.SubdirCountPerPath)
+		fmt.Fprintf(&b, "current write server index = %d\n", group.CurrentWriteServer)
+		fmt.Fprintf(&b, "current trunk file id = %d\n", group.CurrentTrunkFileID)
+
+		for j, storage := range group.Storages {
+			c := storage.Counters
+			fmt.Fprintf(&b, "\n\tStorage %d:\n", j+1)
+			fmt.Fprintf(&b, "\t\tid = %s\n", storage.ID)
+			fmt.Fprintf(&b, "\t\tip_addr = %s  %s\n", storage.IPAddr, storage.StatusName)
+			fmt.Fprintf(&b, "\t\tversion = %s\n", storage.Version)
+			fmt.Fprintf(&b, "\t\tjoin time = %s\n", formatTime(storage.JoinTime))
+			fmt.Fprintf(&b, "\t\tup time = %s\n", formatTime(storage.UpTime))
+			fmt.Fprintf(&b, "\t\ttotal storage = %d MB\n", storage.TotalMB)
+			fmt.Fprintf(&b, "\t\tfree storage = %d MB\n", storage.FreeMB)
+			fmt.Fprintf(&b, "\t\tupload priority = %d\n", storage.UploadPriority)
+			fmt.Fprintf(&b, "\t\tstore_path_count = %d\n", storage.StorePathCount)
+			fmt.Fprintf(&b, "\t\tstorage_port = %d\n", storage.StoragePort)
+			fmt.Fprintf(&b, "\t\tstorage_http_port = %d\n", storage.StorageHTTPPort)
+			fmt.Fprintf(&b, "\t\tcurrent_write_path = %d\n", storage.CurrentWritePath)
+			fmt.Fprintf(&b, "\t\tsource storage id = %s\n", storage.SrcID)
+			fmt.Fprintf(&b, "\t\tif_trunk_server = %d\n", boolInt(storage.IsTrunkServer))
+			fmt.Fprintf(&b, "\t\ttotal_upload_count = %d\n", c.TotalUploadCount)
+			fmt.Fprintf(&b, "\t\tsuccess_upload_count = %d\n", c.SuccessUploadCount)
+			fmt.Fprintf(&b, "\t\ttotal_append_count = %d\n", c.TotalAppendCount)
+			fmt.Fprintf(&b, "\t\tsuccess_append_count = %d\n", c.SuccessAppendCount)
+			fmt.Fprintf(&b, "\t\ttotal_modify_count = %d\n", c.TotalModifyCount)
+			fmt.Fprintf(&b, "\t\tsuccess_modify_count = %d\n", c.SuccessModifyCount)
+			fmt.Fprintf(&b, "\t\ttotal_truncate_count = %d\n", c.TotalTruncateCount)
+			fmt.Fprintf(&b, "\t\tsuccess_truncate_count = %d\n", c.SuccessTruncateCount)
+			fmt.Fprintf(&b, "\t\ttotal_set_meta_count = %d\n", c.TotalSetMetaCount)
+			fmt.Fprintf(&b, "\t\tsuccess_set_meta_count = %d\n", c.SuccessSetMetaCount)
+			fmt.Fprintf(&b, "\t\ttotal_delete_count = %d\n", c.TotalDeleteCount)
+			fmt.Fprintf(&b, "\t\tsuccess_delete_count = %d\n", c.SuccessDeleteCount)
+			fmt.Fprintf(&b, "\t\ttotal_download_count = %d\n", c.TotalDownloadCount)
+			fmt.Fprintf(&b, "\t\tsuccess_download_count = %d\n", c.SuccessDownloadCount)
+			fmt.Fprintf(&b, "\t\ttotal_get_meta_count = %d\n", c.TotalGetMetaCount)
+			fmt.Fprintf(&b, "\t\tsuccess_get_meta_count = %d\n", c.SuccessGetMetaCount)
+			fmt.Fprintf(&b, "\t\ttotal_create_link_count = %d\n", c.TotalCreateLinkCount)
+			fmt.Fprintf(&b, "\t\tsuccess_create_link_count = %d\n", c.SuccessCreateLinkCount)
+			fmt.Fprintf(&b, "\t\ttotal_upload_bytes = %d\n", c.TotalUploadBytes)
+			fmt.Fprintf(&b, "\t\tsuccess_upload_bytes = %d\n", c.SuccessUploadBytes)
+			fmt.Fprintf(&b, "\t\ttotal_download_bytes = %d\n", c.TotalDownloadBytes)
+			fmt.Fprintf(&b, "\t\tsuccess_download_bytes = %d\n", c.SuccessDownloadBytes)
+			fmt.Fprintf(&b, "\t\ttotal_sync_in_bytes = %d\n", c.TotalSyncInBytes)
+			fmt.Fprintf(&b, "\t\tsuccess_sync_in_bytes = %d\n", c.SuccessSyncInBytes)
+			fmt.Fprintf(&b, "\t\ttotal_sync_out_bytes = %d\n", c.TotalSyncOutBytes)
+			fmt.Fprintf(&b, "\t\tsuccess_sync_out_bytes = %d\n", c.SuccessSyncOutBytes)
+			fmt.Fprintf(&b, "\t\tlast_heart_beat_time = %s\n", formatTime(storage.LastHeartBeatTime))
+			fmt.Fprintf(&b, "\t\tlast_source_update = %s\n", formatTime(storage.LastSourceUpdate))
+			fmt.Fprintf(&b, "\t\tlast_sync_update = %s\n", formatTime(storage.LastSyncUpdate))
+			fmt.Fprintf(&b, "\t\tlast_synced_timestamp = %s (%ds delay)\n", formatTime(storage.LastSyncedTime),
+				int64(storage.SyncDelaySeconds))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMonitorJSON(w io.Writer, groups []groupStatus) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{"groups": groups})
+}
+
+// promMetric prometheus 文本格式的一个指标
+type promMetric struct {
+	name    string
+	help    string
+	typ     string
+	samples []string
+}
+
+func (m *promMetric) add(labels string, value float64) {
+	m.samples = append(m.samples, m.name+"{"+labels+"} "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// writeMonitorPrometheus prometheus 文本格式, 可以配合 node_exporter 的 textfile collector 使用
+func writeMonitorPrometheus(w io.Writer, groups []groupStatus) error {
+	var metrics []*promMetric
+	metric := func(name, typ, help string) *promMetric {
+		m := &promMetric{name: name, typ: typ, help: help}
+		metrics = append(metrics, m)
+		return m
+	}
+	groupTotal := metric("fdfs_group_total_mb", "gauge", "Total disk space of the group in MB.")
+	groupFree := metric("fdfs_group_free_mb", "gauge", "Free disk space of the group in MB.")
+	groupTrunkFree := metric("fdfs_group_trunk_free_mb", "gauge", "Free trunk space of the group in MB.")
+	groupStorages := metric("fdfs_group_storage_count", "gauge", "Number of storage servers in the group.")
+	groupActive := metric("fdfs_group_active_count", "gauge", "Number of active storage servers in the group.")
+	storageUp := metric("fdfs_storage_up", "gauge", "Whether the storage server is ACTIVE.")
+	storageStatus := metric("fdfs_storage_status", "gauge", "Status code of the storage server.")
+	storageTotal := metric("fdfs_storage_total_mb", "gauge", "Total disk space of the storage server in MB.")
+	storageFree := metric("fdfs_storage_free_mb", "gauge", "Free disk space of the storage server in MB.")
+	syncDelay := metric("fdfs_storage_sync_delay_seconds", "gauge", "Seconds the storage server is behind the other servers of its group.")
+	heartBeat := metric("fdfs_storage_last_heart_beat_timestamp_seconds", "gauge", "Unix time of the last heart beat.")
+	ops := metric("fdfs_storage_operations_total", "counter", "Number of operations handled by the storage server.")
+	opsSuccess := metric("fdfs_storage_operations_success_total", "counter", "Number of successful operations handled by the storage server.")
+	bytesTotal := metric("fdfs_storage_bytes_total", "counter", "Bytes transferred by the storage server.")
+	bytesSuccess := metric("fdfs_storage_bytes_success_total", "counter", "Bytes successfully transferred by the storage server.")
+
+	for _, group := range groups {
+		labels := "group=" + strconv.Quote(group.GroupName)
+		groupTotal.add(labels, float64(group.TotalMB))
+		groupFree.add(labels, float64(group.FreeMB))
+		groupTrunkFree.add(labels, float64(group.TrunkFreeMB))
+		groupStorages.add(labels, float64(group.StorageCount))
+		groupActive.add(labels, float64(group.ActiveCount))
+
+		for _, storage := range group.Storages {
+			labels := fmt.Sprintf("group=%s,id=%s,ip=%s", strconv.Quote(group.GroupName), strconv.Quote(storage.ID),
+				strconv.Quote(storage.IPAddr))
+			storageUp.add(labels, float64(boolInt(storage.Status == client.FDFS_STORAGE_STATUS_ACTIVE)))
+			storageStatus.add(labels, float64(storage.Status))
+			storageTotal.add(labels, float64(storage.TotalMB))
+			storageFree.add(labels, float64(storage.FreeMB))
+			syncDelay.add(labels, storage.SyncDelaySeconds)
+			if !storage.LastHeartBeatTime.IsZero() {
+				heartBeat.add(labels, float64(storage.LastHeartBeatTime.Unix()))
+			}
+
+			c := storage.Counters
+			for _, op := range []struct {
+				name           string
+				total, success int64
+			}{
+				{"upload", c.TotalUploadCount, c.SuccessUploadCount},
+				{"append", c.TotalAppendCount, c.SuccessAppendCount},
+				{"modify", c.TotalModifyCount, c.SuccessModifyCount},
+				{"truncate", c.TotalTruncateCount, c.SuccessTruncateCount},
+				{"set_meta", c.TotalSetMetaCount, c.SuccessSetMetaCount},
+				{"delete", c.TotalDeleteCount, c.SuccessDeleteCount},
+				{"download", c.TotalDownloadCount, c.SuccessDownloadCount},
+				{"get_meta", c.TotalGetMetaCount, c.SuccessGetMetaCount},
+				{"create_link", c.TotalCreateLinkCount, c.SuccessCreateLinkCount},
+				{"delete_link", c.TotalDeleteLinkCount, c.SuccessDeleteLinkCount},
+			} {
+				opLabels := labels + ",op=" + strconv.Quote(op.name)
+				ops.add(opLabels, float64(op.total))
+				opsSuccess.add(opLabels, float64(op.success))
+			}
+			for _, op := range []struct {
+				name           string
+				total, success int64
+			}{
+				{"upload", c.TotalUploadBytes, c.SuccessUploadBytes},
+				{"append", c.TotalAppendBytes, c.SuccessAppendBytes},
+				{"modify", c.TotalModifyBytes, c.SuccessModifyBytes},
+				{"download", c.TotalDownloadBytes, c.SuccessDownloadBytes},
+				{"sync_in", c.TotalSyncInBytes, c.SuccessSyncInBytes},
+				{"sync_out", c.TotalSyncOutBytes, c.SuccessSyncOutBytes},
+			} {
+				opLabels := labels + ",op=" + strconv.Quote(op.name)
+				bytesTotal.add(opLabels, float64(op.total))
+				bytesSuccess.add(opLabels, float64(op.success))
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, m := range metrics {
+		if len(m.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, sample := range m.samples {
+			b.WriteString(sample)
+			b.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatTime 未设置的时间与 fdfs_monitor 一样输出为 unix 纪元
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/lerryxiao/fdfs_client/dirsync"
+)
+
+func (cmd *command) sync(args []string) (func() error, error) {
+	if len(args) == 0 || (args[0] != "upload" && args[0] != "download") {
+		return nil, errUsage
+	}
+	upload := args[0] == "upload"
+	fs := cmd.flagSet("sync " + args[0])
+	manifestFile := fs.String("manifest", "", "manifest file")
+	concurrency := fs.Int("concurrency", dirsync.DefaultConcurrency, "number of concurrent transfers")
+	groupName := fs.String("group", "", "group name for upload")
+	if err := parse(fs, args[1:], 1, 1); err != nil {
+		return nil, err
+	}
+	if len(*manifestFile) == 0 {
+		return nil, errUsage
+	}
+
+	return func() error {
+		var (
+			mutex       sync.Mutex
+			transferred int
+			skipped     int
+		)
+		opts := dirsync.Options{
+			Concurrency: *concurrency,
+			GroupName:   *groupName,
+			OnFile: func(rel string, entry *dirsync.Entry, skip bool, err error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					return
+				}
+				if skip {
+					skipped++
+				} else {
+					transferred++
+				}
+				if !cmd.json {
+					action := "skipped"
+					if !skip {
+						action = args[0] + "ed"
+					}
+					fmt.Fprintf(cmd.stdout, "%s %s %s\n", action, rel, entry.FileID)
+				}
+			},
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		var err error
+		if upload {
+			err = cmd.syncUpload(ctx, *manifestFile, fs.Arg(0), opts)
+		} else {
+			var m *dirsync.Manifest
+			if m, err = dirsync.LoadManifest(*manifestFile); err != nil {
+				return err
+			}
+			err = dirsync.Download(ctx, cmd.client, m, fs.Arg(0), opts)
+		}
+		if err != nil {
+			return err
+		}
+		return cmd.print(map[string]interface{}{
+			"manifest":    *manifestFile,
+			"transferred": transferred,
+			"skipped":     skipped,
+		}, fmt.Sprintf("%d %sed, %d skipped", transferred, args[0], skipped))
+	}, nil
+}
+
+// syncUpload 清单文件已经存在时只上传有变化的文件
+// 上传失败时保存已经完成的部分和上次清单中还没有处理的文件, 再次执行可以继续上传
+func (cmd *command) syncUpload(ctx context.Context, manifestFile, dir string, opts dirsync.Options) error {
+	previous, err := dirsync.LoadManifest(manifestFile)
+	if err == nil {
+		opts.Previous = previous
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	m, err := dirsync.Upload(ctx, cmd.client, dir, opts)
+	if m == nil {
+		return err
+	}
+	if err != nil && previous != nil {
+		for rel, entry := range previous.Files {
+			if _, ok := m.Files[rel]; !ok {
+				m.Files[rel] = entry
+			}
+		}
+	}
+	if saveErr := m.Save(manifestFile); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
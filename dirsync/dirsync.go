@@ -0,0 +1,294 @@
+// Package dirsync 把本地目录树并发上传到 fastdfs, 用清单记录相对路径和文件ID, 并可以根据清单恢复目录树
+package dirsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+// ManifestVersion 清单格式版本
+const ManifestVersion = 1
+
+// DefaultConcurrency 默认并发数
+const DefaultConcurrency = 4
+
+// Entry 清单中的一个文件
+type Entry struct {
+	FileID string `json:"file_id"`
+	Size   int64  `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+}
+
+// Manifest 相对路径(以 / 分隔)到文件的映射
+type Manifest struct {
+	Version int               `json:"version"`
+	Updated time.Time         `json:"updated"`
+	Files   map[string]*Entry `json:"files"`
+}
+
+// NewManifest 新清单
+func NewManifest() *Manifest {
+	return &Manifest{Version: ManifestVersion, Files: make(map[string]*Entry)}
+}
+
+// LoadManifest 读取清单文件
+func LoadManifest(filename string) (*Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := NewManifest()
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", filename, err)
+	}
+	if m.Version != ManifestVersion {
+		return nil, fmt.Errorf("manifest %s: unsupported version %d", filename, m.Version)
+	}
+	for rel, entry := range m.Files {
+		if err = checkEntry(rel, entry); err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", filename, err)
+		}
+	}
+	return m, nil
+}
+
+// Save 保存清单, 先写临时文件再改名
+func (m *Manifest) Save(filename string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Paths 排序后的相对路径
+func (m *Manifest) Paths() []string {
+	paths := make([]string, 0, len(m.Files))
+	for rel := range m.Files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Options 上传下载选项
+type Options struct {
+	// Concurrency 并发数, 小于等于0时使用 DefaultConcurrency
+	Concurrency int
+	// GroupName 上传到的组, 为空时由 tracker 选择
+	GroupName string
+	// Previous 上次上传的清单, 大小和 CRC32 都相同的文件直接沿用原来的文件ID, 不再上传
+	Previous *Manifest
+	// OnFile 每个文件处理完成后回调, skipped 表示文件没有变化没有传输, 可能被并发调用
+	OnFile func(rel string, entry *Entry, skipped bool, err error)
+}
+
+func (opts *Options) concurrency() int {
+	if opts.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return opts.Concurrency
+}
+
+func (opts *Options) onFile(rel string, entry *Entry, skipped bool, err error) {
+	if opts.OnFile != nil {
+		opts.OnFile(rel, entry, skipped, err)
+	}
+}
+
+// Upload 并发上传 dir 下的所有普通文件, 返回的清单只包含上传成功的文件
+// 出错时停止上传并返回第一个错误, 此时已经上传的部分仍然在清单中, 可以保存后作为 Previous 继续上传
+func Upload(ctx context.Context, store client.FileStore, dir string, opts Options) (*Manifest, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewManifest()
+	var mutex sync.Mutex
+	err = forEach(ctx, paths, opts.concurrency(), func(rel string) error {
+		entry, skipped, err := uploadFile(store, filepath.Join(dir, filepath.FromSlash(rel)), rel, &opts)
+		opts.onFile(rel, entry, skipped, err)
+		if err != nil {
+			return fmt.Errorf("upload %s: %w", rel, err)
+		}
+		mutex.Lock()
+		m.Files[rel] = entry
+		mutex.Unlock()
+		return nil
+	})
+	m.Updated = time.Now()
+	return m, err
+}
+
+func uploadFile(store client.FileStore, filename, rel string, opts *Options) (*Entry, bool, error) {
+	size, crc, err := checksum(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	if opts.Previous != nil {
+		if prev, ok := opts.Previous.Files[rel]; ok && prev.Size == size && prev.CRC32 == crc {
+			return prev, true, nil
+		}
+	}
+	var resp *client.UploadFileResponse
+	if size == 0 {
+		// 空文件不能按文件名上传
+		resp, err = store.UploadByBuffer(nil, strings.TrimPrefix(path.Ext(rel), "."), opts.GroupName)
+	} else {
+		resp, err = store.UploadByFilename(filename, opts.GroupName)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &Entry{FileID: resp.RemoteFileID, Size: size, CRC32: crc}, false, nil
+}
+
+// Download 根据清单并发下载到 dir, 本地已有大小和 CRC32 都相同的文件时跳过
+// 下载的文件先写到临时文件, 校验 CRC32 后再改名
+func Download(ctx context.Context, store client.FileStore, m *Manifest, dir string, opts Options) error {
+	for rel, entry := range m.Files {
+		if err := checkEntry(rel, entry); err != nil {
+			return err
+		}
+	}
+	return forEach(ctx, m.Paths(), opts.concurrency(), func(rel string) error {
+		entry := m.Files[rel]
+		skipped, err := downloadFile(store, entry, filepath.Join(dir, filepath.FromSlash(rel)))
+		opts.onFile(rel, entry, skipped, err)
+		if err != nil {
+			return fmt.Errorf("download %s: %w", rel, err)
+		}
+		return nil
+	})
+}
+
+func downloadFile(store client.FileStore, entry *Entry, filename string) (bool, error) {
+	if size, crc, err := checksum(filename); err == nil && size == entry.Size && crc == entry.CRC32 {
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return false, err
+	}
+	tmp := filename + ".fdfs-tmp"
+	if _, err := store.DownloadToFile(tmp, entry.FileID, 0, 0); err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	size, crc, err := checksum(tmp)
+	if err == nil && (size != entry.Size || crc != entry.CRC32) {
+		err = fmt.Errorf("checksum mismatch, size %d crc32 %d, expect size %d crc32 %d", size, crc, entry.Size, entry.CRC32)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	return false, os.Rename(tmp, filename)
+}
+
+// forEach 用 concurrency 个 goroutine 处理 items, 第一个错误或 ctx 取消后不再开始新的任务
+func forEach(ctx context.Context, items []string, concurrency int, fn func(string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	ch := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range ch {
+				if err := fn(item); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for _, item := range items {
+		select {
+		case ch <- item:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(ch)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// checksum 文件大小和 IEEE CRC32, 与 fastdfs 记录的 CRC32 相同
+func checksum(filename string) (int64, uint32, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	h := crc32.NewIEEE()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, h.Sum32(), nil
+}
+
+// checkEntry 检查清单中的一项, 手工修改或者写了一半的清单可能有空的项
+func checkEntry(rel string, entry *Entry) error {
+	if err := checkRelPath(rel); err != nil {
+		return err
+	}
+	if entry == nil || len(entry.FileID) == 0 {
+		return fmt.Errorf("%w: no file id for [%s]", client.ErrInvalidArgument, rel)
+	}
+	return nil
+}
+
+// checkRelPath 拒绝绝对路径和越过目标目录的路径
+func checkRelPath(rel string) error {
+	if len(rel) == 0 || path.IsAbs(rel) || path.Clean(rel) != rel || rel == ".." || strings.HasPrefix(rel, "../") ||
+		strings.Contains(rel, "\\") {
+		return fmt.Errorf("%w: invalid path [%s]", client.ErrInvalidArgument, rel)
+	}
+	return nil
+}
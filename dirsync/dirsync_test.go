@@ -0,0 +1,171 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lerryxiao/fdfs_client/client"
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+	"github.com/lerryxiao/fdfs_client/localfs"
+)
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for rel, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newFdfsStore(t *testing.T) client.FileStore {
+	server, err := fdfstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	cfg, err := client.LoadClientConfig("", server.ConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdfsClient, err := client.NewFdfsClientByConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fdfsClient
+}
+
+func TestUploadDownload(t *testing.T) {
+	t.Run("localfs", func(t *testing.T) {
+		store, err := localfs.New(t.TempDir(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testUploadDownload(t, store)
+	})
+	t.Run("fdfstest", func(t *testing.T) {
+		testUploadDownload(t, newFdfsStore(t))
+	})
+}
+
+func testUploadDownload(t *testing.T, store client.FileStore) {
+	src := t.TempDir()
+	files := map[string]string{
+		"index.html":        "<html></html>",
+		"css/site.css":      "body {}",
+		"img/a/b/logo.png":  "png",
+		"img/a/b/empty.txt": "",
+	}
+	writeTree(t, src, files)
+
+	m, err := Upload(context.Background(), store, src, Options{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != len(files) {
+		t.Fatalf("unexpected manifest %v", m.Paths())
+	}
+	manifestFile := filepath.Join(t.TempDir(), "manifest.json")
+	if err = m.Save(manifestFile); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = LoadManifest(manifestFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只有修改过的文件重新上传
+	writeTree(t, src, map[string]string{"css/site.css": "body { margin: 0 }"})
+	var uploaded int32
+	m2, err := Upload(context.Background(), store, src, Options{Previous: m, OnFile: func(rel string, entry *Entry, skipped bool, err error) {
+		if !skipped {
+			atomic.AddInt32(&uploaded, 1)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded != 1 || m2.Files["index.html"].FileID != m.Files["index.html"].FileID ||
+		m2.Files["css/site.css"].FileID == m.Files["css/site.css"].FileID {
+		t.Errorf("unexpected incremental upload, %d uploaded", uploaded)
+	}
+
+	dst := t.TempDir()
+	writeTree(t, dst, map[string]string{"index.html": "<html></html>", "css/site.css": "stale"})
+	var downloaded int32
+	err = Download(context.Background(), store, m2, dst, Options{OnFile: func(rel string, entry *Entry, skipped bool, err error) {
+		if !skipped {
+			atomic.AddInt32(&downloaded, 1)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloaded != 3 {
+		t.Errorf("expect 3 downloaded, actual %d", downloaded)
+	}
+	files["css/site.css"] = "body { margin: 0 }"
+	for rel, content := range files {
+		data, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(rel)))
+		if err != nil || string(data) != content {
+			t.Errorf("%s: unexpected content %q %v", rel, data, err)
+		}
+	}
+}
+
+func TestDownloadErrors(t *testing.T) {
+	store, err := localfs.New(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := store.UploadByBuffer([]byte("content"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest()
+	m.Files["../escape.txt"] = &Entry{FileID: resp.RemoteFileID, Size: 7}
+	if err = Download(context.Background(), store, m, t.TempDir(), Options{}); !errors.Is(err, client.ErrInvalidArgument) {
+		t.Errorf("expect ErrInvalidArgument: %v", err)
+	}
+
+	m = NewManifest()
+	m.Files["a.txt"] = &Entry{FileID: resp.RemoteFileID, Size: 7, CRC32: 1}
+	dst := t.TempDir()
+	if err = Download(context.Background(), store, m, dst, Options{}); err == nil {
+		t.Error("expect checksum mismatch")
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+		t.Errorf("unexpected files left %v", entries)
+	}
+
+	m = NewManifest()
+	m.Files["a.txt"] = nil
+	if err = Download(context.Background(), store, m, t.TempDir(), Options{}); !errors.Is(err, client.ErrInvalidArgument) {
+		t.Errorf("expect ErrInvalidArgument for nil entry: %v", err)
+	}
+}
+
+func TestLoadManifestInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"version": 1, "files": {"a.txt": null}}`,
+		`{"version": 1, "files": {"a.txt": {"file_id": "", "size": 1}}}`,
+		`{"version": 1, "files": {"../a.txt": {"file_id": "group1/M00/00/00/a.txt"}}}`,
+	} {
+		filename := filepath.Join(t.TempDir(), "manifest.json")
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadManifest(filename); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Errorf("%s: expect ErrInvalidArgument, actual %v", data, err)
+		}
+	}
+}
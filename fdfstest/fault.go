@@ -0,0 +1,116 @@
+package fdfstest
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Fault 按命令注入的故障, 各项可以组合
+type Fault struct {
+	// Delay 处理请求前的延迟
+	Delay time.Duration
+	// Status 不为0时不处理请求, 直接返回该状态码
+	Status byte
+	// ResetMidBody 为 true 时读到一半请求体后重置连接, 不返回响应
+	ResetMidBody bool
+	// PkgLenDelta 不为0时响应头中的 pkgLen 加上该值, 写完响应后关闭连接
+	PkgLenDelta int64
+	// PartialBody 大于0时只写入前 PartialBody 字节响应体, 然后关闭连接
+	PartialBody int
+	// Body 不为 nil 时替换响应体, 可以与 Status 组合成带响应体的错误响应
+	Body []byte
+	// KeepAlive 为 true 时 PkgLenDelta 和 PartialBody 写完响应后不关闭连接, 客户端需要自己丢弃不同步的连接
+	KeepAlive bool
+	// Times 生效次数, 0 表示一直生效
+	Times int
+}
+
+type faultState struct {
+	fault Fault
+	hits  int
+}
+
+// InjectFault 为命令 cmd 注入故障, 替换之前注入的故障
+// cmd 为协议命令, 例如 11(upload), 14(download), 102(query fetch); 111(active test) 同时作用于 tracker 和 storage
+func (s *Server) InjectFault(cmd byte, fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.faults == nil {
+		s.faults = make(map[byte]*faultState)
+	}
+	s.faults[cmd] = &faultState{fault: fault}
+}
+
+// ClearFaults 清除所有故障
+func (s *Server) ClearFaults() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = nil
+}
+
+// FaultHits 命令 cmd 的故障已经生效的次数
+func (s *Server) FaultHits(cmd byte) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if state, ok := s.faults[cmd]; ok {
+		return state.hits
+	}
+	return 0
+}
+
+// takeFault 取出命令 cmd 本次要注入的故障, 次数用完后删除
+func (s *Server) takeFault(cmd byte) *Fault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.faults[cmd]
+	if !ok || (state.fault.Times > 0 && state.hits >= state.fault.Times) {
+		return nil
+	}
+	state.hits++
+	fault := state.fault
+	return &fault
+}
+
+// readBody 读取请求体, 需要时在读到一半后重置连接
+func readBody(conn net.Conn, pkgLen int64, fault *Fault) ([]byte, bool) {
+	body := make([]byte, pkgLen)
+	if fault != nil && fault.ResetMidBody {
+		_, _ = io.ReadFull(conn, body[:pkgLen/2])
+		resetConn(conn)
+		return nil, false
+	}
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+// writeFaultResponse 按故障写入错误的 pkgLen 或部分响应, 返回 false 表示需要关闭连接
+func writeFaultResponse(conn net.Conn, status byte, resp []byte, fault *Fault) bool {
+	if fault.Body != nil {
+		resp = fault.Body
+	}
+	header := make([]byte, headerLen)
+	putPkgLen(header, int64(len(resp))+fault.PkgLenDelta)
+	header[8] = cmdResp
+	header[9] = status
+
+	closeConn := fault.PkgLenDelta != 0
+	if fault.PartialBody > 0 && fault.PartialBody < len(resp) {
+		resp = resp[:fault.PartialBody]
+		closeConn = true
+	}
+	if _, err := conn.Write(append(header, resp...)); err != nil {
+		return false
+	}
+	return !closeConn || fault.KeepAlive
+}
+
+// resetConn 关闭连接并发送 RST
+func resetConn(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,100 @@
+package fdfstest
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+const (
+	storageIDLen   = 16
+	domainNameLen  = 128
+	versionLen     = 6
+	storageActive  = 7
+	storageTotalMB = 1024
+	storageVersion = "4.06"
+	storeSubdirs   = 256
+)
+
+// #group_stat: |-group_name(17)-total_mb(8)-free_mb(8)-trunk_free_mb(8)-count(8)-storage_port(8)-storage_http_port(8)
+// -active_count(8)-current_write_server(8)-store_path_count(8)-subdir_count_per_path(8)-current_trunk_file_id(8)-|
+func (s *Server) groupStat() []byte {
+	resp := fixed(s.GroupName, groupNameLen+1)
+	for _, v := range []int64{storageTotalMB, s.freeMB(), 0, 1, int64(s.storagePort()), 0, 1, 0, 1, storeSubdirs, 0} {
+		resp = appendInt64(resp, v)
+	}
+	return resp
+}
+
+// #storage_stat: |-status(1)-id(16)-ip_addr(16)-domain_name(128)-src_id(16)-version(6)-join_time(8)-up_time(8)
+// -total_mb(8)-free_mb(8)-upload_priority(8)-store_path_count(8)-subdir_count_per_path(8)-current_write_path(8)
+// -storage_port(8)-storage_http_port(8)-counters(38*8)-last_source_update(8)-last_sync_update(8)
+// -last_synced_timestamp(8)-last_heart_beat_time(8)-if_trunk_server(1)-|
+func (s *Server) storageStat() []byte {
+	ip := s.storage.Addr().(*net.TCPAddr).IP.String()
+	resp := []byte{storageActive}
+	resp = append(resp, fixed(ip, storageIDLen)...)
+	resp = append(resp, fixed(ip, ipAddrLen)...)
+	resp = append(resp, fixed("", domainNameLen)...)
+	resp = append(resp, fixed("", storageIDLen)...)
+	resp = append(resp, fixed(storageVersion, versionLen)...)
+
+	started := s.started.Unix()
+	for _, v := range []int64{started, started, storageTotalMB, s.freeMB(), 0, 1, storeSubdirs, 0, int64(s.storagePort()), 0} {
+		resp = appendInt64(resp, v)
+	}
+	for _, v := range s.counters() {
+		resp = appendInt64(resp, v)
+	}
+	for _, v := range []int64{0, 0, 0, time.Now().Unix()} {
+		resp = appendInt64(resp, v)
+	}
+	return append(resp, 0)
+}
+
+// counters 按 FDFSStorageStatBuff 的顺序返回38个计数, 只统计请求次数, 字节数都为0
+func (s *Server) counters() []int64 {
+	s.files.mutex.Lock()
+	defer s.files.mutex.Unlock()
+
+	counters := make([]int64, 38)
+	for i, cmds := range [][]byte{
+		{cmdUploadFile, cmdUploadAppender, cmdUploadSlave},
+		{cmdAppendFile},
+		{cmdModifyFile},
+		{cmdTruncateFile},
+		{cmdSetMetadata},
+		{cmdDeleteFile},
+		{cmdDownloadFile},
+		{cmdGetMetadata},
+		{cmdCreateLink},
+	} {
+		for _, cmd := range cmds {
+			if count, ok := s.files.counts[cmd]; ok {
+				counters[2*i] += count[0]
+				counters[2*i+1] += count[1]
+			}
+		}
+	}
+	return counters
+}
+
+func (s *Server) freeMB() int64 {
+	s.files.mutex.Lock()
+	defer s.files.mutex.Unlock()
+	var used int64
+	for _, f := range s.files.files {
+		used += int64(len(f.data))
+	}
+	return storageTotalMB - (used+1<<20-1)>>20
+}
+
+func (s *Server) storagePort() int {
+	return s.storage.Addr().(*net.TCPAddr).Port
+}
+
+func appendInt64(buf []byte, v int64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	return append(buf, b[:]...)
+}
@@ -0,0 +1,333 @@
+// Package fdfstest 提供进程内的 tracker 和 storage, 在本机回环地址上实现 fastdfs 协议, 用于离线测试
+package fdfstest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultGroupName 默认组名
+const DefaultGroupName = "group1"
+
+const (
+	cmdResp       = 100
+	cmdQuit       = 82
+	cmdActiveTest = 111
+
+	headerLen    = 10
+	groupNameLen = 16
+	ipAddrLen    = 16
+	prefixLen    = 16
+	extNameLen   = 6
+
+	// linux errno, 与 fastdfs 服务端返回的状态码一致
+	errnoNotFound = 2
+	errnoExist    = 17
+	errnoInvalid  = 22
+)
+
+// Server 进程内的 tracker 和 storage, 文件保存在内存中
+type Server struct {
+	// GroupName 组名, 只能在 NewServerWithGroup 时设置
+	GroupName string
+
+	tracker net.Listener
+	storage net.Listener
+	files   *fileStore
+	conns   map[net.Conn]struct{}
+	faults  map[byte]*faultState
+	started time.Time
+	closed  bool
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// NewServer 在 127.0.0.1 的随机端口上启动 tracker 和 storage
+func NewServer() (*Server, error) {
+	return NewServerWithGroup(DefaultGroupName)
+}
+
+// NewServerWithGroup 指定组名启动
+func NewServerWithGroup(groupName string) (*Server, error) {
+	if len(groupName) == 0 || len(groupName) > groupNameLen {
+		return nil, fmt.Errorf("invalid group name [%s]", groupName)
+	}
+	tracker, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	storage, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		_ = tracker.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		GroupName: groupName,
+		tracker:   tracker,
+		storage:   storage,
+		files:     newFileStore(),
+		conns:     make(map[net.Conn]struct{}),
+		started:   time.Now(),
+	}
+	s.wg.Add(2)
+	go s.serve(tracker, s.handleTracker)
+	go s.serve(storage, s.handleStorage)
+	return s, nil
+}
+
+// TrackerAddr tracker 地址 ip:port
+func (s *Server) TrackerAddr() string {
+	return s.tracker.Addr().String()
+}
+
+// StorageAddr storage 地址 ip:port
+func (s *Server) StorageAddr() string {
+	return s.storage.Addr().String()
+}
+
+// StoragePort storage 端口
+func (s *Server) StoragePort() int {
+	return s.storagePort()
+}
+
+// TrackerHost tracker ip
+func (s *Server) TrackerHost() string {
+	return s.tracker.Addr().(*net.TCPAddr).IP.String()
+}
+
+// TrackerPort tracker 端口
+func (s *Server) TrackerPort() int {
+	return s.tracker.Addr().(*net.TCPAddr).Port
+}
+
+// ConfigData 指向本服务器的 client.conf 内容
+func (s *Server) ConfigData() string {
+	return "connect_timeout=5\nnetwork_timeout=5\ntracker_server=" + s.TrackerAddr() + "\n"
+}
+
+// Close 关闭监听和所有连接
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	_ = s.tracker.Close()
+	_ = s.storage.Close()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// Files 保存的所有文件ID
+func (s *Server) Files() []string {
+	return s.files.list(s.GroupName)
+}
+
+// File 文件内容
+func (s *Server) File(fileID string) ([]byte, bool) {
+	s.files.mutex.Lock()
+	defer s.files.mutex.Unlock()
+	f, ok := s.files.files[s.filename(fileID)]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), f.data...), true
+}
+
+// Metadata 文件元数据
+func (s *Server) Metadata(fileID string) (map[string]string, bool) {
+	s.files.mutex.Lock()
+	defer s.files.mutex.Unlock()
+	f, ok := s.files.files[s.filename(fileID)]
+	if !ok {
+		return nil, false
+	}
+	meta := make(map[string]string, len(f.meta))
+	for name, value := range f.meta {
+		meta[name] = value
+	}
+	return meta, true
+}
+
+func (s *Server) filename(fileID string) string {
+	prefix := s.GroupName + "/"
+	if len(fileID) > len(prefix) && fileID[:len(prefix)] == prefix {
+		return fileID[len(prefix):]
+	}
+	return fileID
+}
+
+type handler func(cmd byte, body []byte) (status byte, resp []byte)
+
+func (s *Server) serve(l net.Listener, handle handler) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn, handle)
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn, handle handler) {
+	header := make([]byte, headerLen)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pkgLen := int64(binary.BigEndian.Uint64(header[0:8]))
+		cmd := header[8]
+		if pkgLen < 0 {
+			return
+		}
+		fault := s.takeFault(cmd)
+		body, ok := readBody(conn, pkgLen, fault)
+		if !ok {
+			return
+		}
+		if fault != nil && fault.Delay > 0 {
+			time.Sleep(fault.Delay)
+		}
+
+		var (
+			status byte
+			resp   []byte
+		)
+		switch {
+		case cmd == cmdQuit:
+			return
+		case fault != nil && fault.Status != 0:
+			status = fault.Status
+		case cmd == cmdActiveTest:
+		default:
+			status, resp = handle(cmd, body)
+		}
+
+		if fault != nil {
+			if !writeFaultResponse(conn, status, resp, fault) {
+				return
+			}
+		} else if err := writeResponse(conn, status, resp); err != nil {
+			return
+		}
+	}
+}
+
+func writeResponse(conn net.Conn, status byte, resp []byte) error {
+	header := make([]byte, headerLen)
+	putPkgLen(header, int64(len(resp)))
+	header[8] = cmdResp
+	header[9] = status
+	if _, err := conn.Write(append(header, resp...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func putPkgLen(header []byte, pkgLen int64) {
+	binary.BigEndian.PutUint64(header[0:8], uint64(pkgLen))
+}
+
+// handleTracker 只支持 store/fetch/update 查询和 list groups/storage, 所有查询都返回唯一的 storage
+func (s *Server) handleTracker(cmd byte, body []byte) (byte, []byte) {
+	const (
+		queryStoreWithoutGroup = 101
+		queryFetch             = 102
+		queryUpdate            = 103
+		queryStoreWithGroup    = 104
+		listAllGroups          = 91
+		listStorage            = 92
+	)
+	switch cmd {
+	case listAllGroups:
+		return 0, s.groupStat()
+	case listStorage:
+		if len(body) < groupNameLen {
+			return errnoInvalid, nil
+		}
+		if cstr(body[:groupNameLen]) != s.GroupName {
+			return errnoNotFound, nil
+		}
+		return 0, s.storageStat()
+	case queryStoreWithoutGroup:
+		return 0, s.storageInfo(true)
+	case queryStoreWithGroup:
+		if len(body) != groupNameLen {
+			return errnoInvalid, nil
+		}
+		if cstr(body) != s.GroupName {
+			return errnoNotFound, nil
+		}
+		return 0, s.storageInfo(true)
+	case queryFetch, queryUpdate:
+		if len(body) <= groupNameLen {
+			return errnoInvalid, nil
+		}
+		if cstr(body[:groupNameLen]) != s.GroupName {
+			return errnoNotFound, nil
+		}
+		return 0, s.storageInfo(false)
+	}
+	return errnoInvalid, nil
+}
+
+// #recv_fmt |-group_name(16)-ipaddr(16-1)-port(8)-store_path_index(1)|
+func (s *Server) storageInfo(withPathIndex bool) []byte {
+	addr := s.storage.Addr().(*net.TCPAddr)
+	resp := make([]byte, groupNameLen+ipAddrLen-1+8)
+	copy(resp, s.GroupName)
+	copy(resp[groupNameLen:], addr.IP.String())
+	binary.BigEndian.PutUint64(resp[groupNameLen+ipAddrLen-1:], uint64(addr.Port))
+	if withPathIndex {
+		resp = append(resp, 0)
+	}
+	return resp
+}
+
+func cstr(data []byte) string {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
+
+func fixed(s string, length int) []byte {
+	buf := make([]byte, length)
+	copy(buf, s)
+	return buf
+}
+
+// readInt64 读取第 index 个 8 字节整数, 调用方需要先检查长度
+func readInt64(body []byte, index int) int64 {
+	return int64(binary.BigEndian.Uint64(body[index*8:]))
+}
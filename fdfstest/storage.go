@@ -0,0 +1,421 @@
+package fdfstest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cmdUploadFile     = 11
+	cmdDeleteFile     = 12
+	cmdSetMetadata    = 13
+	cmdDownloadFile   = 14
+	cmdGetMetadata    = 15
+	cmdCreateLink     = 20
+	cmdUploadSlave    = 21
+	cmdQueryFileInfo  = 22
+	cmdUploadAppender = 23
+	cmdAppendFile     = 24
+	cmdModifyFile     = 34
+	cmdTruncateFile   = 36
+
+	metaFlagOverwrite = 'O'
+	metaFlagMerge     = 'M'
+	recordSeperator   = "\x01"
+	fieldSeperator    = "\x02"
+)
+
+type file struct {
+	data     []byte
+	meta     map[string]string
+	appender bool
+	created  time.Time
+}
+
+type fileStore struct {
+	files map[string]*file
+	// counts 各命令的请求次数和成功次数, 用于 list storage 的计数
+	counts map[byte]*[2]int64
+	mutex  sync.Mutex
+}
+
+func newFileStore() *fileStore {
+	return &fileStore{files: make(map[string]*file), counts: make(map[byte]*[2]int64)}
+}
+
+func (fs *fileStore) list(groupName string) []string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fileIDs := make([]string, 0, len(fs.files))
+	for filename := range fs.files {
+		fileIDs = append(fileIDs, groupName+"/"+filename)
+	}
+	sort.Strings(fileIDs)
+	return fileIDs
+}
+
+// handleStorage 处理 storage 命令, 锁住整个文件表, 测试场景下足够
+func (s *Server) handleStorage(cmd byte, body []byte) (byte, []byte) {
+	s.files.mutex.Lock()
+	defer s.files.mutex.Unlock()
+
+	var (
+		errno byte
+		resp  []byte
+	)
+	switch cmd {
+	case cmdUploadFile, cmdUploadAppender:
+		resp, errno = s.upload(body, cmd == cmdUploadAppender)
+	case cmdUploadSlave:
+		resp, errno = s.uploadSlave(body)
+	case cmdCreateLink:
+		resp, errno = s.createLink(body)
+	case cmdDeleteFile:
+		errno = s.deleteFile(body)
+	case cmdDownloadFile:
+		resp, errno = s.download(body)
+	case cmdSetMetadata:
+		errno = s.setMetadata(body)
+	case cmdGetMetadata:
+		resp, errno = s.getMetadata(body)
+	case cmdQueryFileInfo:
+		resp, errno = s.queryFileInfo(body)
+	case cmdAppendFile, cmdModifyFile, cmdTruncateFile:
+		errno = s.modify(cmd, body)
+	default:
+		errno = errnoInvalid
+	}
+
+	count, ok := s.files.counts[cmd]
+	if !ok {
+		count = new([2]int64)
+		s.files.counts[cmd] = count
+	}
+	count[0]++
+	if errno == 0 {
+		count[1]++
+	}
+	return errno, resp
+}
+
+// #upload_fmt |-store_path_index(1)-file_size(8)-file_ext_name(6)-file_content(file_size)-|
+func (s *Server) upload(body []byte, appender bool) ([]byte, byte) {
+	const fixedLen = 1 + 8 + extNameLen
+	if len(body) < fixedLen {
+		return nil, errnoInvalid
+	}
+	size := int64(binary.BigEndian.Uint64(body[1:9]))
+	if size != int64(len(body)-fixedLen) {
+		return nil, errnoInvalid
+	}
+	filename := s.newFilename(cstr(body[9:fixedLen]))
+	s.files.files[filename] = newFile(body[fixedLen:], appender)
+	return s.uploadResponse(filename), 0
+}
+
+// #slave_fmt |-master_len(8)-file_size(8)-prefix_name(16)-file_ext_name(6)-master_name(master_len)-file_content(file_size)-|
+func (s *Server) uploadSlave(body []byte) ([]byte, byte) {
+	const fixedLen = 8 + 8 + prefixLen + extNameLen
+	if len(body) < fixedLen {
+		return nil, errnoInvalid
+	}
+	masterLen := readInt64(body, 0)
+	size := readInt64(body, 1)
+	if masterLen <= 0 || int64(len(body)) != fixedLen+masterLen+size {
+		return nil, errnoInvalid
+	}
+	prefixName := cstr(body[16 : 16+prefixLen])
+	extName := cstr(body[16+prefixLen : fixedLen])
+	masterFilename := string(body[fixedLen : fixedLen+masterLen])
+	if len(prefixName) == 0 {
+		return nil, errnoInvalid
+	}
+	if _, ok := s.files.files[masterFilename]; !ok {
+		return nil, errnoNotFound
+	}
+
+	filename := slaveFilename(masterFilename, prefixName, extName)
+	if _, ok := s.files.files[filename]; ok {
+		return nil, errnoExist
+	}
+	s.files.files[filename] = newFile(body[fixedLen+masterLen:], false)
+	return s.uploadResponse(filename), 0
+}
+
+// #link_fmt |-master_len(8)-src_len(8)-src_sig_len(8)-group_name(16)-prefix_name(16)-file_ext_name(6)
+// #          -master_filename(master_len)-src_filename(src_len)-src_sig(src_sig_len)-|
+func (s *Server) createLink(body []byte) ([]byte, byte) {
+	const fixedLen = 3*8 + groupNameLen + prefixLen + extNameLen
+	if len(body) < fixedLen {
+		return nil, errnoInvalid
+	}
+	masterLen := readInt64(body, 0)
+	srcLen := readInt64(body, 1)
+	sigLen := readInt64(body, 2)
+	if masterLen < 0 || srcLen <= 0 || sigLen < 0 || int64(len(body)) != fixedLen+masterLen+srcLen+sigLen {
+		return nil, errnoInvalid
+	}
+	if cstr(body[24:24+groupNameLen]) != s.GroupName {
+		return nil, errnoInvalid
+	}
+	prefixName := cstr(body[24+groupNameLen : 24+groupNameLen+prefixLen])
+	extName := cstr(body[24+groupNameLen+prefixLen : fixedLen])
+	masterFilename := string(body[fixedLen : fixedLen+masterLen])
+	srcFilename := string(body[fixedLen+masterLen : fixedLen+masterLen+srcLen])
+
+	src, ok := s.files.files[srcFilename]
+	if !ok {
+		return nil, errnoNotFound
+	}
+	var filename string
+	if masterLen > 0 {
+		if _, ok := s.files.files[masterFilename]; !ok {
+			return nil, errnoNotFound
+		}
+		filename = slaveFilename(masterFilename, prefixName, extName)
+		if _, ok := s.files.files[filename]; ok {
+			return nil, errnoExist
+		}
+	} else {
+		filename = s.newFilename(extName)
+	}
+	s.files.files[filename] = newFile(src.data, false)
+	return s.uploadResponse(filename), 0
+}
+
+// #del_fmt |-group_name(16)-filename(len)-|
+func (s *Server) deleteFile(body []byte) byte {
+	filename, errno := s.parseFilename(body)
+	if errno != 0 {
+		return errno
+	}
+	if _, ok := s.files.files[filename]; !ok {
+		return errnoNotFound
+	}
+	delete(s.files.files, filename)
+	return 0
+}
+
+// #down_fmt |-offset(8)-download_bytes(8)-group_name(16)-remote_filename(len)-|
+func (s *Server) download(body []byte) ([]byte, byte) {
+	if len(body) < 16 {
+		return nil, errnoInvalid
+	}
+	offset := readInt64(body, 0)
+	size := readInt64(body, 1)
+	filename, errno := s.parseFilename(body[16:])
+	if errno != 0 {
+		return nil, errno
+	}
+	f, ok := s.files.files[filename]
+	if !ok {
+		return nil, errnoNotFound
+	}
+	if offset < 0 || offset > int64(len(f.data)) || size < 0 {
+		return nil, errnoInvalid
+	}
+	end := int64(len(f.data))
+	if size > 0 && offset+size < end {
+		end = offset + size
+	}
+	return append([]byte(nil), f.data[offset:end]...), 0
+}
+
+// #set_meta_fmt |-filename_len(8)-meta_len(8)-op_flag(1)-group_name(16)-filename(len)-meta(meta_len)-|
+func (s *Server) setMetadata(body []byte) byte {
+	const fixedLen = 8 + 8 + 1 + groupNameLen
+	if len(body) < fixedLen {
+		return errnoInvalid
+	}
+	filenameLen := readInt64(body, 0)
+	metaLen := readInt64(body, 1)
+	if filenameLen <= 0 || metaLen < 0 || int64(len(body)) != fixedLen+filenameLen+metaLen {
+		return errnoInvalid
+	}
+	opFlag := body[16]
+	if cstr(body[17:fixedLen]) != s.GroupName {
+		return errnoInvalid
+	}
+	f, ok := s.files.files[string(body[fixedLen:fixedLen+filenameLen])]
+	if !ok {
+		return errnoNotFound
+	}
+
+	meta := unpackMetadata(body[fixedLen+filenameLen:])
+	switch opFlag {
+	case metaFlagOverwrite:
+		f.meta = meta
+	case metaFlagMerge:
+		for name, value := range meta {
+			f.meta[name] = value
+		}
+	default:
+		return errnoInvalid
+	}
+	return 0
+}
+
+func (s *Server) getMetadata(body []byte) ([]byte, byte) {
+	filename, errno := s.parseFilename(body)
+	if errno != 0 {
+		return nil, errno
+	}
+	f, ok := s.files.files[filename]
+	if !ok {
+		return nil, errnoNotFound
+	}
+	return packMetadata(f.meta), 0
+}
+
+// #resp_fmt |-file_size(8)-create_timestamp(8)-crc32(8)-source_ip_addr(16)-|
+func (s *Server) queryFileInfo(body []byte) ([]byte, byte) {
+	filename, errno := s.parseFilename(body)
+	if errno != 0 {
+		return nil, errno
+	}
+	f, ok := s.files.files[filename]
+	if !ok {
+		return nil, errnoNotFound
+	}
+	resp := make([]byte, 3*8, 3*8+ipAddrLen)
+	binary.BigEndian.PutUint64(resp[0:8], uint64(len(f.data)))
+	binary.BigEndian.PutUint64(resp[8:16], uint64(f.created.Unix()))
+	binary.BigEndian.PutUint64(resp[16:24], uint64(crc32.ChecksumIEEE(f.data)))
+	return append(resp, fixed(s.storage.Addr().(*net.TCPAddr).IP.String(), ipAddrLen)...), 0
+}
+
+// #append_fmt   |-filename_len(8)-file_size(8)-filename(len)-file_content(file_size)-|
+// #modify_fmt   |-filename_len(8)-file_offset(8)-file_size(8)-filename(len)-file_content(file_size)-|
+// #truncate_fmt |-filename_len(8)-truncated_file_size(8)-filename(len)-|
+func (s *Server) modify(cmd byte, body []byte) byte {
+	fields := 2
+	if cmd == cmdModifyFile {
+		fields = 3
+	}
+	if len(body) < fields*8 {
+		return errnoInvalid
+	}
+	filenameLen := readInt64(body, 0)
+	if filenameLen <= 0 || int64(len(body)) < int64(fields*8)+filenameLen {
+		return errnoInvalid
+	}
+	filename := string(body[fields*8 : int64(fields*8)+filenameLen])
+	content := body[int64(fields*8)+filenameLen:]
+	f, ok := s.files.files[filename]
+	if !ok {
+		return errnoNotFound
+	}
+	if !f.appender {
+		return errnoInvalid
+	}
+
+	switch cmd {
+	case cmdAppendFile:
+		if size := readInt64(body, 1); size != int64(len(content)) {
+			return errnoInvalid
+		}
+		f.data = append(f.data, content...)
+	case cmdModifyFile:
+		offset := readInt64(body, 1)
+		size := readInt64(body, 2)
+		if size != int64(len(content)) || offset < 0 || offset > int64(len(f.data)) {
+			return errnoInvalid
+		}
+		if end := offset + size; end > int64(len(f.data)) {
+			f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+		}
+		copy(f.data[offset:], content)
+	case cmdTruncateFile:
+		size := readInt64(body, 1)
+		if len(content) != 0 || size < 0 || size > int64(len(f.data)) {
+			return errnoInvalid
+		}
+		f.data = f.data[:size]
+	}
+	return 0
+}
+
+// parseFilename |-group_name(16)-filename(len)-|
+func (s *Server) parseFilename(body []byte) (string, byte) {
+	if len(body) <= groupNameLen {
+		return "", errnoInvalid
+	}
+	if cstr(body[:groupNameLen]) != s.GroupName {
+		return "", errnoInvalid
+	}
+	return string(body[groupNameLen:]), 0
+}
+
+// #resp_fmt |-group_name(16)-filename(len)-|
+func (s *Server) uploadResponse(filename string) []byte {
+	return append(fixed(s.GroupName, groupNameLen), filename...)
+}
+
+func (s *Server) newFilename(extName string) string {
+	for {
+		buf := make([]byte, 20)
+		_, _ = rand.Read(buf)
+		filename := "M00/00/00/" + base64.RawURLEncoding.EncodeToString(buf)
+		if len(extName) > 0 {
+			filename += "." + extName
+		}
+		if _, ok := s.files.files[filename]; !ok {
+			return filename
+		}
+	}
+}
+
+func newFile(data []byte, appender bool) *file {
+	return &file{
+		data:     append([]byte(nil), data...),
+		meta:     make(map[string]string),
+		appender: appender,
+		created:  time.Now(),
+	}
+}
+
+// slaveFilename 主文件名去掉扩展名 + 前缀名 + 扩展名
+func slaveFilename(masterFilename, prefixName, extName string) string {
+	filename := strings.TrimSuffix(masterFilename, path.Ext(masterFilename)) + prefixName
+	if len(extName) > 0 {
+		filename += "." + extName
+	}
+	return filename
+}
+
+func packMetadata(meta map[string]string) []byte {
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	records := make([]string, 0, len(names))
+	for _, name := range names {
+		records = append(records, name+fieldSeperator+meta[name])
+	}
+	return []byte(strings.Join(records, recordSeperator))
+}
+
+func unpackMetadata(data []byte) map[string]string {
+	meta := make(map[string]string)
+	if len(data) == 0 {
+		return meta
+	}
+	for _, record := range strings.Split(string(data), recordSeperator) {
+		fields := strings.SplitN(record, fieldSeperator, 2)
+		if len(fields) == 2 {
+			meta[fields[0]] = fields[1]
+		} else if len(fields[0]) > 0 {
+			meta[fields[0]] = ""
+		}
+	}
+	return meta
+}
@@ -0,0 +1,130 @@
+// Package gateway 提供替代 nginx fastdfs 模块的 HTTP 网关
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+const (
+	// MetaContentType 保存 Content-Type 的元数据名
+	MetaContentType = "content_type"
+)
+
+// DownloadHandler 下载网关, 将 /group/M00/... 路径映射为远端文件ID
+type DownloadHandler struct {
+	Client *client.FdfsClient
+	// UseMetadata 为 true 时优先使用元数据中的 Content-Type
+	UseMetadata bool
+	// AntiSteal 不为空时校验请求中的防盗链 token 和 ts 参数
+	AntiSteal *client.AntiSteal
+}
+
+// NewDownloadHandler 新下载网关
+func NewDownloadHandler(fdfsClient *client.FdfsClient) *DownloadHandler {
+	return &DownloadHandler{Client: fdfsClient}
+}
+
+func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	remoteFileID, ok := remoteFileIDFromPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if h.AntiSteal != nil {
+		if err := h.AntiSteal.VerifyRequest(r, remoteFileID); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	rf, err := h.Client.Open(r.Context(), remoteFileID)
+	if err != nil {
+		writeError(w, r, h.Client, err)
+		return
+	}
+	defer rf.Close()
+
+	info := rf.Info()
+	w.Header().Set("ETag", fmt.Sprintf(`"%08x-%x"`, info.CRC32, info.FileSize))
+	w.Header().Set("Content-Type", h.contentType(remoteFileID))
+	http.ServeContent(w, r, path.Base(remoteFileID), info.CreateTimestamp, rf)
+}
+
+func (h *DownloadHandler) contentType(remoteFileID string) string {
+	if h.UseMetadata {
+		if meta, err := h.Client.GetMetadata(remoteFileID); err == nil && len(meta[MetaContentType]) > 0 {
+			return meta[MetaContentType]
+		}
+	}
+	if ctype := mime.TypeByExtension(path.Ext(remoteFileID)); len(ctype) > 0 {
+		return ctype
+	}
+	return "application/octet-stream"
+}
+
+// remoteFileIDFromPath /group1/M00/00/00/xxx.jpg => group1/M00/00/00/xxx.jpg
+func remoteFileIDFromPath(urlPath string) (string, bool) {
+	remoteFileID := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	parts := strings.SplitN(remoteFileID, "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[0]) > client.FDFS_GROUP_NAME_MAX_LEN ||
+		!strings.HasPrefix(parts[1], "M") {
+		return "", false
+	}
+	return remoteFileID, true
+}
+
+// requestError 请求本身不合法, 或读取请求体出错
+type requestError struct {
+	err error
+}
+
+func (e requestError) Error() string {
+	return e.err.Error()
+}
+
+// status 请求体超过 http.MaxBytesReader 的限制时为 413, 否则为 400
+// Go 1.19 之前 MaxBytesReader 的错误没有导出的类型, 只能比较错误信息
+func (e requestError) status() int {
+	if strings.Contains(e.err.Error(), "request body too large") {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// writeError fastdfs 的错误中有存储服务器地址等内部信息, 只记录到客户端的日志, 响应中只返回状态码的文本
+// 请求本身不合法时返回错误原因
+func writeError(w http.ResponseWriter, r *http.Request, fdfsClient *client.FdfsClient, err error) {
+	if e, ok := err.(requestError); ok {
+		http.Error(w, e.Error(), e.status())
+		return
+	}
+	status := http.StatusBadGateway
+	if errors.Is(err, client.ErrNotFound) {
+		status = http.StatusNotFound
+	} else if errors.Is(err, client.ErrInvalidArgument) {
+		status = http.StatusBadRequest
+	}
+	if logger := fdfsClient.Logger(); logger != nil {
+		args := []interface{}{"method", r.Method, "path", r.URL.Path, "status", status, "err", err}
+		if status == http.StatusBadGateway {
+			logger.Error("fdfs gateway request failed", args...)
+		} else {
+			logger.Info("fdfs gateway request failed", args...)
+		}
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/client"
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func newTestClient(t *testing.T) (*client.FdfsClient, *fdfstest.Server) {
+	server, err := fdfstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	cfg, err := client.LoadClientConfig("", server.ConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdfsClient, err := client.NewFdfsClientByConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fdfsClient, server
+}
+
+type recordingLogger struct {
+	lines []string
+	mutex sync.Mutex
+}
+
+func (l *recordingLogger) record(level, msg string, args []interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, fmt.Sprint(level, " ", msg, args))
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record("DEBUG", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg, args) }
+
+func TestRemoteFileIDFromPath(t *testing.T) {
+	cases := []struct {
+		path   string
+		expect string
+		ok     bool
+	}{
+		{"/group1/M00/00/00/wKgBaFoAAA.jpg", "group1/M00/00/00/wKgBaFoAAA.jpg", true},
+		{"/group1/M00/00/../00/wKgBaFoAAA.jpg", "group1/M00/00/wKgBaFoAAA.jpg", true},
+		{"/group1", "", false},
+		{"/", "", false},
+		{"/group1/static/a.jpg", "", false},
+		{"/group_name_too_long_xx/M00/00/00/a.jpg", "", false},
+	}
+	for _, c := range cases {
+		remoteFileID, ok := remoteFileIDFromPath(c.path)
+		if ok != c.ok || remoteFileID != c.expect {
+			t.Errorf("%s: expect (%s, %v), actual (%s, %v)", c.path, c.expect, c.ok, remoteFileID, ok)
+		}
+	}
+}
+
+func TestDownloadAntiSteal(t *testing.T) {
+	as := &client.AntiSteal{SecretKey: "secret", TokenTTL: time.Minute}
+	h := &DownloadHandler{AntiSteal: as}
+	remoteFileID := "group1/M00/00/00/wKgBaFoAAA.jpg"
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+remoteFileID, nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unsigned request: expect %d, actual %d", http.StatusForbidden, w.Code)
+	}
+
+	signed, err := as.SignURL("/"+remoteFileID, remoteFileID, time.Now().Add(-2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, signed, nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expired request: expect %d, actual %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	data := []byte("hello gateway download")
+	ur, err := fdfsClient.UploadByBuffer(data, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewDownloadHandler(fdfsClient)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+ur.RemoteFileID, nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != string(data) || len(etag) == 0 {
+		t.Fatalf("unexpected response %d %q etag %q", w.Code, w.Body.String(), etag)
+	}
+	if ctype := w.Header().Get("Content-Type"); !strings.HasPrefix(ctype, "text/plain") {
+		t.Errorf("unexpected content type %s", ctype)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/"+ur.RemoteFileID, nil)
+	r.Header.Set("Range", "bytes=6-12")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(data[6:13]) {
+		t.Errorf("unexpected range response %d %q", w.Code, w.Body.String())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes 6-12/%d", len(data)) {
+		t.Errorf("unexpected content range %s", cr)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/"+ur.RemoteFileID, nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != fmt.Sprint(len(data)) {
+		t.Errorf("unexpected head response %d %q length %s", w.Code, w.Body.String(), w.Header().Get("Content-Length"))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/"+ur.RemoteFileID, nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("unexpected conditional response %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+ur.RemoteFileID, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect %d, actual %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestDownloadError(t *testing.T) {
+	fdfsClient, server := newTestClient(t)
+	logger := &recordingLogger{}
+	fdfsClient.SetLogger(logger)
+	ur, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewDownloadHandler(fdfsClient)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/group1/M00/00/00/missing.txt", nil))
+	if w.Code != http.StatusNotFound || w.Body.String() != "Not Found\n" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	server.InjectFault(client.STORAGE_PROTO_CMD_QUERY_FILE_INFO, fdfstest.Fault{Status: 5, Times: 1})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+ur.RemoteFileID, nil))
+	if w.Code != http.StatusBadGateway || w.Body.String() != "Bad Gateway\n" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	// 错误详情只记录到日志
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if len(logger.lines) != 2 || !strings.HasPrefix(logger.lines[0], "INFO fdfs gateway request failed") ||
+		!strings.HasPrefix(logger.lines[1], "ERROR fdfs gateway request failed") || !strings.Contains(logger.lines[1], server.StorageAddr()) {
+		t.Errorf("unexpected logs %q", logger.lines)
+	}
+}
@@ -0,0 +1,222 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+const (
+	// HeaderGroup 指定上传组的请求头
+	HeaderGroup = "X-Fdfs-Group"
+	// HeaderMetaPrefix 上传时附带元数据的请求头前缀
+	HeaderMetaPrefix = "X-Fdfs-Meta-"
+	// FormFileField multipart/form-data 中的文件字段名
+	FormFileField = "file"
+	// MetaFilename 保存原始文件名的元数据名
+	MetaFilename = "filename"
+
+	defaultMaxMemory   = 32 << 20
+	defaultChunkSize   = 4 << 20
+	defaultMaxBodySize = 1 << 30
+)
+
+// UploadResult 上传结果
+type UploadResult struct {
+	GroupName    string `json:"group_name"`
+	RemoteFileID string `json:"remote_file_id"`
+	URL          string `json:"url,omitempty"`
+	Size         int64  `json:"size"`
+}
+
+// UploadHandler 上传网关, 支持 multipart/form-data 和原始 PUT/POST 请求体
+type UploadHandler struct {
+	Client *client.FdfsClient
+	// DefaultGroup 请求未指定组时使用的组, 为空时由 tracker 选择
+	DefaultGroup string
+	// StoreMetadata 为 true 时保存 Content-Type、原始文件名以及 X-Fdfs-Meta-* 请求头
+	StoreMetadata bool
+	// URLPrefix 不为空时, 返回结果中的 URL 为 URLPrefix + RemoteFileID, 否则使用 FdfsClient.URL
+	URLPrefix string
+	// MaxMemory multipart 解析时使用的最大内存
+	MaxMemory int64
+	// ChunkSize 原始请求体以 appender 方式分块上传的块大小
+	ChunkSize int
+	// MaxBodySize 请求体的最大字节数, 超出时返回 413, 小于等于0时为 1GB
+	MaxBodySize int64
+}
+
+// NewUploadHandler 新上传网关
+func NewUploadHandler(fdfsClient *client.FdfsClient) *UploadHandler {
+	return &UploadHandler{Client: fdfsClient}
+}
+
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBodySize := h.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	if r.ContentLength > maxBodySize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	var (
+		result   *UploadResult
+		filename string
+		ctype    string
+		err      error
+	)
+	groupName := h.groupName(r)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		result, filename, ctype, err = h.uploadMultipart(r, groupName)
+	} else {
+		filename = path.Base(r.URL.Path)
+		ctype = r.Header.Get("Content-Type")
+		result, err = h.uploadRaw(r.Body, fileExtName(r.URL.Query().Get("ext"), filename), groupName)
+	}
+	if err != nil {
+		writeError(w, r, h.Client, err)
+		return
+	}
+
+	if h.StoreMetadata {
+		meta := requestMetadata(r)
+		if len(ctype) > 0 {
+			meta[MetaContentType] = ctype
+		}
+		if len(filename) > 0 && filename != "/" && filename != "." {
+			meta[MetaFilename] = filename
+		}
+		if len(meta) > 0 {
+			err = h.Client.SetMetadata(result.RemoteFileID, meta, client.STORAGE_SET_METADATA_FLAG_OVERWRITE)
+			if err != nil {
+				_ = h.Client.DeleteFile(result.RemoteFileID)
+				writeError(w, r, h.Client, err)
+				return
+			}
+		}
+	}
+
+	if len(h.URLPrefix) > 0 {
+		result.URL = h.URLPrefix + result.RemoteFileID
+	} else {
+		result.URL, _ = h.Client.URL(result.RemoteFileID)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (h *UploadHandler) groupName(r *http.Request) string {
+	if groupName := r.Header.Get(HeaderGroup); len(groupName) > 0 {
+		return groupName
+	}
+	if groupName := r.URL.Query().Get("group"); len(groupName) > 0 {
+		return groupName
+	}
+	return h.DefaultGroup
+}
+
+func (h *UploadHandler) uploadMultipart(r *http.Request, groupName string) (*UploadResult, string, string, error) {
+	maxMemory := h.MaxMemory
+	if maxMemory <= 0 {
+		maxMemory = defaultMaxMemory
+	}
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return nil, "", "", requestError{err}
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile(FormFileField)
+	if err != nil {
+		return nil, "", "", requestError{err}
+	}
+	defer file.Close()
+
+	ur, err := h.Client.UploadByStream(file, header.Size, fileExtName("", header.Filename), groupName)
+	if err != nil {
+		return nil, "", "", err
+	}
+	result := &UploadResult{GroupName: ur.GroupName, RemoteFileID: ur.RemoteFileID, Size: header.Size}
+	return result, header.Filename, header.Header.Get("Content-Type"), nil
+}
+
+// uploadRaw 请求体不超过一个块时作为普通文件上传, 否则以 appender 文件分块追加
+func (h *UploadHandler) uploadRaw(body io.Reader, ext string, groupName string) (*UploadResult, error) {
+	chunkSize := h.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	chunk := make([]byte, chunkSize)
+
+	n, err := io.ReadFull(body, chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		ur, err := h.Client.UploadByBuffer(chunk[:n], ext, groupName)
+		if err != nil {
+			return nil, err
+		}
+		return &UploadResult{GroupName: ur.GroupName, RemoteFileID: ur.RemoteFileID, Size: int64(n)}, nil
+	}
+	if err != nil {
+		return nil, requestError{err}
+	}
+
+	ur, err := h.Client.UploadAppenderByBuffer(chunk[:n], ext, groupName)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(n)
+	for {
+		n, err = io.ReadFull(body, chunk)
+		if n > 0 {
+			if err := h.Client.AppendByBuffer(chunk[:n], ur.RemoteFileID); err != nil {
+				_ = h.Client.DeleteFile(ur.RemoteFileID)
+				return nil, err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			_ = h.Client.DeleteFile(ur.RemoteFileID)
+			return nil, requestError{err}
+		}
+	}
+	return &UploadResult{GroupName: ur.GroupName, RemoteFileID: ur.RemoteFileID, Size: size}, nil
+}
+
+func requestMetadata(r *http.Request) map[string]string {
+	meta := make(map[string]string)
+	for key, values := range r.Header {
+		if strings.HasPrefix(key, HeaderMetaPrefix) && len(key) > len(HeaderMetaPrefix) && len(values) > 0 {
+			meta[strings.ToLower(key[len(HeaderMetaPrefix):])] = values[0]
+		}
+	}
+	return meta
+}
+
+// fileExtName 优先使用指定的扩展名, 否则取文件名的扩展名, 超过 FDFS_FILE_EXT_NAME_MAX_LEN 时忽略
+func fileExtName(ext string, filename string) string {
+	if len(ext) == 0 {
+		ext = path.Ext(filename)
+	}
+	ext = strings.TrimPrefix(ext, ".")
+	if len(ext) > client.FDFS_FILE_EXT_NAME_MAX_LEN {
+		return ""
+	}
+	return ext
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+func TestFileExtName(t *testing.T) {
+	cases := []struct {
+		ext      string
+		filename string
+		expect   string
+	}{
+		{"", "photo.jpg", "jpg"},
+		{".png", "photo.jpg", "png"},
+		{"", "archive", ""},
+		{"", "data.toolong", ""},
+	}
+	for _, c := range cases {
+		if ext := fileExtName(c.ext, c.filename); ext != c.expect {
+			t.Errorf("(%s, %s): expect %s, actual %s", c.ext, c.filename, c.expect, ext)
+		}
+	}
+}
+
+func TestRequestMetadata(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodPut, "/upload/a.txt", nil)
+	r.Header.Set("X-Fdfs-Meta-Author", "fdfs")
+	r.Header.Set("X-Fdfs-Meta-", "ignored")
+	r.Header.Set("X-Other", "ignored")
+	meta := requestMetadata(r)
+	if len(meta) != 1 || meta["author"] != "fdfs" {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+}
+
+// chunkedRequest 没有 Content-Length 的请求体
+func chunkedRequest(target string, body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPut, target, ioutil.NopCloser(bytes.NewReader(body)))
+	r.ContentLength = -1
+	r.TransferEncoding = []string{"chunked"}
+	return r
+}
+
+func uploadResult(t *testing.T, fdfsClient *client.FdfsClient, w *httptest.ResponseRecorder, expect []byte) {
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	var result UploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != int64(len(expect)) {
+		t.Errorf("expect size %d, actual %d", len(expect), result.Size)
+	}
+	dr, err := fdfsClient.DownloadToBuffer(result.RemoteFileID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := dr.Content.([]byte); !bytes.Equal(content, expect) {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	h := &UploadHandler{Client: fdfsClient, ChunkSize: 8, MaxBodySize: 32}
+
+	// 不超过一个块, 普通文件
+	data := []byte("hello")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/upload/a.txt", bytes.NewReader(data)))
+	uploadResult(t, fdfsClient, w, data)
+
+	// 超过一个块, appender 文件分块追加
+	data = []byte("hello chunked upload body")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, chunkedRequest("/upload/a.txt", data))
+	uploadResult(t, fdfsClient, w, data)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload/empty.txt", http.NoBody))
+	uploadResult(t, fdfsClient, w, nil)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(FormFileField, "form.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(fw, "form")
+	_ = mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	(&UploadHandler{Client: fdfsClient}).ServeHTTP(w, r)
+	uploadResult(t, fdfsClient, w, []byte("form"))
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	fdfsClient, _ := newTestClient(t)
+	h := &UploadHandler{Client: fdfsClient, ChunkSize: 8, MaxBodySize: 16}
+	data := []byte(strings.Repeat("x", 17))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/upload/a.txt", bytes.NewReader(data)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("content length: expect %d, actual %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	// 分块上传时超出限制
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, chunkedRequest("/upload/a.txt", data))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked: expect %d, actual %d %q", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, chunkedRequest("/upload/a.txt", data[:16]))
+	uploadResult(t, fdfsClient, w, data[:16])
+}
@@ -1,5 +1,5 @@
 module github.com/lerryxiao/fdfs_client
 
-go 1.13
+go 1.16
 
 require github.com/jslyzt/goconfig v0.0.0-20180305021213-737bb5a2dad6
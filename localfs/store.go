@@ -0,0 +1,340 @@
+// Package localfs 基于本地磁盘的 client.FileStore 实现, 用于没有 fastdfs 集群的本地开发和单元测试
+// 文件保存在 root/group/M00/xx/yy/name.ext, 元数据以 JSON 保存在同目录的 name.ext-m 文件中
+package localfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+const (
+	// DefaultGroupName 默认组名
+	DefaultGroupName = "group1"
+
+	metaSuffix   = "-m"
+	storePath    = "M00"
+	groupNameLen = 16
+	extNameLen   = 6
+	metaNameLen  = 64
+	metaValueLen = 256
+)
+
+var _ client.FileStore = (*Store)(nil)
+
+// Store 本地磁盘存储
+type Store struct {
+	root      string
+	groupName string
+	mutex     sync.Mutex
+}
+
+// New 新本地存储, groupName 为空时使用 DefaultGroupName
+func New(root string, groupName string) (*Store, error) {
+	if len(groupName) == 0 {
+		groupName = DefaultGroupName
+	}
+	if err := checkGroupName(groupName); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{root: root, groupName: groupName}, nil
+}
+
+// Root 根目录
+func (s *Store) Root() string {
+	return s.root
+}
+
+// UploadByFilename 上传本地文件
+func (s *Store) UploadByFilename(filename string, groupName ...string) (*client.UploadFileResponse, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, opError("upload", "", err)
+	}
+	defer file.Close()
+	return s.upload(file, extName(filename), groupName...)
+}
+
+// UploadByBuffer 上传数据
+func (s *Store) UploadByBuffer(filebuffer []byte, fileExtName string, groupName ...string) (*client.UploadFileResponse, error) {
+	return s.upload(bytes.NewReader(filebuffer), fileExtName, groupName...)
+}
+
+// UploadByStream 上传流, size 小于等于0时通过 Seek 获取
+func (s *Store) UploadByStream(stream client.ReadStream, size int64, fileExtName string, groupName ...string) (*client.UploadFileResponse, error) {
+	if size <= 0 {
+		var err error
+		if size, err = stream.Seek(0, io.SeekEnd); err != nil {
+			return nil, opError("upload", "", err)
+		}
+	}
+	return s.upload(io.NewSectionReader(stream, 0, size), fileExtName, groupName...)
+}
+
+// DownloadToFile 下载到本地文件
+func (s *Store) DownloadToFile(localFilename string, remoteFileID string, offset int64, downloadSize int64) (*client.DownloadFileResponse, error) {
+	content, err := s.read(remoteFileID, offset, downloadSize)
+	if err != nil {
+		return nil, opError("download", remoteFileID, err)
+	}
+	if err = os.WriteFile(localFilename, content, 0644); err != nil {
+		return nil, opError("download", remoteFileID, err)
+	}
+	return &client.DownloadFileResponse{RemoteFileID: remoteFileID, Content: localFilename, DownloadSize: int64(len(content))}, nil
+}
+
+// DownloadToBuffer 下载到内存
+func (s *Store) DownloadToBuffer(remoteFileID string, offset int64, downloadSize int64) (*client.DownloadFileResponse, error) {
+	content, err := s.read(remoteFileID, offset, downloadSize)
+	if err != nil {
+		return nil, opError("download", remoteFileID, err)
+	}
+	return &client.DownloadFileResponse{RemoteFileID: remoteFileID, Content: content, DownloadSize: int64(len(content))}, nil
+}
+
+// DeleteFile 删除文件和元数据
+func (s *Store) DeleteFile(remoteFileID string) error {
+	filename, err := s.localPath(remoteFileID)
+	if err != nil {
+		return opError("delete", remoteFileID, err)
+	}
+	if err = os.Remove(filename); err != nil {
+		return opError("delete", remoteFileID, err)
+	}
+	if err = os.Remove(filename + metaSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return opError("delete", remoteFileID, err)
+	}
+	return nil
+}
+
+// SetMetadata 设置元数据, opFlag 为 STORAGE_SET_METADATA_FLAG_OVERWRITE 或 STORAGE_SET_METADATA_FLAG_MERGE
+func (s *Store) SetMetadata(remoteFileID string, meta map[string]string, opFlag byte) error {
+	if err := s.setMetadata(remoteFileID, meta, opFlag); err != nil {
+		return opError("set_metadata", remoteFileID, err)
+	}
+	return nil
+}
+
+// GetMetadata 获取元数据
+func (s *Store) GetMetadata(remoteFileID string) (map[string]string, error) {
+	filename, err := s.localPath(remoteFileID)
+	if err != nil {
+		return nil, opError("get_metadata", remoteFileID, err)
+	}
+	if _, err = os.Stat(filename); err != nil {
+		return nil, opError("get_metadata", remoteFileID, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	meta, err := readMetadata(filename)
+	if err != nil {
+		return nil, opError("get_metadata", remoteFileID, err)
+	}
+	return meta, nil
+}
+
+// QueryFileInfo 文件信息, CRC32 为 IEEE crc32
+func (s *Store) QueryFileInfo(remoteFileID string) (*client.FileInfo, error) {
+	filename, err := s.localPath(remoteFileID)
+	if err != nil {
+		return nil, opError("query_file_info", remoteFileID, err)
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, opError("query_file_info", remoteFileID, err)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, opError("query_file_info", remoteFileID, err)
+	}
+	return &client.FileInfo{
+		FileSize:        stat.Size(),
+		CreateTimestamp: stat.ModTime(),
+		CRC32:           crc32.ChecksumIEEE(content),
+		SourceIPAddr:    "127.0.0.1",
+	}, nil
+}
+
+func (s *Store) upload(content io.Reader, fileExtName string, groupName ...string) (*client.UploadFileResponse, error) {
+	group := s.groupName
+	if len(groupName) > 0 && len(groupName[0]) > 0 {
+		group = groupName[0]
+	}
+	if err := checkGroupName(group); err != nil {
+		return nil, opError("upload", "", err)
+	}
+	if len(fileExtName) > extNameLen || strings.ContainsAny(fileExtName, "/\\.") {
+		return nil, opError("upload", "", fmt.Errorf("%w: ext name [%s]", client.ErrInvalidArgument, fileExtName))
+	}
+
+	remoteFilename, err := newRemoteFilename(fileExtName)
+	if err != nil {
+		return nil, opError("upload", "", err)
+	}
+	remoteFileID := group + "/" + remoteFilename
+	filename := filepath.Join(s.root, group, filepath.FromSlash(remoteFilename))
+	if err = writeFile(filename, content); err != nil {
+		return nil, opError("upload", remoteFileID, err)
+	}
+	return &client.UploadFileResponse{GroupName: group, RemoteFileID: remoteFileID}, nil
+}
+
+func (s *Store) read(remoteFileID string, offset int64, downloadSize int64) ([]byte, error) {
+	filename, err := s.localPath(remoteFileID)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > int64(len(content)) || downloadSize < 0 {
+		return nil, fmt.Errorf("%w: offset %d, size %d", client.ErrInvalidArgument, offset, downloadSize)
+	}
+	end := int64(len(content))
+	if downloadSize > 0 && offset+downloadSize < end {
+		end = offset + downloadSize
+	}
+	return content[offset:end], nil
+}
+
+func (s *Store) setMetadata(remoteFileID string, meta map[string]string, opFlag byte) error {
+	for name, value := range meta {
+		if len(name) == 0 || len(name) > metaNameLen || len(value) > metaValueLen {
+			return fmt.Errorf("%w: metadata [%s]", client.ErrInvalidArgument, name)
+		}
+	}
+	filename, err := s.localPath(remoteFileID)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(filename); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	merged := make(map[string]string)
+	switch opFlag {
+	case client.STORAGE_SET_METADATA_FLAG_OVERWRITE:
+	case client.STORAGE_SET_METADATA_FLAG_MERGE:
+		if merged, err = readMetadata(filename); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: op flag [%c]", client.ErrInvalidArgument, opFlag)
+	}
+	for name, value := range meta {
+		merged[name] = value
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	return writeFile(filename+metaSuffix, bytes.NewReader(data))
+}
+
+// localPath 远端文件ID对应的本地路径, 拒绝 .. 等越过根目录的路径
+func (s *Store) localPath(remoteFileID string) (string, error) {
+	parts := strings.SplitN(remoteFileID, "/", 2)
+	if len(parts) != 2 || checkGroupName(parts[0]) != nil || len(parts[1]) == 0 ||
+		path.Clean(remoteFileID) != remoteFileID || strings.HasSuffix(remoteFileID, metaSuffix) {
+		return "", fmt.Errorf("%w: remote file id [%s]", client.ErrInvalidArgument, remoteFileID)
+	}
+	for _, part := range strings.Split(remoteFileID, "/") {
+		if part == ".." || part == "." {
+			return "", fmt.Errorf("%w: remote file id [%s]", client.ErrInvalidArgument, remoteFileID)
+		}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(remoteFileID)), nil
+}
+
+func readMetadata(filename string) (map[string]string, error) {
+	meta := make(map[string]string)
+	data, err := os.ReadFile(filename + metaSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// writeFile 先写临时文件再改名, 避免读到写了一半的文件
+func writeFile(filename string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// newRemoteFilename M00/xx/yy/name.ext, 与 fastdfs 的两级目录和 27 字节文件名相同
+func newRemoteFilename(fileExtName string) (string, error) {
+	buf := make([]byte, 22)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	// 没有扩展名时文件名不能与元数据文件的后缀冲突
+	name := strings.Replace(base64.RawURLEncoding.EncodeToString(buf[2:]), "-", "_", -1)
+	filename := fmt.Sprintf("%s/%02X/%02X/%s", storePath, buf[0], buf[1], name)
+	if len(fileExtName) > 0 {
+		filename += "." + fileExtName
+	}
+	return filename, nil
+}
+
+func checkGroupName(groupName string) error {
+	if len(groupName) == 0 || len(groupName) > groupNameLen || strings.ContainsAny(groupName, "/\\.") {
+		return fmt.Errorf("%w: group name [%s]", client.ErrInvalidArgument, groupName)
+	}
+	return nil
+}
+
+func extName(filename string) string {
+	ext := strings.TrimPrefix(filepath.Ext(filename), ".")
+	if len(ext) > extNameLen {
+		return ""
+	}
+	return ext
+}
+
+// opError 与 FdfsClient 一致, 文件不存在时可以用 errors.Is(err, client.ErrNotFound) 判断
+func opError(op string, remoteFileID string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		err = client.ErrNotFound
+	}
+	return &client.OpError{Op: op, FileID: remoteFileID, Err: err}
+}
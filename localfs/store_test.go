@@ -0,0 +1,68 @@
+package localfs
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+func TestStore(t *testing.T) {
+	store, err := New(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ur, err := store.UploadByStream(strings.NewReader("hello localfs"), 0, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^group1/M00/[0-9A-F]{2}/[0-9A-F]{2}/[0-9A-Za-z_]{27}\.txt$`).MatchString(ur.RemoteFileID) {
+		t.Errorf("unexpected file id %s", ur.RemoteFileID)
+	}
+
+	dr, err := store.DownloadToBuffer(ur.RemoteFileID, 6, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := dr.Content.([]byte); string(content) != "local" {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	if err = store.SetMetadata(ur.RemoteFileID, map[string]string{"a": "1", "b": "2"}, client.STORAGE_SET_METADATA_FLAG_OVERWRITE); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetMetadata(ur.RemoteFileID, map[string]string{"b": "3"}, client.STORAGE_SET_METADATA_FLAG_MERGE); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := store.GetMetadata(ur.RemoteFileID)
+	if err != nil || len(meta) != 2 || meta["a"] != "1" || meta["b"] != "3" {
+		t.Errorf("unexpected metadata %v %v", meta, err)
+	}
+
+	info, err := store.QueryFileInfo(ur.RemoteFileID)
+	if err != nil || info.FileSize != 13 {
+		t.Errorf("unexpected file info %+v %v", info, err)
+	}
+
+	if err = store.DeleteFile(ur.RemoteFileID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.DownloadToBuffer(ur.RemoteFileID, 0, 0); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expect ErrNotFound: %v", err)
+	}
+}
+
+func TestStoreInvalidFileID(t *testing.T) {
+	store, err := New(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fileID := range []string{"group1", "group1/../secret", "group1/M00/../../x", "../x/y", "group1/M00/00/00/a.txt-m"} {
+		if _, err := store.DownloadToBuffer(fileID, 0, 0); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Errorf("%s: expect ErrInvalidArgument, actual %v", fileID, err)
+		}
+	}
+}
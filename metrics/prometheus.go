@@ -0,0 +1,277 @@
+// Package metrics 把 client.Metrics 以 prometheus 文本格式导出, 不依赖 prometheus 客户端库
+//
+//	m := metrics.NewPrometheus()
+//	m.Register(fdfsClient)
+//	http.Handle("/metrics", m)
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+// DefaultBuckets 延迟直方图的默认桶, 单位秒
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ContentType prometheus 文本格式
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type opKey struct {
+	op   string
+	addr string
+}
+
+type errorKey struct {
+	op   string
+	addr string
+	code string
+}
+
+type bytesKey struct {
+	addr      string
+	direction string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Prometheus 实现 client.Metrics 和 http.Handler
+type Prometheus struct {
+	// Buckets 延迟直方图的桶, 需要在使用前设置
+	Buckets []float64
+
+	ops     map[opKey]*histogram
+	errors  map[errorKey]uint64
+	bytes   map[bytesKey]uint64
+	clients []*client.FdfsClient
+	mutex   sync.Mutex
+}
+
+var _ client.Metrics = (*Prometheus)(nil)
+
+// NewPrometheus 新建
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		Buckets: DefaultBuckets,
+		ops:     make(map[opKey]*histogram),
+		errors:  make(map[errorKey]uint64),
+		bytes:   make(map[bytesKey]uint64),
+	}
+}
+
+// Register 设置客户端的 Metrics, 并导出客户端的连接池状态
+func (p *Prometheus) Register(fdfsClient *client.FdfsClient) {
+	fdfsClient.SetMetrics(p)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.clients = append(p.clients, fdfsClient)
+}
+
+// ObserveOp client.Metrics
+func (p *Prometheus) ObserveOp(op string, addr string, duration time.Duration, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := opKey{op, addr}
+	h, ok := p.ops[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.Buckets))}
+		p.ops[key] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range p.Buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if err != nil {
+		p.errors[errorKey{op, addr, ErrorCode(err)}]++
+	}
+}
+
+// AddBytes client.Metrics
+func (p *Prometheus) AddBytes(addr string, direction string, n int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.bytes[bytesKey{addr, direction}] += uint64(n)
+}
+
+// ErrorCode 错误分类: 服务端返回的 errno, 或者 protocol, timeout, network, invalid_argument, canceled, other
+func ErrorCode(err error) string {
+	var errno client.Errno
+	var netErr net.Error
+	switch {
+	case errors.As(err, &errno):
+		return strconv.Itoa(errno.Status())
+	case errors.Is(err, client.ErrProtocol):
+		return "protocol"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "network"
+	case errors.Is(err, client.ErrInvalidArgument):
+		return "invalid_argument"
+	}
+	return "other"
+}
+
+// ServeHTTP 输出所有指标
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = p.WriteTo(w)
+}
+
+// WriteTo 以 prometheus 文本格式输出所有指标
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	p.mutex.Lock()
+	p.writeOps(&b)
+	p.writeErrors(&b)
+	p.writeBytes(&b)
+	clients := append([]*client.FdfsClient(nil), p.clients...)
+	p.mutex.Unlock()
+	writePools(&b, clients)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (p *Prometheus) writeOps(b *strings.Builder) {
+	if len(p.ops) == 0 {
+		return
+	}
+	const name = "fdfs_client_operation_duration_seconds"
+	writeHeader(b, name, "histogram", "Latency of FastDFS operations by storage or tracker address.")
+	keys := make([]opKey, 0, len(p.ops))
+	for key := range p.ops {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].op < keys[j].op || (keys[i].op == keys[j].op && keys[i].addr < keys[j].addr)
+	})
+	for _, key := range keys {
+		h := p.ops[key]
+		labels := "op=" + quote(key.op) + ",addr=" + quote(key.addr)
+		for i, bound := range p.Buckets {
+			fmt.Fprintf(b, "%s_bucket{%s,le=%s} %d\n", name, labels, quote(formatFloat(bound)), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+func (p *Prometheus) writeErrors(b *strings.Builder) {
+	if len(p.errors) == 0 {
+		return
+	}
+	const name = "fdfs_client_operation_errors_total"
+	writeHeader(b, name, "counter", "Failed FastDFS operations by error code, the code is the server errno when available.")
+	keys := make([]errorKey, 0, len(p.errors))
+	for key := range p.errors {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, c := keys[i], keys[j]
+		if a.op != c.op {
+			return a.op < c.op
+		}
+		if a.addr != c.addr {
+			return a.addr < c.addr
+		}
+		return a.code < c.code
+	})
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s{op=%s,addr=%s,code=%s} %d\n", name, quote(key.op), quote(key.addr), quote(key.code), p.errors[key])
+	}
+}
+
+func (p *Prometheus) writeBytes(b *strings.Builder) {
+	if len(p.bytes) == 0 {
+		return
+	}
+	const name = "fdfs_client_bytes_total"
+	writeHeader(b, name, "counter", "Bytes sent to and received from FastDFS servers.")
+	keys := make([]bytesKey, 0, len(p.bytes))
+	for key := range p.bytes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].addr < keys[j].addr || (keys[i].addr == keys[j].addr && keys[i].direction < keys[j].direction)
+	})
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s{addr=%s,direction=%s} %d\n", name, quote(key.addr), quote(key.direction), p.bytes[key])
+	}
+}
+
+// writePools 多个客户端共享 storage 连接池, 同一地址只输出一次
+func writePools(b *strings.Builder, clients []*client.FdfsClient) {
+	seen := make(map[string]bool)
+	var stats []client.PoolStats
+	for _, c := range clients {
+		for _, s := range c.PoolStats() {
+			if !seen[s.Addr] {
+				seen[s.Addr] = true
+				stats = append(stats, s)
+			}
+		}
+	}
+	if len(stats) == 0 {
+		return
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	for _, gauge := range []struct {
+		name  string
+		help  string
+		value func(client.PoolStats) int
+	}{
+		{"fdfs_client_pool_idle_connections", "Idle connections in the pool.", func(s client.PoolStats) int { return s.Idle }},
+		{"fdfs_client_pool_in_use_connections", "Connections taken from the pool.", func(s client.PoolStats) int { return s.InUse }},
+		{"fdfs_client_pool_max_connections", "Maximum connections of the pool.", func(s client.PoolStats) int { return s.MaxConns }},
+	} {
+		writeHeader(b, gauge.name, "gauge", gauge.help)
+		for _, s := range stats {
+			fmt.Fprintf(b, "%s{addr=%s} %d\n", gauge.name, quote(s.Addr), gauge.value(s))
+		}
+	}
+}
+
+func writeHeader(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// quote 标签值只需要转义 \ " 和换行
+func quote(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lerryxiao/fdfs_client/client"
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func TestPrometheus(t *testing.T) {
+	server, err := fdfstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	cfg, err := client.LoadClientConfig("", server.ConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdfsClient, err := client.NewFdfsClientByConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewPrometheus()
+	m.Register(fdfsClient)
+	resp, err := fdfsClient.UploadByBuffer([]byte("hello"), "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = fdfsClient.DeleteFile(resp.RemoteFileID)
+	_ = fdfsClient.DeleteFile(resp.RemoteFileID)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("unexpected content type %s", rec.Header().Get("Content-Type"))
+	}
+	out := rec.Body.String()
+	storage := server.StorageAddr()
+	for _, line := range []string{
+		"# TYPE fdfs_client_operation_duration_seconds histogram\n",
+		fmt.Sprintf("fdfs_client_operation_duration_seconds_count{op=\"upload\",addr=%q} 1\n", storage),
+		fmt.Sprintf("fdfs_client_operation_duration_seconds_bucket{op=\"delete\",addr=%q,le=\"+Inf\"} 2\n", storage),
+		fmt.Sprintf("fdfs_client_operation_errors_total{op=\"delete\",addr=%q,code=\"2\"} 1\n", storage),
+		fmt.Sprintf("fdfs_client_pool_in_use_connections{addr=%q} 0\n", server.TrackerAddr()),
+		fmt.Sprintf("fdfs_client_pool_max_connections{addr=%q} %d\n", storage, cfg.MaxConns),
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expect %q in output:\n%s", line, out)
+		}
+	}
+	if !strings.Contains(out, fmt.Sprintf("fdfs_client_bytes_total{addr=%q,direction=\"sent\"}", storage)) {
+		t.Errorf("bytes not exported:\n%s", out)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	m := NewPrometheus()
+	m.Buckets = []float64{0.1, 1}
+	m.ObserveOp("download", "", 50*time.Millisecond, nil)
+	m.ObserveOp("download", "", 500*time.Millisecond, nil)
+	m.ObserveOp("download", "", 5*time.Second, errors.New("boom"))
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`fdfs_client_operation_duration_seconds_bucket{op="download",addr="",le="0.1"} 1`,
+		`fdfs_client_operation_duration_seconds_bucket{op="download",addr="",le="1"} 2`,
+		`fdfs_client_operation_duration_seconds_bucket{op="download",addr="",le="+Inf"} 3`,
+		`fdfs_client_operation_duration_seconds_sum{op="download",addr=""} 5.55`,
+		`fdfs_client_operation_errors_total{op="download",addr="",code="other"} 1`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("expect %q in output:\n%s", line, b.String())
+		}
+	}
+}
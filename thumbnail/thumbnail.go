@@ -0,0 +1,162 @@
+// Package thumbnail 根据主图片生成缩略图, 并作为从文件上传
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/lerryxiao/fdfs_client/client"
+)
+
+const defaultQuality = 85
+
+// Size 缩略图尺寸
+type Size struct {
+	Width  int
+	Height int
+	// Crop 为 true 时裁剪中间部分得到精确尺寸, 否则保持比例缩放到 Width x Height 以内
+	Crop bool
+}
+
+// Prefix 从文件前缀名, 例如 _150x150
+func (s Size) Prefix() string {
+	return fmt.Sprintf("_%dx%d", s.Width, s.Height)
+}
+
+// Result 生成的缩略图
+type Result struct {
+	Size   Size
+	FileID string
+}
+
+// Generator 缩略图生成器
+type Generator struct {
+	Client *client.FdfsClient
+	Sizes  []Size
+	// Quality jpeg 质量, 为0时使用 85
+	Quality int
+	// Upscale 为 true 时允许放大比目标尺寸小的图片
+	Upscale bool
+}
+
+// NewGenerator 新缩略图生成器
+func NewGenerator(fdfsClient *client.FdfsClient, sizes ...Size) *Generator {
+	return &Generator{Client: fdfsClient, Sizes: sizes}
+}
+
+// Generate 下载主图片, 按配置的尺寸生成缩略图并作为从文件上传
+// 出错时返回已经上传的缩略图
+func (g *Generator) Generate(masterFileID string) ([]Result, error) {
+	dr, err := g.Client.DownloadToBuffer(masterFileID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	content, _ := dr.Content.([]byte)
+	src, format, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", masterFileID, err)
+	}
+
+	results := make([]Result, 0, len(g.Sizes))
+	for _, size := range g.Sizes {
+		buffer := new(bytes.Buffer)
+		if err = g.encode(buffer, g.Resize(src, size), format); err != nil {
+			return results, err
+		}
+		ur, err := g.Client.UploadSlave(&client.SlaveUploadRequest{
+			MasterFileID: masterFileID,
+			PrefixName:   size.Prefix(),
+			Source:       buffer.Bytes(),
+		})
+		if err != nil {
+			return results, err
+		}
+		results = append(results, Result{Size: size, FileID: ur.RemoteFileID})
+	}
+	return results, nil
+}
+
+// Resize 按 size 缩放图片
+func (g *Generator) Resize(src image.Image, size Size) image.Image {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if size.Width <= 0 || size.Height <= 0 || sw == 0 || sh == 0 {
+		return src
+	}
+
+	rect := bounds
+	dw, dh := size.Width, size.Height
+	if size.Crop {
+		// 裁剪出与目标尺寸比例相同的中间部分
+		if sw*dh > sh*dw {
+			cw := sh * dw / dh
+			rect.Min.X += (sw - cw) / 2
+			rect.Max.X = rect.Min.X + cw
+		} else {
+			ch := sw * dh / dw
+			rect.Min.Y += (sh - ch) / 2
+			rect.Max.Y = rect.Min.Y + ch
+		}
+	} else if sw*dh > sh*dw {
+		dh = maxInt(1, sh*dw/sw)
+	} else {
+		dw = maxInt(1, sw*dh/sh)
+	}
+	if !g.Upscale && (dw > rect.Dx() || dh > rect.Dy()) {
+		dw, dh = rect.Dx(), rect.Dy()
+	}
+	return resize(src, rect, dw, dh)
+}
+
+func (g *Generator) encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		quality := g.Quality
+		if quality <= 0 {
+			quality = defaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+// resize 区域平均缩放, 目标像素取源图片对应区域内像素的平均值
+func resize(src image.Image, rect image.Rectangle, dw, dh int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	sw, sh := rect.Dx(), rect.Dy()
+	for y := 0; y < dh; y++ {
+		y0 := rect.Min.Y + y*sh/dh
+		y1 := maxInt(y0+1, rect.Min.Y+(y+1)*sh/dh)
+		for x := 0; x < dw; x++ {
+			x0 := rect.Min.X + x*sw/dw
+			x1 := maxInt(x0+1, rect.Min.X+(x+1)*sw/dw)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
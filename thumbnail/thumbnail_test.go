@@ -0,0 +1,119 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/lerryxiao/fdfs_client/client"
+	"github.com/lerryxiao/fdfs_client/fdfstest"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			if x < 200 {
+				src.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				src.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	g := &Generator{}
+	cases := []struct {
+		size          Size
+		width, height int
+	}{
+		{Size{Width: 100, Height: 100}, 100, 50},
+		{Size{Width: 100, Height: 100, Crop: true}, 100, 100},
+		{Size{Width: 800, Height: 800}, 400, 200},
+	}
+	for _, c := range cases {
+		bounds := g.Resize(src, c.size).Bounds()
+		if bounds.Dx() != c.width || bounds.Dy() != c.height {
+			t.Errorf("%s: expect %dx%d, actual %dx%d", c.size.Prefix(), c.width, c.height, bounds.Dx(), bounds.Dy())
+		}
+	}
+
+	dst := g.Resize(src, Size{Width: 100, Height: 100})
+	if r, _, b, _ := dst.At(10, 10).RGBA(); r>>8 != 255 || b != 0 {
+		t.Errorf("unexpected left color %v", dst.At(10, 10))
+	}
+	if r, _, b, _ := dst.At(90, 10).RGBA(); r != 0 || b>>8 != 255 {
+		t.Errorf("unexpected right color %v", dst.At(90, 10))
+	}
+}
+
+func TestSizePrefix(t *testing.T) {
+	if prefix := (Size{Width: 150, Height: 150}).Prefix(); prefix != "_150x150" {
+		t.Errorf("unexpected prefix %s", prefix)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	server, err := fdfstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	cfg, err := client.LoadClientConfig("", server.ConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdfsClient, err := client.NewFdfsClientByConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err = png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+	ur, err := fdfsClient.UploadByBuffer(buffer.Bytes(), "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []Size{{Width: 100, Height: 100}, {Width: 50, Height: 50, Crop: true}}
+	results, err := NewGenerator(fdfsClient, sizes...).Generate(ur.RemoteFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(sizes) {
+		t.Fatalf("expect %d results, actual %d", len(sizes), len(results))
+	}
+	expects := [][2]int{{100, 50}, {50, 50}}
+	for i, result := range results {
+		// 从文件ID为主文件ID加上前缀名
+		expectID := strings.TrimSuffix(ur.RemoteFileID, ".png") + sizes[i].Prefix() + ".png"
+		if result.FileID != expectID || result.Size != sizes[i] {
+			t.Errorf("expect slave %s, actual %s", expectID, result.FileID)
+		}
+		dr, err := fdfsClient.DownloadToBuffer(result.FileID, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := dr.Content.([]byte)
+		img, format, err := image.Decode(bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bounds := img.Bounds(); format != "png" || bounds.Dx() != expects[i][0] || bounds.Dy() != expects[i][1] {
+			t.Errorf("%s: expect png %dx%d, actual %s %dx%d", result.FileID, expects[i][0], expects[i][1], format, bounds.Dx(), bounds.Dy())
+		}
+	}
+
+	// 主文件不是图片
+	ur, err = fdfsClient.UploadByBuffer([]byte("not an image"), "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewGenerator(fdfsClient, sizes...).Generate(ur.RemoteFileID); err == nil {
+		t.Error("expect decode error")
+	}
+}